@@ -13,6 +13,9 @@ var Index string
 //go:embed base/head.html
 var BaseHead string
 
+//go:embed base/webhooks.html
+var BaseWebhooks string
+
 //go:embed admin/header.html
 var AdminHeader string
 
@@ -49,6 +52,15 @@ var UserSessions string
 //go:embed user/edit.html
 var UserEdit string
 
+//go:embed user/keys.html
+var UserKeys string
+
+//go:embed user/tokens.html
+var UserTokens string
+
+//go:embed user/webhooks.html
+var UserWebhooks string
+
 //go:embed repo/header.html
 var RepoHeader string
 
@@ -70,6 +82,12 @@ var RepoTree string
 //go:embed repo/file.html
 var RepoFile string
 
+//go:embed repo/blame.html
+var RepoBlame string
+
+//go:embed repo/compare.html
+var RepoCompare string
+
 //go:embed repo/refs.html
 var RepoRefs string
 
@@ -5,18 +5,19 @@ package admin
 
 import (
 	"fmt"
-	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/Jamozed/Goit/src/goit"
 	"github.com/Jamozed/Goit/src/util"
+	"github.com/dustin/go-humanize"
 )
 
 func HandleCron(w http.ResponseWriter, r *http.Request) {
 	auth, user, err := goit.Auth(w, r, true)
 	if err != nil {
-		log.Println("[/admin/cron]", err.Error())
+		util.Errorln("[/admin/cron]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
@@ -26,33 +27,83 @@ func HandleCron(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	type row struct{ Id, Repo, Schedule, Next, Last string }
+	type row struct {
+		Id, Name, Repo, Schedule, Next, Last, Result string
+		Failed                                       bool
+	}
+
+	type backupRow struct{ Name, Time, Size string }
+
 	data := struct {
-		Title string
-		Jobs  []row
-	}{Title: "Admin - Cron"}
+		Title             string
+		Jobs              []row
+		Backups           []backupRow
+		Schedule, Retains string
+	}{
+		Title:    "Admin - Cron",
+		Schedule: util.If(goit.Conf.BackupSchedule == "", "not scheduled", "scheduled at "+goit.Conf.BackupSchedule),
+		Retains:  "all",
+	}
 
 	for _, job := range goit.Cron.Jobs() {
 		repo := &goit.Repo{}
 
 		if job.Rid != -1 {
 			if r, err := goit.GetRepo(job.Rid); err != nil {
-				log.Println("[/admin/cron]", err.Error())
+				util.Errorln("[/admin/cron]", err.Error())
 			} else if r != nil {
 				repo = r
 			}
 		}
 
+		result := "-"
+		if job.Done {
+			result = util.If(job.Err != nil, "failed", "succeeded") + " in " + job.Duration.Round(time.Millisecond).String()
+			if job.Err != nil {
+				result += ": " + job.Err.Error()
+			}
+		}
+
 		data.Jobs = append(data.Jobs, row{
 			Id:       fmt.Sprint(job.Id),
+			Name:     job.Name,
 			Repo:     repo.Name,
 			Schedule: job.Schedule.String(),
 			Next:     job.Next.String(),
 			Last:     util.If(job.Last == time.Time{}, "never", job.Last.String()),
+			Result:   result,
+			Failed:   job.Err != nil,
+		})
+	}
+
+	var retains []string
+	if n := goit.Conf.BackupKeepLast; n > 0 {
+		retains = append(retains, fmt.Sprint("last ", n))
+	}
+	if n := goit.Conf.BackupKeepDaily; n > 0 {
+		retains = append(retains, fmt.Sprint(n, " daily"))
+	}
+	if n := goit.Conf.BackupKeepWeekly; n > 0 {
+		retains = append(retains, fmt.Sprint(n, " weekly"))
+	}
+	if len(retains) != 0 {
+		data.Retains = strings.Join(retains, ", ")
+	}
+
+	backups, err := goit.GetBackups()
+	if err != nil {
+		util.Errorln("[/admin/cron]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	for _, b := range backups {
+		data.Backups = append(data.Backups, backupRow{
+			Name: b.Name, Time: b.Time.String(), Size: humanize.IBytes(uint64(b.Size)),
 		})
 	}
 
 	if err := goit.Tmpl.ExecuteTemplate(w, "admin/cron", data); err != nil {
-		log.Println("[/admin/cron]", err.Error())
+		util.Errorln("[/admin/cron]", err.Error())
 	}
 }
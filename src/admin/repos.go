@@ -6,13 +6,9 @@ package admin
 import (
 	"fmt"
 	"html/template"
-	"log"
 	"net/http"
-	"slices"
 	"strconv"
-	"strings"
 
-	"github.com/Jamozed/Goit/src/cron"
 	"github.com/Jamozed/Goit/src/goit"
 	"github.com/Jamozed/Goit/src/util"
 	"github.com/dustin/go-humanize"
@@ -22,7 +18,7 @@ import (
 func HandleRepos(w http.ResponseWriter, r *http.Request) {
 	auth, user, err := goit.Auth(w, r, true)
 	if err != nil {
-		log.Println("[admin/repos]", err.Error())
+		util.Errorln("[admin/repos]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
@@ -40,7 +36,7 @@ func HandleRepos(w http.ResponseWriter, r *http.Request) {
 
 	repos, err := goit.GetRepos()
 	if err != nil {
-		log.Println("[/admin/repos]", err.Error())
+		util.Errorln("[/admin/repos]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
@@ -48,13 +44,13 @@ func HandleRepos(w http.ResponseWriter, r *http.Request) {
 	for _, r := range repos {
 		u, err := goit.GetUser(r.OwnerId)
 		if err != nil {
-			log.Println("[/admin/repos]", err.Error())
+			util.Errorln("[/admin/repos]", err.Error())
 			u = &goit.User{}
 		}
 
 		size, err := util.DirSize(goit.RepoPath(r.Name, true))
 		if err != nil {
-			log.Println("[/admin/repos]", err.Error())
+			util.Errorln("[/admin/repos]", err.Error())
 		}
 
 		data.Repos = append(data.Repos, row{
@@ -63,14 +59,14 @@ func HandleRepos(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := goit.Tmpl.ExecuteTemplate(w, "admin/repos", data); err != nil {
-		log.Println("[/admin/repos]", err.Error())
+		util.Errorln("[/admin/repos]", err.Error())
 	}
 }
 
 func HandleRepoEdit(w http.ResponseWriter, r *http.Request) {
 	auth, user, err := goit.Auth(w, r, true)
 	if err != nil {
-		log.Println("[/admin/repo/edit]", err.Error())
+		util.Errorln("[/admin/repo/edit]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
@@ -88,7 +84,7 @@ func HandleRepoEdit(w http.ResponseWriter, r *http.Request) {
 
 	repo, err := goit.GetRepo(id)
 	if err != nil {
-		log.Println("[/admin/repo/edit]", err.Error())
+		util.Errorln("[/admin/repo/edit]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	} else if repo == nil {
@@ -98,11 +94,11 @@ func HandleRepoEdit(w http.ResponseWriter, r *http.Request) {
 
 	owner, err := goit.GetUser(repo.OwnerId)
 	if err != nil {
-		log.Println("[/admin/repo/edit]", err.Error())
+		util.Errorln("[/admin/repo/edit]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	} else if owner == nil {
-		log.Println("[/admin/repo/edit]", repo.Id, "is owned by a nonexistent user")
+		util.Errorln("[/admin/repo/edit]", repo.Id, "is owned by a nonexistent user")
 		owner = &goit.User{}
 	}
 
@@ -146,51 +142,24 @@ func HandleRepoEdit(w http.ResponseWriter, r *http.Request) {
 			data.Edit.Visibility = r.FormValue("visibility")
 			data.Edit.IsMirror = r.FormValue("mirror") == "mirror"
 
-			if data.Edit.Name == "" {
-				data.Edit.Message = "Name cannot be empty"
-			} else if slices.Contains(goit.Reserved, data.Edit.Name) || !goit.IsLegal(data.Name) {
-				data.Edit.Message = "Name \"" + data.Edit.Name + "\" is illegal"
-			} else if exists, err := goit.RepoExists(data.Edit.Name); err != nil {
-				log.Println("[/admin/repo/edit]", err.Error())
+			edit := goit.Repo{
+				Name: data.Edit.Name, Description: data.Edit.Description, DefaultBranch: data.Edit.DefaultBranch,
+				Upstream: data.Edit.Upstream, Visibility: goit.VisibilityFromString(data.Edit.Visibility),
+				IsMirror: data.Edit.IsMirror,
+			}
+
+			if msg, err := goit.ValidateRepo(edit, repo); err != nil {
+				util.Errorln("[/admin/repo/edit]", err.Error())
 				goit.HttpError(w, http.StatusInternalServerError)
 				return
-			} else if exists && !strings.EqualFold(data.Edit.Name, repo.Name) {
-				data.Edit.Message = "Name \"" + data.Edit.Name + "\" is taken"
-			} else if len(data.Edit.Description) > 256 {
-				data.Edit.Message = "Description cannot exceed 256 characters"
-			} else if visibility := goit.VisibilityFromString(data.Edit.Visibility); visibility == -1 {
-				data.Edit.Message = "Visibility \"" + data.Edit.Visibility + "\" is invalid"
-			} else if err := goit.UpdateRepo(repo.Id, goit.Repo{
-				Name: data.Edit.Name, Description: data.Edit.Description, DefaultBranch: data.Edit.DefaultBranch,
-				Upstream: data.Edit.Upstream, Visibility: visibility, IsMirror: data.Edit.IsMirror,
-			}); err != nil {
-				log.Println("[/admin/repo/edit]", err.Error())
+			} else if msg != "" {
+				data.Edit.Message = msg
+			} else if err := goit.UpdateRepo(repo.Id, edit); err != nil {
+				util.Errorln("[/admin/repo/edit]", err.Error())
 				goit.HttpError(w, http.StatusInternalServerError)
 				return
 			} else {
-				if (data.Edit.Upstream == "" && repo.Upstream != "") || !data.Edit.IsMirror {
-					goit.Cron.RemoveFor(repo.Id)
-					goit.Cron.Update()
-				} else if data.Edit.Upstream != "" && data.Edit.IsMirror &&
-					(data.Edit.Upstream != repo.Upstream || !repo.IsMirror) {
-					goit.Cron.RemoveFor(repo.Id)
-
-					goit.Cron.Add(repo.Id, cron.Immediate, func() {
-						if err := goit.Pull(repo.Id); err != nil {
-							log.Println("[cron:import]", err.Error())
-						}
-						log.Println("[cron:import] imported", data.Name)
-					})
-
-					goit.Cron.Add(repo.Id, cron.Daily, func() {
-						if err := goit.Pull(repo.Id); err != nil {
-							log.Println("[cron:mirror]", err.Error())
-						}
-						log.Println("[cron:mirror] updated", data.Edit.Name)
-					})
-
-					goit.Cron.Update()
-				}
+				goit.RescheduleMirror(*repo, edit)
 
 				data.Edit.Message = "Repository \"" + repo.Name + "\" updated successfully"
 			}
@@ -201,17 +170,18 @@ func HandleRepoEdit(w http.ResponseWriter, r *http.Request) {
 			if data.Transfer.Owner == "" {
 				data.Transfer.Message = "New owner cannot be empty"
 			} else if u, err := goit.GetUserByName(data.Transfer.Owner); err != nil {
-				log.Println("[/admin/repo/edit]", err.Error())
+				util.Errorln("[/admin/repo/edit]", err.Error())
 				goit.HttpError(w, http.StatusInternalServerError)
 				return
 			} else if u == nil {
 				data.Transfer.Message = "User \"" + data.Transfer.Owner + "\" does not exist"
 			} else if err := goit.ChownRepo(repo.Id, u.Id); err != nil {
-				log.Println("[/admin/repo/edit]", err.Error())
+				util.Errorln("[/admin/repo/edit]", err.Error())
 				goit.HttpError(w, http.StatusInternalServerError)
 				return
 			} else {
-				log.Println("User", user.Id, "transferred repo", repo.Id, "ownership to", u.Id)
+				util.Infoln("[/admin/repo/edit] User", user.Id, "transferred repo", repo.Id, "ownership to", u.Id)
+				goit.TransferWebhooks(*repo, u.Id, user)
 				http.Redirect(w, r, "/admin/repo/edit?repo="+data.Edit.Id, http.StatusFound)
 				return
 			}
@@ -221,11 +191,16 @@ func HandleRepoEdit(w http.ResponseWriter, r *http.Request) {
 
 			if reponame != repo.Name {
 				data.Delete.Message = "Input does not match the repository name"
-			} else if err := goit.DelRepo(repo.Id); err != nil {
-				log.Println("[/admin/repo/edit]", err.Error())
-				goit.HttpError(w, http.StatusInternalServerError)
-				return
 			} else {
+				/* Webhooks are deleted with the repository, so dispatch to them first */
+				goit.DispatchWebhooks("delete", repo, user, nil, nil)
+
+				if err := goit.DelRepo(repo.Id); err != nil {
+					util.Errorln("[/admin/repo/edit]", err.Error())
+					goit.HttpError(w, http.StatusInternalServerError)
+					return
+				}
+
 				http.Redirect(w, r, "/admin/repos", http.StatusFound)
 				return
 			}
@@ -233,6 +208,6 @@ func HandleRepoEdit(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := goit.Tmpl.ExecuteTemplate(w, "admin/repo/edit", data); err != nil {
-		log.Println("[/admin/repo/edit]", err.Error())
+		util.Errorln("[/admin/repo/edit]", err.Error())
 	}
 }
@@ -5,7 +5,6 @@ package admin
 
 import (
 	"fmt"
-	"log"
 	"net/http"
 	"runtime"
 	"strings"
@@ -20,7 +19,7 @@ import (
 func HandleStatus(w http.ResponseWriter, r *http.Request) {
 	auth, user, err := goit.Auth(w, r, true)
 	if err != nil {
-		log.Println("[admin]", err.Error())
+		util.Errorln("[admin]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 	}
 
@@ -47,7 +46,7 @@ func HandleStatus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := goit.Tmpl.ExecuteTemplate(w, "admin/status", data); err != nil {
-		log.Println("[/admin/status]", err.Error())
+		util.Errorln("[/admin/status]", err.Error())
 	}
 }
 
@@ -6,11 +6,11 @@ package admin
 import (
 	"fmt"
 	"html/template"
-	"log"
 	"net/http"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Jamozed/Goit/src/goit"
 	"github.com/Jamozed/Goit/src/util"
@@ -20,7 +20,7 @@ import (
 func HandleUsers(w http.ResponseWriter, r *http.Request) {
 	auth, user, err := goit.Auth(w, r, true)
 	if err != nil {
-		log.Println("[admin/users]", err.Error())
+		util.Errorln("[admin/users]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
@@ -30,7 +30,7 @@ func HandleUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	type row struct{ Id, Name, FullName, IsAdmin string }
+	type row struct{ Id, Name, FullName, IsAdmin, Keys string }
 	data := struct {
 		Title string
 		Users []row
@@ -38,26 +38,33 @@ func HandleUsers(w http.ResponseWriter, r *http.Request) {
 
 	users, err := goit.GetUsers()
 	if err != nil {
-		log.Println("[admin/users]", err.Error())
+		util.Errorln("[admin/users]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	keys, err := goit.CountSshKeys()
+	if err != nil {
+		util.Errorln("[admin/users]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
 
 	for _, u := range users {
 		data.Users = append(data.Users, row{
-			fmt.Sprint(u.Id), u.Name, u.FullName, util.If(u.IsAdmin, "true", "false"),
+			fmt.Sprint(u.Id), u.Name, u.FullName, util.If(u.IsAdmin, "true", "false"), fmt.Sprint(keys[u.Id]),
 		})
 	}
 
 	if err := goit.Tmpl.ExecuteTemplate(w, "admin/users", data); err != nil {
-		log.Println("[/admin/users]", err.Error())
+		util.Errorln("[/admin/users]", err.Error())
 	}
 }
 
 func HandleUserCreate(w http.ResponseWriter, r *http.Request) {
 	auth, user, err := goit.Auth(w, r, true)
 	if err != nil {
-		log.Println("[admin/users]", err.Error())
+		util.Errorln("[admin/users]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
@@ -93,20 +100,20 @@ func HandleUserCreate(w http.ResponseWriter, r *http.Request) {
 		} else if slices.Contains(goit.Reserved, data.Form.Name) || !goit.IsLegal(data.Form.Name) {
 			data.Message = "Username \"" + data.Form.Name + "\" is illegal"
 		} else if exists, err := goit.UserExists(data.Form.Name); err != nil {
-			log.Println("[/admin/user/create]", err.Error())
+			util.Errorln("[/admin/user/create]", err.Error())
 			goit.HttpError(w, http.StatusInternalServerError)
 			return
 		} else if exists {
 			data.Message = "Username \"" + data.Form.Name + "\" is taken"
 		} else if salt, err := goit.Salt(); err != nil {
-			log.Println("[/admin/user/create]", err.Error())
+			util.Errorln("[/admin/user/create]", err.Error())
 			goit.HttpError(w, http.StatusInternalServerError)
 			return
 		} else if err := goit.CreateUser(goit.User{
 			Name: data.Form.Name, FullName: data.Form.FullName, Pass: goit.Hash(password, salt), PassAlgo: "argon2",
 			Salt: salt, IsAdmin: data.Form.IsAdmin,
 		}); err != nil {
-			log.Println("[/admin/user/create]", err.Error())
+			util.Errorln("[/admin/user/create]", err.Error())
 			goit.HttpError(w, http.StatusInternalServerError)
 			return
 		} else {
@@ -117,14 +124,14 @@ func HandleUserCreate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := goit.Tmpl.ExecuteTemplate(w, "admin/user/create", data); err != nil {
-		log.Println("[/admin/user/create]", err.Error())
+		util.Errorln("[/admin/user/create]", err.Error())
 	}
 }
 
 func HandleUserEdit(w http.ResponseWriter, r *http.Request) {
 	auth, user, err := goit.Auth(w, r, true)
 	if err != nil {
-		log.Println("[admin/users]", err.Error())
+		util.Errorln("[admin/users]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
@@ -142,7 +149,7 @@ func HandleUserEdit(w http.ResponseWriter, r *http.Request) {
 
 	u, err := goit.GetUser(uid)
 	if err != nil {
-		log.Println("[/admin/user/edit]", err.Error())
+		util.Errorln("[/admin/user/edit]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	} else if u == nil {
@@ -150,6 +157,7 @@ func HandleUserEdit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	type key struct{ Id, Name, Fingerprint, Created string }
 	data := struct {
 		Title, Message string
 
@@ -158,6 +166,8 @@ func HandleUserEdit(w http.ResponseWriter, r *http.Request) {
 			IsAdmin            bool
 		}
 
+		Keys []key
+
 		CsrfField template.HTML
 	}{
 		Title: "Admin - Edit User",
@@ -170,7 +180,19 @@ func HandleUserEdit(w http.ResponseWriter, r *http.Request) {
 	data.Form.FullName = u.FullName
 	data.Form.IsAdmin = u.IsAdmin
 
-	if r.Method == http.MethodPost {
+	if r.Method == http.MethodPost && r.FormValue("action") == "revoke" {
+		if kid, err := strconv.ParseInt(r.FormValue("key"), 10, 64); err != nil {
+			data.Message = "Key is invalid"
+		} else if err := goit.DelSshKey(u.Id, kid); err != nil {
+			util.Errorln("[/admin/user/edit]", err.Error())
+			goit.HttpError(w, http.StatusInternalServerError)
+			return
+		} else {
+			util.Infoln("[/admin/user/edit] User", user.Id, "revoked key", kid, "of user", u.Id)
+			http.Redirect(w, r, "/admin/user/edit?user="+data.Form.Id, http.StatusFound)
+			return
+		}
+	} else if r.Method == http.MethodPost {
 		data.Form.Name = strings.ToLower(r.FormValue("username"))
 		data.Form.FullName = r.FormValue("fullname")
 		password := r.FormValue("password")
@@ -181,7 +203,7 @@ func HandleUserEdit(w http.ResponseWriter, r *http.Request) {
 		} else if slices.Contains(goit.Reserved, data.Form.Name) && user.Id != 0 || !goit.IsLegal(data.Form.Name) {
 			data.Message = "Username \"" + data.Form.Name + "\" is illegal"
 		} else if exists, err := goit.UserExists(data.Form.Name); err != nil {
-			log.Println("[/admin/user/edit]", err.Error())
+			util.Errorln("[/admin/user/edit]", err.Error())
 			goit.HttpError(w, http.StatusInternalServerError)
 			return
 		} else if exists && data.Form.Name != u.Name {
@@ -190,14 +212,14 @@ func HandleUserEdit(w http.ResponseWriter, r *http.Request) {
 			if err := goit.UpdateUser(u.Id, goit.User{
 				Name: data.Form.Name, FullName: data.Form.FullName, IsAdmin: data.Form.IsAdmin,
 			}); err != nil {
-				log.Println("[/admin/user/edit]", err.Error())
+				util.Errorln("[/admin/user/edit]", err.Error())
 				goit.HttpError(w, http.StatusInternalServerError)
 				return
 			}
 
 			if password != "" {
 				if err := goit.UpdatePassword(u.Id, password); err != nil {
-					log.Println("[/admin/user/edit]", err.Error())
+					util.Errorln("[/admin/user/edit]", err.Error())
 					goit.HttpError(w, http.StatusInternalServerError)
 					return
 				}
@@ -207,7 +229,20 @@ func HandleUserEdit(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	keys, err := goit.GetSshKeys(u.Id)
+	if err != nil {
+		util.Errorln("[/admin/user/edit]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	for _, k := range keys {
+		data.Keys = append(data.Keys, key{
+			Id: fmt.Sprint(k.Id), Name: k.Name, Fingerprint: k.Fingerprint, Created: k.Created.Format(time.DateTime),
+		})
+	}
+
 	if err := goit.Tmpl.ExecuteTemplate(w, "admin/user/edit", data); err != nil {
-		log.Println("[/admin/user/edit]", err.Error())
+		util.Errorln("[/admin/user/edit]", err.Error())
 	}
 }
@@ -0,0 +1,107 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+/* Package api implements a versioned JSON API over repositories, users, and Git objects. */
+package api
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+
+	"github.com/Jamozed/Goit/src/goit"
+	"github.com/Jamozed/Goit/src/util"
+	"github.com/go-chi/chi/v5"
+)
+
+/* Create a router for version 1 of the API, to be mounted at /api/v1. */
+func Router() http.Handler {
+	r := chi.NewRouter()
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) { writeError(w, http.StatusNotFound, "Not found") })
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	})
+
+	r.Get("/user", HandleSelf)
+	r.Get("/users", HandleUsers)
+	r.Get("/users/{name}", HandleUser)
+	r.Get("/repos", HandleRepos)
+	r.Post("/repos", HandleRepoCreate)
+	r.HandleFunc("/repos/*", HandleRepo)
+
+	return r
+}
+
+/*
+Authenticate an API request with a session cookie, or with Basic authentication using a password or token. Returns the
+user and the scope of their credentials, or writes an error response and returns a nil user if the request should not
+continue. Anonymous requests are permitted, with a nil user and no access, only if anon is true.
+*/
+func auth(w http.ResponseWriter, r *http.Request, anon bool) (*goit.User, goit.Access, bool) {
+	if _, _, ok := r.BasicAuth(); ok {
+		user, scope, err := goit.BasicAuth(r)
+		if err != nil {
+			util.Errorln("[api]", err.Error())
+			writeError(w, http.StatusInternalServerError, "Internal server error")
+			return nil, goit.AccessNone, false
+		} else if user == nil {
+			w.Header().Set("WWW-Authenticate", "Basic realm=\"goit\"")
+			writeError(w, http.StatusUnauthorized, "Invalid credentials")
+			return nil, goit.AccessNone, false
+		}
+
+		return user, scope, true
+	}
+
+	if auth, user, err := goit.Auth(w, r, false); err != nil {
+		util.Errorln("[api]", err.Error())
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return nil, goit.AccessNone, false
+	} else if auth {
+		return user, goit.AccessAdmin, true
+	}
+
+	if !anon {
+		w.Header().Set("WWW-Authenticate", "Basic realm=\"goit\"")
+		writeError(w, http.StatusUnauthorized, "Authentication required")
+		return nil, goit.AccessNone, false
+	}
+
+	return nil, goit.AccessNone, true
+}
+
+/*
+Decode a JSON request body into v. Requiring a JSON content type also prevents cross-site form submissions from using a
+session cookie.
+*/
+func readJson(w http.ResponseWriter, r *http.Request, v any) bool {
+	if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+		return false
+	}
+
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024*1024)).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+		return false
+	}
+
+	return true
+}
+
+func writeJson(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+
+	if err := enc.Encode(v); err != nil {
+		util.Errorln("[api]", err.Error())
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJson(w, code, struct {
+		Error string `json:"error"`
+	}{msg})
+}
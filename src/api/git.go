@@ -0,0 +1,367 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/Jamozed/Goit/src/goit"
+	"github.com/Jamozed/Goit/src/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+type Signature struct {
+	Name  string    `json:"name"`
+	Email string    `json:"email"`
+	Date  time.Time `json:"date"`
+}
+
+type Commit struct {
+	Hash      string          `json:"hash"`
+	Author    Signature       `json:"author"`
+	Committer Signature       `json:"committer"`
+	Message   string          `json:"message"`
+	Parents   []string        `json:"parents"`
+	Stats     []goit.DiffStat `json:"stats,omitempty"`
+}
+
+type Ref struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Hash   string `json:"hash"`
+	Commit string `json:"commit"`
+}
+
+type TreeEntry struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Type string `json:"type"`
+	Mode string `json:"mode"`
+	Hash string `json:"hash"`
+	Size int64  `json:"size,omitempty"`
+}
+
+type Blob struct {
+	Path     string `json:"path"`
+	Mode     string `json:"mode"`
+	Hash     string `json:"hash"`
+	Size     int64  `json:"size"`
+	Encoding string `json:"encoding"`
+	Content  string `json:"content"`
+}
+
+func toCommit(c *object.Commit) Commit {
+	commit := Commit{
+		Hash:      c.Hash.String(),
+		Author:    Signature{c.Author.Name, c.Author.Email, c.Author.When},
+		Committer: Signature{c.Committer.Name, c.Committer.Email, c.Committer.When},
+		Message:   c.Message, Parents: []string{},
+	}
+
+	for _, h := range c.ParentHashes {
+		commit.Parents = append(commit.Parents, h.String())
+	}
+
+	return commit
+}
+
+func handleRefs(w http.ResponseWriter, r *http.Request, repo *goit.Repo) {
+	gr, err := git.PlainOpen(goit.RepoPath(repo.Name, true))
+	if err != nil {
+		util.Errorln("[/api/repo/refs]", err.Error())
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	iter, err := gr.References()
+	if err != nil {
+		util.Errorln("[/api/repo/refs]", err.Error())
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	refs := []Ref{}
+	if err := iter.ForEach(func(ref *plumbing.Reference) error {
+		var typ string
+		switch {
+		case ref.Name().IsBranch():
+			typ = "branch"
+		case ref.Name().IsTag():
+			typ = "tag"
+		default:
+			return nil
+		}
+
+		/* Peel annotated tags to the commit they point to */
+		commit := ref.Hash()
+		if tag, err := gr.TagObject(ref.Hash()); err == nil {
+			commit = tag.Target
+		} else if !errors.Is(err, plumbing.ErrObjectNotFound) {
+			return err
+		}
+
+		refs = append(refs, Ref{
+			Name: ref.Name().Short(), Type: typ, Hash: ref.Hash().String(), Commit: commit.String(),
+		})
+
+		return nil
+	}); err != nil {
+		util.Errorln("[/api/repo/refs]", err.Error())
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	writeJson(w, http.StatusOK, refs)
+}
+
+/* List the commits reachable from a ref, paginated by the "o" offset and "n" count query parameters. */
+func handleCommits(w http.ResponseWriter, r *http.Request, repo *goit.Repo) {
+	offset, count := int64(0), int64(100)
+
+	if o := r.URL.Query().Get("o"); o != "" {
+		if i, err := strconv.ParseInt(o, 10, 64); err != nil || i < 0 {
+			writeError(w, http.StatusBadRequest, "Invalid offset")
+			return
+		} else {
+			offset = i
+		}
+	}
+
+	if n := r.URL.Query().Get("n"); n != "" {
+		if i, err := strconv.ParseInt(n, 10, 64); err != nil || i < 1 || i > 1000 {
+			writeError(w, http.StatusBadRequest, "Invalid count")
+			return
+		} else {
+			count = i
+		}
+	}
+
+	gr, ref := openRef(w, r, repo)
+	if gr == nil {
+		return
+	}
+
+	commits := []Commit{}
+	if ref == nil {
+		writeJson(w, http.StatusOK, commits)
+		return
+	}
+
+	iter, err := gr.Log(&git.LogOptions{From: ref.Hash(), Order: git.LogOrderCommitterTime})
+	if err != nil {
+		util.Errorln("[/api/repo/commits]", err.Error())
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	defer iter.Close()
+
+	for i := int64(0); i < offset+count; i += 1 {
+		c, err := iter.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			util.Errorln("[/api/repo/commits]", err.Error())
+			writeError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		if i >= offset {
+			commits = append(commits, toCommit(c))
+		}
+	}
+
+	writeJson(w, http.StatusOK, commits)
+}
+
+func handleCommit(w http.ResponseWriter, r *http.Request, repo *goit.Repo, rev string) {
+	gr, err := git.PlainOpen(goit.RepoPath(repo.Name, true))
+	if err != nil {
+		util.Errorln("[/api/repo/commit]", err.Error())
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	ref, err := goit.ResolveRef(gr, rev)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		writeError(w, http.StatusNotFound, "Commit not found")
+		return
+	} else if err != nil {
+		util.Errorln("[/api/repo/commit]", err.Error())
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	c, err := gr.CommitObject(ref.Hash())
+	if err != nil {
+		util.Errorln("[/api/repo/commit]", err.Error())
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	commit := toCommit(c)
+	if commit.Stats, err = goit.DiffStats(c); err != nil {
+		util.Errorln("[/api/repo/commit]", err.Error())
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	writeJson(w, http.StatusOK, commit)
+}
+
+func handleTree(w http.ResponseWriter, r *http.Request, repo *goit.Repo, tpath string) {
+	tree := openTree(w, r, repo)
+	if tree == nil {
+		return
+	}
+
+	if tpath != "" {
+		var err error
+		if tree, err = tree.Tree(tpath); errors.Is(err, object.ErrDirectoryNotFound) {
+			writeError(w, http.StatusNotFound, "Directory not found")
+			return
+		} else if err != nil {
+			util.Errorln("[/api/repo/tree]", err.Error())
+			writeError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	entries := []TreeEntry{}
+	for _, e := range tree.Entries {
+		entry := TreeEntry{
+			Name: e.Name, Path: path.Join(tpath, e.Name), Type: "blob", Mode: util.ModeString(uint32(e.Mode)),
+			Hash: e.Hash.String(),
+		}
+
+		switch e.Mode {
+		case filemode.Dir:
+			entry.Type = "tree"
+		case filemode.Submodule:
+			entry.Type = "commit" /* The commit of the submodule is not in this repository */
+		default:
+			f, err := tree.TreeEntryFile(&e)
+			if err != nil {
+				util.Errorln("[/api/repo/tree]", err.Error())
+				writeError(w, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+
+			entry.Size = f.Size
+		}
+
+		entries = append(entries, entry)
+	}
+
+	writeJson(w, http.StatusOK, entries)
+}
+
+/* Return the content of a file, base64 encoded, up to the same 10 MiB limit as the file viewer. */
+func handleBlob(w http.ResponseWriter, r *http.Request, repo *goit.Repo, fpath string) {
+	tree := openTree(w, r, repo)
+	if tree == nil {
+		return
+	}
+
+	file, err := tree.File(fpath)
+	if errors.Is(err, object.ErrFileNotFound) {
+		writeError(w, http.StatusNotFound, "File not found")
+		return
+	} else if err != nil {
+		util.Errorln("[/api/repo/blob]", err.Error())
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if file.Size > 10*1024*1024 {
+		writeError(w, http.StatusRequestEntityTooLarge, "File exceeds 10 MiB")
+		return
+	}
+
+	rc, err := file.Blob.Reader()
+	if err != nil {
+		util.Errorln("[/api/repo/blob]", err.Error())
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	defer rc.Close()
+
+	buf, err := io.ReadAll(rc)
+	if err != nil {
+		util.Errorln("[/api/repo/blob]", err.Error())
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	writeJson(w, http.StatusOK, Blob{
+		Path: file.Name, Mode: util.ModeString(uint32(file.Mode)), Hash: file.Hash.String(), Size: file.Size,
+		Encoding: "base64", Content: base64.StdEncoding.EncodeToString(buf),
+	})
+}
+
+/*
+Open a repository and resolve the ref in the "ref" query parameter, or HEAD if it is empty. Returns a nil reference for
+an empty repository, or writes an error response and returns a nil repository if the request should not continue.
+*/
+func openRef(w http.ResponseWriter, r *http.Request, repo *goit.Repo) (*git.Repository, *plumbing.Reference) {
+	gr, err := git.PlainOpen(goit.RepoPath(repo.Name, true))
+	if err != nil {
+		util.Errorln("[/api/repo]", err.Error())
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return nil, nil
+	}
+
+	rev := r.URL.Query().Get("ref")
+
+	ref, err := goit.ResolveRef(gr, rev)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		if rev != "" {
+			writeError(w, http.StatusNotFound, "Ref not found")
+			return nil, nil
+		}
+
+		return gr, nil
+	} else if err != nil {
+		util.Errorln("[/api/repo]", err.Error())
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return nil, nil
+	}
+
+	return gr, ref
+}
+
+/* Open the root tree of the ref in the "ref" query parameter, writing an error response if it cannot be opened. */
+func openTree(w http.ResponseWriter, r *http.Request, repo *goit.Repo) *object.Tree {
+	gr, ref := openRef(w, r, repo)
+	if gr == nil {
+		return nil
+	} else if ref == nil {
+		writeError(w, http.StatusNotFound, "Repository is empty")
+		return nil
+	}
+
+	c, err := gr.CommitObject(ref.Hash())
+	if err != nil {
+		util.Errorln("[/api/repo]", err.Error())
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return nil
+	}
+
+	tree, err := c.Tree()
+	if err != nil {
+		util.Errorln("[/api/repo]", err.Error())
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return nil
+	}
+
+	return tree
+}
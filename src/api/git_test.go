@@ -0,0 +1,79 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/Jamozed/Goit/src/api"
+	"github.com/Jamozed/Goit/src/goit"
+)
+
+/* Run Git in a repository with input, returning its trimmed output. */
+func testGit(t *testing.T, repo, in string, args ...string) string {
+	t.Helper()
+
+	c := exec.Command("git", append([]string{"--git-dir", goit.RepoPath(repo, true)}, args...)...)
+	c.Env = append(c.Environ(), "GIT_AUTHOR_NAME=Goit", "GIT_AUTHOR_EMAIL=goit@example.com",
+		"GIT_COMMITTER_NAME=Goit", "GIT_COMMITTER_EMAIL=goit@example.com")
+	c.Stdin = strings.NewReader(in)
+
+	out, err := c.Output()
+	if err != nil {
+		t.Fatal("git", args, err.Error())
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+func TestTree(t *testing.T) {
+	tokens := newTestInstance(t)
+	newTestRepo(t, goit.AccessRead)
+
+	/* Commit a tree with a file, a directory, and a submodule whose commit is not in the repository */
+	blob := testGit(t, "proj", "hello\n", "hash-object", "-w", "--stdin")
+	dir := testGit(t, "proj", "100644 blob "+blob+"\tb.txt\n", "mktree")
+	sub := strings.Repeat("1", 40)
+	tree := testGit(t, "proj", "100644 blob "+blob+"\ta.txt\n040000 tree "+dir+"\tdir\n160000 commit "+sub+"\tsub\n",
+		"mktree")
+	commit := testGit(t, "proj", "", "commit-tree", tree, "-m", "Initial commit")
+	testGit(t, "proj", "", "update-ref", "refs/heads/master", commit)
+
+	w := request(t, http.MethodGet, "/repos/proj/tree", "bob", tokens[2][goit.AccessRead], "")
+	if w.Code != http.StatusOK {
+		t.Fatal("Expected status 200 got", w.Code, w.Body.String())
+	}
+
+	var entries []api.TreeEntry
+	if err := json.NewDecoder(w.Body).Decode(&entries); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	want := []api.TreeEntry{
+		{Name: "a.txt", Path: "a.txt", Type: "blob", Mode: "-rw-r--r--", Hash: blob, Size: 6},
+		{Name: "dir", Path: "dir", Type: "tree", Mode: "d---------", Hash: dir},
+		{Name: "sub", Path: "sub", Type: "commit", Mode: "d---------", Hash: sub},
+	}
+
+	if len(entries) != len(want) {
+		t.Fatal("Expected", want, "got", entries)
+	}
+
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Error("Expected", want[i], "got", entries[i])
+		}
+	}
+
+	t.Run("directory", func(t *testing.T) {
+		w := request(t, http.MethodGet, "/repos/proj/tree/dir", "bob", tokens[2][goit.AccessRead], "")
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"path": "dir/b.txt"`) {
+			t.Error("Expected the directory to be listed, got", w.Code, w.Body.String())
+		}
+	})
+}
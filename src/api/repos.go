@@ -0,0 +1,324 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package api
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/Jamozed/Goit/src/goit"
+	"github.com/Jamozed/Goit/src/util"
+	"github.com/go-chi/chi/v5"
+)
+
+/* The fields of a goit.Repo, with the owner and visibility by name. */
+type Repo struct {
+	Id            int64  `json:"id"`
+	Owner         string `json:"owner"`
+	Name          string `json:"name"`
+	Description   string `json:"description"`
+	DefaultBranch string `json:"default_branch"`
+	Upstream      string `json:"upstream"`
+	Visibility    string `json:"visibility"`
+	IsMirror      bool   `json:"is_mirror"`
+}
+
+func toRepo(r goit.Repo, owner string) Repo {
+	return Repo{
+		Id: r.Id, Owner: owner, Name: r.Name, Description: r.Description, DefaultBranch: r.DefaultBranch,
+		Upstream: r.Upstream, Visibility: r.Visibility.String(), IsMirror: r.IsMirror,
+	}
+}
+
+func HandleRepos(w http.ResponseWriter, r *http.Request) {
+	user, scope, ok := auth(w, r, true)
+	if !ok {
+		return
+	}
+
+	repos, err := goit.GetRepos()
+	if err != nil {
+		util.Errorln("[/api/repos]", err.Error())
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	users, err := goit.GetUsers()
+	if err != nil {
+		util.Errorln("[/api/repos]", err.Error())
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	owners := map[int64]string{}
+	for _, u := range users {
+		owners[u.Id] = u.Name
+	}
+
+	grants, err := goit.GetGrants(user)
+	if err != nil {
+		util.Errorln("[/api/repos]", err.Error())
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	res := []Repo{}
+	for _, repo := range repos {
+		if canRead(&repo, grants.RepoAccess(&repo, user != nil, user), scope) {
+			res = append(res, toRepo(repo, owners[repo.OwnerId]))
+		}
+	}
+
+	writeJson(w, http.StatusOK, res)
+}
+
+func HandleRepoCreate(w http.ResponseWriter, r *http.Request) {
+	user, scope, ok := auth(w, r, false)
+	if !ok {
+		return
+	} else if scope < goit.AccessWrite {
+		writeError(w, http.StatusForbidden, "Insufficient scope")
+		return
+	}
+
+	req := Repo{DefaultBranch: "master", Visibility: "public"}
+	if !readJson(w, r, &req) {
+		return
+	}
+
+	repo := goit.Repo{
+		OwnerId: user.Id, Name: req.Name, Description: req.Description, DefaultBranch: req.DefaultBranch,
+		Upstream: req.Upstream, Visibility: goit.VisibilityFromString(req.Visibility), IsMirror: req.IsMirror,
+	}
+
+	if msg, err := goit.ValidateRepo(repo, nil); err != nil {
+		util.Errorln("[/api/repos]", err.Error())
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	} else if msg != "" {
+		writeError(w, http.StatusUnprocessableEntity, msg)
+		return
+	}
+
+	rid, err := goit.CreateRepo(repo)
+	if err != nil {
+		util.Errorln("[/api/repos]", err.Error())
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if repo.Upstream != "" {
+		goit.ScheduleImport(rid, repo.Name, repo.IsMirror)
+	}
+
+	util.Infoln("[/api/repos] User", user.Id, "created repo", rid)
+
+	repo.Id = rid
+	goit.DispatchWebhooks("create", &repo, user, nil, nil)
+
+	writeJson(w, http.StatusCreated, toRepo(repo, user.Name))
+}
+
+/* Route a request under /repos/ to the handler for a repository or its Git objects. */
+func HandleRepo(w http.ResponseWriter, r *http.Request) {
+	user, scope, ok := auth(w, r, true)
+	if !ok {
+		return
+	}
+
+	repo, spath, err := findRepo(chi.URLParam(r, "*"))
+	if err != nil {
+		util.Errorln("[/api/repo]", err.Error())
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if repo == nil || !canRead(repo, goit.RepoAccess(repo, user != nil, user), scope) {
+		writeError(w, http.StatusNotFound, "Repository not found")
+		return
+	}
+
+	switch {
+	case spath == "":
+		switch r.Method {
+		case http.MethodGet:
+			owner, err := goit.GetUser(repo.OwnerId)
+			if err != nil {
+				util.Errorln("[/api/repo]", err.Error())
+				writeError(w, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+
+			writeJson(w, http.StatusOK, toRepo(*repo, ownerName(owner)))
+		case http.MethodPatch:
+			handleRepoUpdate(w, r, user, scope, repo)
+		case http.MethodDelete:
+			handleRepoDelete(w, r, user, scope, repo)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+
+	case r.Method != http.MethodGet:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+
+	case spath == "refs":
+		handleRefs(w, r, repo)
+	case spath == "commits":
+		handleCommits(w, r, repo)
+	case strings.HasPrefix(spath, "commits/"):
+		handleCommit(w, r, repo, strings.TrimPrefix(spath, "commits/"))
+	case spath == "tree", strings.HasPrefix(spath, "tree/"):
+		handleTree(w, r, repo, strings.TrimLeft(strings.TrimPrefix(spath, "tree"), "/"))
+	case strings.HasPrefix(spath, "blob/"):
+		handleBlob(w, r, repo, strings.TrimPrefix(spath, "blob/"))
+
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func handleRepoUpdate(w http.ResponseWriter, r *http.Request, user *goit.User, scope goit.Access, repo *goit.Repo) {
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, "Authentication required")
+		return
+	} else if goit.RepoAccess(repo, true, user) < goit.AccessAdmin {
+		writeError(w, http.StatusForbidden, "Insufficient access")
+		return
+	} else if scope < goit.AccessWrite {
+		writeError(w, http.StatusForbidden, "Insufficient scope")
+		return
+	}
+
+	owner, err := goit.GetUser(repo.OwnerId)
+	if err != nil {
+		util.Errorln("[/api/repo]", err.Error())
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	/* Fields that are omitted from the request are left unchanged */
+	req := toRepo(*repo, ownerName(owner))
+	if !readJson(w, r, &req) {
+		return
+	}
+
+	if req.Id != repo.Id {
+		writeError(w, http.StatusUnprocessableEntity, "Id cannot be changed")
+		return
+	}
+
+	edit := goit.Repo{
+		Name: req.Name, Description: req.Description, DefaultBranch: req.DefaultBranch, Upstream: req.Upstream,
+		Visibility: goit.VisibilityFromString(req.Visibility), IsMirror: req.IsMirror,
+	}
+
+	if msg, err := goit.ValidateRepo(edit, repo); err != nil {
+		util.Errorln("[/api/repo]", err.Error())
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	} else if msg != "" {
+		writeError(w, http.StatusUnprocessableEntity, msg)
+		return
+	}
+
+	/* Transferring ownership is restricted to the owner, authenticated by password or session rather than a token */
+	var newOwner *goit.User
+	if req.Owner != ownerName(owner) {
+		if repo.OwnerId != user.Id {
+			writeError(w, http.StatusForbidden, "Only the owner can transfer ownership")
+			return
+		} else if scope < goit.AccessAdmin {
+			writeError(w, http.StatusForbidden, "Insufficient scope")
+			return
+		}
+
+		if newOwner, err = goit.GetUserByName(req.Owner); err != nil {
+			util.Errorln("[/api/repo]", err.Error())
+			writeError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		} else if newOwner == nil {
+			writeError(w, http.StatusUnprocessableEntity, "User \""+req.Owner+"\" does not exist")
+			return
+		}
+	}
+
+	if err := goit.UpdateRepo(repo.Id, edit); err != nil {
+		util.Errorln("[/api/repo]", err.Error())
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	goit.RescheduleMirror(*repo, edit)
+	edit.Id, edit.OwnerId = repo.Id, repo.OwnerId
+
+	if newOwner != nil {
+		if err := goit.ChownRepo(repo.Id, newOwner.Id); err != nil {
+			util.Errorln("[/api/repo]", err.Error())
+			writeError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		util.Infoln("[/api/repo] User", user.Id, "transferred repo", repo.Id, "ownership to", newOwner.Id)
+		goit.TransferWebhooks(edit, newOwner.Id, user)
+		edit.OwnerId, owner = newOwner.Id, newOwner
+	}
+
+	writeJson(w, http.StatusOK, toRepo(edit, ownerName(owner)))
+}
+
+func handleRepoDelete(w http.ResponseWriter, r *http.Request, user *goit.User, scope goit.Access, repo *goit.Repo) {
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, "Authentication required")
+		return
+	} else if repo.OwnerId != user.Id {
+		writeError(w, http.StatusForbidden, "Only the owner can delete the repository")
+		return
+	} else if scope < goit.AccessAdmin /* A password or session, rather than a token */ {
+		writeError(w, http.StatusForbidden, "Insufficient scope")
+		return
+	}
+
+	/* Webhooks are deleted with the repository, so dispatch to them first */
+	goit.DispatchWebhooks("delete", repo, user, nil, nil)
+
+	if err := goit.DelRepo(repo.Id); err != nil {
+		util.Errorln("[/api/repo]", err.Error())
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	util.Infoln("[/api/repo] User", user.Id, "deleted repo", repo.Id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/* Check if a repository is public, or readable with a user's access within the scope of their credentials. */
+func canRead(repo *goit.Repo, access, scope goit.Access) bool {
+	return repo.Visibility == goit.Public || min(access, scope) >= goit.AccessRead
+}
+
+func ownerName(u *goit.User) string {
+	if u == nil {
+		return ""
+	}
+
+	return u.Name
+}
+
+/* Find the repository at the start of a path, returning it and the remainder of the path. */
+func findRepo(p string) (*goit.Repo, string, error) {
+	var rpath string
+	for _, part := range strings.Split(p, "/") {
+		rpath = path.Join(rpath, part)
+
+		repo, err := goit.GetRepoByName(rpath)
+		if err != nil {
+			return nil, "", err
+		} else if repo != nil {
+			return repo, strings.Trim(strings.TrimPrefix(p, rpath), "/"), nil
+		}
+	}
+
+	return nil, "", nil
+}
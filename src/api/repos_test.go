@@ -0,0 +1,285 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Jamozed/Goit/src/api"
+	"github.com/Jamozed/Goit/src/cron"
+	"github.com/Jamozed/Goit/src/goit"
+)
+
+/*
+Open an empty instance in a temporary data path with the users alice, bob, and carol, returning a credential with each
+scope for each user, indexed by user ID and then by scope. The credential with the admin scope is the user's password.
+*/
+func newTestInstance(t *testing.T) map[int64]map[goit.Access]string {
+	t.Helper()
+
+	goit.Conf.DataPath = t.TempDir()
+	goit.Cron = cron.New()
+
+	if err := goit.OpenDatabase(filepath.Join(goit.Conf.DataPath, "goit.db")); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tokens := map[int64]map[goit.Access]string{}
+	for i, name := range []string{"alice", "bob", "carol"} {
+		salt, err := goit.Salt()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		pass := name + "-password"
+		if err := goit.CreateUser(goit.User{Name: name, Pass: goit.Hash(pass, salt), Salt: salt}); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		uid := int64(i + 1)
+		tokens[uid] = map[goit.Access]string{goit.AccessAdmin: pass}
+
+		for _, scope := range []goit.Access{goit.AccessRead, goit.AccessWrite} {
+			_, token, err := goit.NewToken(uid, scope.String(), scope, time.Time{})
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			tokens[uid][scope] = token
+		}
+	}
+
+	return tokens
+}
+
+/* Send a request to the API as a user with a token, or anonymously if the user is empty. */
+func request(t *testing.T, method, target, user, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+
+	if user != "" {
+		r.SetBasicAuth(user, token)
+	}
+
+	w := httptest.NewRecorder()
+	api.Router().ServeHTTP(w, r)
+	return w
+}
+
+func TestRepoCreate(t *testing.T) {
+	tokens := newTestInstance(t)
+	alice := tokens[1]
+
+	tests := []struct {
+		name, user, token, body string
+		status                  int
+	}{
+		{"anonymous", "", "", `{"name": "proj"}`, http.StatusUnauthorized},
+		{"invalid token", "alice", "invalid", `{"name": "proj"}`, http.StatusUnauthorized},
+		{"read scope", "alice", alice[goit.AccessRead], `{"name": "proj"}`, http.StatusForbidden},
+		{"reserved name", "alice", alice[goit.AccessWrite], `{"name": "api"}`, http.StatusUnprocessableEntity},
+		{"reserved directory", "alice", alice[goit.AccessWrite], `{"name": "metrics/a"}`,
+			http.StatusUnprocessableEntity},
+		{"invalid visibility", "alice", alice[goit.AccessWrite], `{"name": "proj", "visibility": "secret"}`,
+			http.StatusUnprocessableEntity},
+		{"invalid json", "alice", alice[goit.AccessWrite], `{"name": `, http.StatusBadRequest},
+		{"created", "alice", alice[goit.AccessWrite], `{"name": "proj", "visibility": "private"}`,
+			http.StatusCreated},
+		{"taken", "alice", alice[goit.AccessWrite], `{"name": "PROJ"}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := request(t, http.MethodPost, "/repos", tt.user, tt.token, tt.body)
+			if w.Code != tt.status {
+				t.Error("Expected status", tt.status, "got", w.Code, w.Body.String())
+			}
+		})
+	}
+
+	t.Run("content type", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/repos", strings.NewReader(`{"name": "form"}`))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.SetBasicAuth("alice", alice[goit.AccessWrite])
+
+		w := httptest.NewRecorder()
+		api.Router().ServeHTTP(w, r)
+
+		if w.Code != http.StatusUnsupportedMediaType {
+			t.Error("Expected status 415 got", w.Code)
+		}
+	})
+
+	repo, err := goit.GetRepoByName("proj")
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if repo == nil {
+		t.Fatal("Repository was not created")
+	}
+
+	if repo.OwnerId != 1 || repo.Visibility != goit.Private || repo.DefaultBranch != "master" {
+		t.Error("Expected a private repository of alice on master, got", repo)
+	}
+
+	if _, err := os.Stat(goit.RepoPath("proj", true)); err != nil {
+		t.Error("Expected the repository to be initialised, got", err)
+	}
+}
+
+/* Create a private repository of alice with bob as a collaborator with an access level. */
+func newTestRepo(t *testing.T, access goit.Access) *goit.Repo {
+	t.Helper()
+
+	rid, err := goit.CreateRepo(goit.Repo{OwnerId: 1, Name: "proj", DefaultBranch: "master", Visibility: goit.Private})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := goit.SetCollaborator(rid, 2, access); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	repo, err := goit.GetRepo(rid)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return repo
+}
+
+func TestRepoUpdate(t *testing.T) {
+	tokens := newTestInstance(t)
+	newTestRepo(t, goit.AccessWrite)
+
+	tests := []struct {
+		name, user, token, body string
+		status                  int
+	}{
+		{"anonymous", "", "", `{"description": "Changed"}`, http.StatusNotFound},
+		{"no access", "carol", tokens[3][goit.AccessWrite], `{"description": "Changed"}`, http.StatusNotFound},
+		{"write access", "bob", tokens[2][goit.AccessWrite], `{"description": "Changed"}`, http.StatusForbidden},
+		{"read scope", "alice", tokens[1][goit.AccessRead], `{"description": "Changed"}`, http.StatusForbidden},
+		{"id", "alice", tokens[1][goit.AccessWrite], `{"id": 2}`, http.StatusUnprocessableEntity},
+		{"invalid name", "alice", tokens[1][goit.AccessWrite], `{"name": "api"}`, http.StatusUnprocessableEntity},
+		{"updated", "alice", tokens[1][goit.AccessWrite], `{"description": "Changed", "visibility": "public"}`,
+			http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := request(t, http.MethodPatch, "/repos/proj", tt.user, tt.token, tt.body)
+			if w.Code != tt.status {
+				t.Error("Expected status", tt.status, "got", w.Code, w.Body.String())
+			}
+		})
+	}
+
+	repo, err := goit.GetRepoByName("proj")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	/* Omitted fields are left unchanged */
+	if repo.Description != "Changed" || repo.Visibility != goit.Public || repo.DefaultBranch != "master" {
+		t.Error("Expected only the description and visibility to change, got", repo)
+	}
+
+	t.Run("rename", func(t *testing.T) {
+		w := request(t, http.MethodPatch, "/repos/proj", "alice", tokens[1][goit.AccessWrite], `{"name": "group/proj"}`)
+		if w.Code != http.StatusOK {
+			t.Fatal("Expected status 200 got", w.Code, w.Body.String())
+		}
+
+		w = request(t, http.MethodGet, "/repos/group/proj", "", "", "")
+		if w.Code != http.StatusOK {
+			t.Error("Expected the renamed repository to be found, got", w.Code)
+		}
+	})
+}
+
+func TestRepoTransfer(t *testing.T) {
+	tokens := newTestInstance(t)
+	newTestRepo(t, goit.AccessAdmin)
+
+	tests := []struct {
+		name, user, token, owner string
+		status                   int
+	}{
+		{"collaborator", "bob", tokens[2][goit.AccessAdmin], "bob", http.StatusForbidden},
+		{"write scope", "alice", tokens[1][goit.AccessWrite], "carol", http.StatusForbidden},
+		{"unknown user", "alice", tokens[1][goit.AccessAdmin], "dave", http.StatusUnprocessableEntity},
+		{"transferred", "alice", tokens[1][goit.AccessAdmin], "carol", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := request(t, http.MethodPatch, "/repos/proj", tt.user, tt.token, `{"owner": "`+tt.owner+`"}`)
+			if w.Code != tt.status {
+				t.Error("Expected status", tt.status, "got", w.Code, w.Body.String())
+			}
+
+			if w.Code == http.StatusOK {
+				var res api.Repo
+				if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+					t.Fatal(err.Error())
+				} else if res.Owner != tt.owner {
+					t.Error("Expected owner", tt.owner, "got", res.Owner)
+				}
+			}
+		})
+	}
+
+	if repo, err := goit.GetRepoByName("proj"); err != nil {
+		t.Fatal(err.Error())
+	} else if repo.OwnerId != 3 {
+		t.Error("Expected carol to own the repository, got", repo.OwnerId)
+	}
+}
+
+func TestRepoDelete(t *testing.T) {
+	tokens := newTestInstance(t)
+	newTestRepo(t, goit.AccessAdmin)
+
+	tests := []struct {
+		name, user, token string
+		status            int
+	}{
+		{"anonymous", "", "", http.StatusNotFound},
+		{"collaborator", "bob", tokens[2][goit.AccessAdmin], http.StatusForbidden},
+		{"read scope", "alice", tokens[1][goit.AccessRead], http.StatusForbidden},
+		{"write scope", "alice", tokens[1][goit.AccessWrite], http.StatusForbidden},
+		{"deleted", "alice", tokens[1][goit.AccessAdmin], http.StatusNoContent},
+		{"not found", "alice", tokens[1][goit.AccessAdmin], http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := request(t, http.MethodDelete, "/repos/proj", tt.user, tt.token, "")
+			if w.Code != tt.status {
+				t.Error("Expected status", tt.status, "got", w.Code, w.Body.String())
+			}
+		})
+	}
+
+	if repo, err := goit.GetRepoByName("proj"); err != nil {
+		t.Fatal(err.Error())
+	} else if repo != nil {
+		t.Error("Expected the repository to be deleted")
+	}
+
+	if _, err := os.Stat(goit.RepoPath("proj", true)); !os.IsNotExist(err) {
+		t.Error("Expected the repository directory to be removed, got", err)
+	}
+}
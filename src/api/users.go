@@ -0,0 +1,71 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/Jamozed/Goit/src/goit"
+	"github.com/Jamozed/Goit/src/util"
+	"github.com/go-chi/chi/v5"
+)
+
+/* The public fields of a goit.User. */
+type User struct {
+	Id       int64  `json:"id"`
+	Name     string `json:"name"`
+	FullName string `json:"name_full"`
+	IsAdmin  bool   `json:"is_admin"`
+}
+
+func toUser(u goit.User) User {
+	return User{Id: u.Id, Name: u.Name, FullName: u.FullName, IsAdmin: u.IsAdmin}
+}
+
+func HandleSelf(w http.ResponseWriter, r *http.Request) {
+	user, _, ok := auth(w, r, false)
+	if !ok {
+		return
+	}
+
+	writeJson(w, http.StatusOK, toUser(*user))
+}
+
+func HandleUsers(w http.ResponseWriter, r *http.Request) {
+	if _, _, ok := auth(w, r, false); !ok {
+		return
+	}
+
+	users, err := goit.GetUsers()
+	if err != nil {
+		util.Errorln("[/api/users]", err.Error())
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	res := []User{}
+	for _, u := range users {
+		res = append(res, toUser(u))
+	}
+
+	writeJson(w, http.StatusOK, res)
+}
+
+func HandleUser(w http.ResponseWriter, r *http.Request) {
+	if _, _, ok := auth(w, r, false); !ok {
+		return
+	}
+
+	user, err := goit.GetUserByName(chi.URLParam(r, "name"))
+	if err != nil {
+		util.Errorln("[/api/user]", err.Error())
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	} else if user == nil {
+		writeError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	writeJson(w, http.StatusOK, toUser(*user))
+}
@@ -4,40 +4,64 @@
 package cron
 
 import (
-	"log"
+	"context"
 	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/Jamozed/Goit/src/metrics"
 	"github.com/Jamozed/Goit/src/util"
 )
 
 type Cron struct {
 	jobs    []Job
 	stop    chan struct{}
+	done    chan struct{}
 	update  chan struct{}
 	running atomic.Bool
 	mutex   sync.Mutex
 	lastId  uint64
 	waiter  sync.WaitGroup
+
+	results map[uint64]result
+	rmutex  sync.Mutex
 }
 
 type Job struct {
 	Id         uint64
 	Rid        int64
+	Name       string
 	Schedule   Schedule
 	Next, Last time.Time
-	fn         func()
+
+	/* The duration and error of the last completed run, if any */
+	Done     bool
+	Duration time.Duration
+	Err      error
+
+	fn func() error
+}
+
+type result struct {
+	duration time.Duration
+	err      error
 }
 
 const maxDuration time.Duration = 1<<63 - 1
 
+var (
+	jobRuns     = metrics.NewCounter("goit_cron_runs_total", "Runs of cron jobs.", "job")
+	jobFailures = metrics.NewCounter("goit_cron_failures_total", "Runs of cron jobs that failed.", "job")
+)
+
 func New() *Cron {
 	return &Cron{
-		jobs:   []Job{},
-		stop:   make(chan struct{}),
-		update: make(chan struct{}),
+		jobs:    []Job{},
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		update:  make(chan struct{}),
+		results: map[uint64]result{},
 	}
 }
 
@@ -74,7 +98,7 @@ func (c *Cron) Start() {
 			select {
 			case now := <-timer.C:
 				now = now.UTC()
-				log.Println("[cron] timer expired")
+				util.Infoln("[cron] timer expired")
 
 				c.mutex.Lock()
 				util.Debugln("[cron.now] Cron mutex lock")
@@ -86,13 +110,27 @@ func (c *Cron) Start() {
 						continue
 					}
 
-					log.Println("[cron] running job", job.Id, job.Rid)
+					util.Infoln("[cron] running job", job.Id, job.Name, "for", job.Rid)
 
 					j := job
 					c.waiter.Add(1)
 					go func() {
 						defer c.waiter.Done()
-						j.fn()
+
+						t1 := time.Now()
+						err := j.fn()
+						if err != nil {
+							util.Errorln("[cron] job", j.Id, j.Name, "for", j.Rid, "failed:", err.Error())
+							jobFailures.Inc(j.Name)
+						}
+
+						jobRuns.Inc(j.Name)
+
+						if !j.Schedule.IsImmediate() {
+							c.rmutex.Lock()
+							c.results[j.Id] = result{time.Since(t1), err}
+							c.rmutex.Unlock()
+						}
 					}()
 
 					if !job.Schedule.IsImmediate() {
@@ -121,6 +159,7 @@ func (c *Cron) Start() {
 				util.Debugln("[cron.stop] Cron mutex unlock")
 				c.mutex.Unlock()
 
+				close(c.done)
 				return
 
 			case <-c.update:
@@ -138,12 +177,32 @@ func (c *Cron) Stop() {
 	close(c.stop)
 }
 
+/* Stop running jobs on schedule and wait for any running jobs to finish, or for a context to be done. */
+func (c *Cron) Shutdown(ctx context.Context) error {
+	if !c.running.Load() {
+		return nil
+	}
+
+	c.Stop()
+
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (c *Cron) Update() {
 	if !c.running.Load() {
 		return
 	}
 
-	c.update <- struct{}{}
+	/* The scheduler no longer receives updates once it has been stopped */
+	select {
+	case c.update <- struct{}{}:
+	case <-c.stop:
+	}
 }
 
 func (c *Cron) _update() {
@@ -166,10 +225,21 @@ func (c *Cron) Jobs() []Job {
 
 	jobs := make([]Job, len(c.jobs))
 	copy(jobs, c.jobs)
+
+	c.rmutex.Lock()
+	defer c.rmutex.Unlock()
+
+	for i := range jobs {
+		if r, ok := c.results[jobs[i].Id]; ok {
+			jobs[i].Done, jobs[i].Duration, jobs[i].Err = true, r.duration, r.err
+		}
+	}
+
 	return jobs
 }
 
-func (c *Cron) Add(rid int64, schedule Schedule, fn func()) uint64 {
+/* Add a job for a repository, or -1 for none, that is run on a schedule. Errors are logged and kept as its result. */
+func (c *Cron) Add(rid int64, name string, schedule Schedule, fn func() error) uint64 {
 	c.mutex.Lock()
 	util.Debugln("[cron.Add] Cron mutex lock")
 	defer c.mutex.Unlock()
@@ -177,11 +247,11 @@ func (c *Cron) Add(rid int64, schedule Schedule, fn func()) uint64 {
 
 	c.lastId += 1
 
-	job := Job{Id: c.lastId, Rid: rid, Schedule: schedule, fn: fn}
+	job := Job{Id: c.lastId, Rid: rid, Name: name, Schedule: schedule, fn: fn}
 	job.Next = job.Schedule.Next(time.Now().UTC())
 	c.jobs = append(c.jobs, job)
 
-	log.Println("[cron] added job", job.Id, "for", job.Rid)
+	util.Infoln("[cron] added job", job.Id, job.Name, "for", job.Rid)
 	return job.Id
 }
 
@@ -191,12 +261,16 @@ func (c *Cron) RemoveFor(rid int64) {
 	defer c.mutex.Unlock()
 	defer util.Debugln("[cron.RemoveFor] Cron mutex unlock")
 
+	c.rmutex.Lock()
+	defer c.rmutex.Unlock()
+
 	tmp := c.jobs[:0]
 	for _, job := range c.jobs {
 		if job.Rid != rid {
 			tmp = append(tmp, job)
 		} else {
-			log.Println("[cron] removing job", job.Id, "for", job.Rid)
+			util.Infoln("[cron] removing job", job.Id, job.Name, "for", job.Rid)
+			delete(c.results, job.Id)
 		}
 	}
 
@@ -4,7 +4,10 @@
 package cron
 
 import (
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Jamozed/Goit/src/util"
@@ -115,3 +118,48 @@ func (s Schedule) String() string {
 		util.If(s.Second == -1, "*", fmt.Sprint(s.Second)),
 	)
 }
+
+/*
+Parse a schedule in the format produced by String, as month, day, weekday, hour, minute, and second, where an asterisk
+represents "any". The names yearly, monthly, weekly, daily, hourly, and minutely are also accepted.
+*/
+func ParseSchedule(s string) (Schedule, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "yearly":
+		return Yearly, nil
+	case "monthly":
+		return Monthly, nil
+	case "weekly":
+		return Weekly, nil
+	case "daily":
+		return Daily, nil
+	case "hourly":
+		return Hourly, nil
+	case "minutely":
+		return Minutely, nil
+	}
+
+	fields := strings.Fields(s)
+	if len(fields) != 6 {
+		return Schedule{}, errors.New("schedule must have six fields")
+	}
+
+	limits := [6][2]int64{{1, 12}, {1, 31}, {0, 6}, {0, 23}, {0, 59}, {0, 59}}
+	values := [6]int64{}
+
+	for i, f := range fields {
+		if f == "*" {
+			values[i] = -1
+			continue
+		}
+
+		v, err := strconv.ParseInt(f, 10, 64)
+		if err != nil || v < limits[i][0] || v > limits[i][1] {
+			return Schedule{}, fmt.Errorf("invalid schedule field \"%s\"", f)
+		}
+
+		values[i] = v
+	}
+
+	return Schedule{values[0], values[1], values[2], values[3], values[4], values[5]}, nil
+}
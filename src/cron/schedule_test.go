@@ -132,3 +132,44 @@ func TestNext(t *testing.T) {
 		}
 	})
 }
+
+func TestParseSchedule(t *testing.T) {
+	t.Run("Fields", func(t *testing.T) {
+		expected := cron.Schedule{-1, -1, 0, 3, 30, 0}
+
+		r, err := cron.ParseSchedule("* * 0 3 30 0")
+		if err != nil {
+			t.Error(err)
+		} else if r != expected {
+			t.Error("Expected", expected, "got", r)
+		}
+	})
+
+	t.Run("Name", func(t *testing.T) {
+		r, err := cron.ParseSchedule("daily")
+		if err != nil {
+			t.Error(err)
+		} else if r != cron.Daily {
+			t.Error("Expected", cron.Daily, "got", r)
+		}
+	})
+
+	t.Run("Round Trip", func(t *testing.T) {
+		schedule := cron.Schedule{3, 6, 2, 6, 45, 15}
+
+		r, err := cron.ParseSchedule(schedule.String())
+		if err != nil {
+			t.Error(err)
+		} else if r != schedule {
+			t.Error("Expected", schedule, "got", r)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, s := range []string{"", "* * * * *", "13 * * * * *", "* * * 24 * *", "* * * a * *"} {
+			if _, err := cron.ParseSchedule(s); err == nil {
+				t.Error("Expected an error for", s)
+			}
+		}
+	})
+}
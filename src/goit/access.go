@@ -0,0 +1,173 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package goit
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/Jamozed/Goit/src/util"
+)
+
+type Access int32
+
+const (
+	AccessNone  Access = 0
+	AccessRead  Access = 1
+	AccessWrite Access = 2
+	AccessAdmin Access = 3
+)
+
+type Collaborator struct {
+	RepoId int64  `json:"repo_id"`
+	UserId int64  `json:"user_id"`
+	Access Access `json:"access"`
+}
+
+func AccessFromString(s string) Access {
+	switch strings.ToLower(s) {
+	case "none":
+		return AccessNone
+	case "read":
+		return AccessRead
+	case "write":
+		return AccessWrite
+	case "admin":
+		return AccessAdmin
+	default:
+		return -1
+	}
+}
+
+func (a Access) String() string {
+	names := [...]string{"none", "read", "write", "admin"}
+	if a < 0 || int(a) >= len(names) {
+		return "unknown"
+	}
+
+	return names[a]
+}
+
+/* Get the access level of a user to a repository, where owners have admin access. */
+func RepoAccess(repo *Repo, auth bool, user *User) Access {
+	grants := Grants{}
+	if auth {
+		var a Access
+		if err := db.QueryRow(
+			"SELECT access FROM collaborators WHERE repo_id = ? AND user_id = ?", repo.Id, user.Id,
+		).Scan(&a); err == nil {
+			grants[repo.Id] = a
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			util.Errorln("[access]", err.Error())
+		}
+	}
+
+	return grants.RepoAccess(repo, auth, user)
+}
+
+/*
+The access granted to a user as a collaborator, by repository ID. Grants are loaded once to check the access of a user
+to many repositories, such as when listing them.
+*/
+type Grants map[int64]Access
+
+/* Get the access granted to a user as a collaborator of each repository, which is none if the user is nil. */
+func GetGrants(user *User) (Grants, error) {
+	grants := Grants{}
+	if user == nil {
+		return grants, nil
+	}
+
+	rows, err := db.Query("SELECT repo_id, access FROM collaborators WHERE user_id = ?", user.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var rid int64
+		var a Access
+		if err := rows.Scan(&rid, &a); err != nil {
+			return nil, err
+		}
+
+		grants[rid] = a
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return grants, nil
+}
+
+/* Get the access level of a user to a repository from the grants of the user. */
+func (g Grants) RepoAccess(repo *Repo, auth bool, user *User) Access {
+	if auth && user.Id == repo.OwnerId {
+		return AccessAdmin
+	}
+
+	access := AccessNone
+	if repo.Visibility == Public || (repo.Visibility == Limited && auth) {
+		access = AccessRead
+	}
+
+	if auth {
+		access = max(access, g[repo.Id])
+	}
+
+	return access
+}
+
+/* Check if a repository is visible to a user from the grants of the user. */
+func (g Grants) IsVisible(repo *Repo, auth bool, user *User) bool {
+	return g.RepoAccess(repo, auth, user) >= AccessRead
+}
+
+func GetCollaborators(rid int64) ([]Collaborator, error) {
+	collabs := []Collaborator{}
+
+	rows, err := db.Query("SELECT repo_id, user_id, access FROM collaborators WHERE repo_id = ?", rid)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		c := Collaborator{}
+		if err := rows.Scan(&c.RepoId, &c.UserId, &c.Access); err != nil {
+			return nil, err
+		}
+
+		collabs = append(collabs, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return collabs, nil
+}
+
+/* Grant a user access to a repository, removing them as a collaborator if access is none. */
+func SetCollaborator(rid, uid int64, access Access) error {
+	if access == AccessNone {
+		if _, err := db.Exec("DELETE FROM collaborators WHERE repo_id = ? AND user_id = ?", rid, uid); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	if _, err := db.Exec(
+		"INSERT OR REPLACE INTO collaborators (repo_id, user_id, access) VALUES (?, ?, ?)", rid, uid, access,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
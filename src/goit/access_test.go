@@ -0,0 +1,69 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package goit_test
+
+import (
+	"testing"
+
+	"github.com/Jamozed/Goit/src/goit"
+)
+
+func TestGrants(t *testing.T) {
+	newTestInstance(t)
+
+	for _, name := range []string{"alice", "bob", "carol"} {
+		if err := goit.CreateUser(goit.User{Name: name, Pass: []byte{}, Salt: []byte{}}); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	var repos []goit.Repo
+	for _, r := range []goit.Repo{
+		{OwnerId: 1, Name: "public", Visibility: goit.Public},
+		{OwnerId: 1, Name: "private", Visibility: goit.Private},
+		{OwnerId: 1, Name: "limited", Visibility: goit.Limited},
+	} {
+		r.DefaultBranch = "master"
+		rid, err := goit.CreateRepo(r)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		r.Id = rid
+		repos = append(repos, r)
+	}
+
+	if err := goit.SetCollaborator(repos[1].Id, 2, goit.AccessWrite); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := goit.SetCollaborator(repos[2].Id, 2, goit.AccessAdmin); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	users := []*goit.User{nil, {Id: 1, Name: "alice"}, {Id: 2, Name: "bob"}, {Id: 3, Name: "carol"}}
+	want := [][]goit.Access{
+		{goit.AccessRead, goit.AccessNone, goit.AccessNone},
+		{goit.AccessAdmin, goit.AccessAdmin, goit.AccessAdmin},
+		{goit.AccessRead, goit.AccessWrite, goit.AccessAdmin},
+		{goit.AccessRead, goit.AccessNone, goit.AccessRead},
+	}
+
+	for i, user := range users {
+		grants, err := goit.GetGrants(user)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		for j, repo := range repos {
+			if a := grants.RepoAccess(&repo, user != nil, user); a != want[i][j] {
+				t.Error("User", i, "repository", repo.Name, "expected", want[i][j], "got", a)
+			}
+
+			if a := goit.RepoAccess(&repo, user != nil, user); a != want[i][j] {
+				t.Error("User", i, "repository", repo.Name, "expected", want[i][j], "got", a)
+			}
+		}
+	}
+}
@@ -0,0 +1,387 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package goit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Jamozed/Goit/src/util"
+	"github.com/klauspost/compress/zstd"
+)
+
+/* The format of a backup archive. */
+type BackupFormat int32
+
+const (
+	BackupZip        BackupFormat = 0
+	BackupZipDeflate BackupFormat = 1
+	BackupTarGz      BackupFormat = 2
+	BackupTarZst     BackupFormat = 3
+)
+
+func BackupFormatFromString(s string) BackupFormat {
+	switch strings.ToLower(s) {
+	case "zip":
+		return BackupZip
+	case "zip-deflate":
+		return BackupZipDeflate
+	case "tar.gz":
+		return BackupTarGz
+	case "tar.zst":
+		return BackupTarZst
+	default:
+		return -1
+	}
+}
+
+func (f BackupFormat) String() string {
+	names := [...]string{"zip", "zip-deflate", "tar.gz", "tar.zst"}
+	if f < 0 || int(f) >= len(names) {
+		return "unknown"
+	}
+
+	return names[f]
+}
+
+/* The file extension of archives of a format, or an empty string if the format is unknown. */
+func (f BackupFormat) Ext() string {
+	exts := [...]string{".zip", ".zip", ".tar.gz", ".tar.zst"}
+	if f < 0 || int(f) >= len(exts) {
+		return ""
+	}
+
+	return exts[f]
+}
+
+/* A writer of the entries of a backup archive, which are written in order without seeking. */
+type archiveWriter interface {
+	Dir(name string, mod time.Time) error
+	File(name string, size int64, mod time.Time, r io.Reader) error
+	Close() error
+}
+
+/* Create an archive writer of a format, writing to w. Closing the archive writer does not close w. */
+func newArchiveWriter(w io.Writer, format BackupFormat) (archiveWriter, error) {
+	switch format {
+	case BackupZip, BackupZipDeflate:
+		return &zipArchive{zip.NewWriter(w), util.If(format == BackupZip, zip.Store, zip.Deflate)}, nil
+	case BackupTarGz:
+		gw := gzip.NewWriter(w)
+		return &tarArchive{tar.NewWriter(gw), gw}, nil
+	case BackupTarZst:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+
+		return &tarArchive{tar.NewWriter(zw), zw}, nil
+	default:
+		return nil, fmt.Errorf("invalid backup format %d", format)
+	}
+}
+
+type zipArchive struct {
+	zw     *zip.Writer
+	method uint16
+}
+
+func (a *zipArchive) Dir(name string, mod time.Time) error {
+	_, err := a.zw.CreateHeader(&zip.FileHeader{Name: name + "/", Modified: mod})
+	return err
+}
+
+func (a *zipArchive) File(name string, size int64, mod time.Time, r io.Reader) error {
+	head := &zip.FileHeader{Name: name, Method: a.method, Modified: mod}
+	head.SetMode(0o644)
+
+	w, err := a.zw.CreateHeader(head)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.CopyN(w, r, size)
+	return err
+}
+
+func (a *zipArchive) Close() error {
+	return a.zw.Close()
+}
+
+type tarArchive struct {
+	tw *tar.Writer
+	cw io.WriteCloser
+}
+
+func (a *tarArchive) Dir(name string, mod time.Time) error {
+	return a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir, Name: name + "/", Mode: 0o755, ModTime: mod, Format: tar.FormatPAX,
+	})
+}
+
+func (a *tarArchive) File(name string, size int64, mod time.Time, r io.Reader) error {
+	if err := a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg, Name: name, Size: size, Mode: 0o644, ModTime: mod, Format: tar.FormatPAX,
+	}); err != nil {
+		return err
+	}
+
+	_, err := io.CopyN(a.tw, r, size)
+	return err
+}
+
+func (a *tarArchive) Close() error {
+	if err := a.tw.Close(); err != nil {
+		a.cw.Close()
+		return err
+	}
+
+	return a.cw.Close()
+}
+
+/* Add a file from disk to an archive. */
+func archiveFile(aw archiveWriter, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	return aw.File(name, info.Size(), info.ModTime(), f)
+}
+
+func archiveBytes(aw archiveWriter, name string, b []byte) error {
+	return aw.File(name, int64(len(b)), time.Now(), bytes.NewReader(b))
+}
+
+/*
+Open a backup archive of any format as a file system, detecting the format from its content. ZIP archives are read in
+place, while TAR archives are extracted to a temporary directory in the data path, which is removed when the returned
+close function is called.
+*/
+func openArchive(archive string) (fs.FS, func() error, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	tr, done, err := openTar(f)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if tr == nil {
+		zr, err := zip.OpenReader(archive)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return zr, zr.Close, nil
+	}
+	defer done()
+
+	dir, err := os.MkdirTemp(Conf.DataPath, ".extract-")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := extractTar(tr, dir); err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, fmt.Errorf("%s: %w", filepath.Base(archive), err)
+	}
+
+	return os.DirFS(dir), func() error { return os.RemoveAll(dir) }, nil
+}
+
+/*
+Read the first file of a backup archive of any format whose name matches a pattern, without extracting the archive.
+TAR archives are read until the file is found.
+*/
+func readArchiveFile(archive, pattern string) ([]byte, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tr, done, err := openTar(f)
+	if err != nil {
+		return nil, err
+	}
+
+	if tr == nil {
+		zr, err := zip.OpenReader(archive)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+
+		for _, zf := range zr.File {
+			if ok, _ := path.Match(pattern, zf.Name); ok {
+				return fs.ReadFile(zr, zf.Name)
+			}
+		}
+
+		return nil, fmt.Errorf("%s: %s %w", filepath.Base(archive), pattern, fs.ErrNotExist)
+	}
+	defer done()
+
+	for {
+		head, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%s: %s %w", filepath.Base(archive), pattern, fs.ErrNotExist)
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(archive), err)
+		}
+
+		if ok, _ := path.Match(pattern, head.Name); ok && head.Typeflag == tar.TypeReg {
+			return io.ReadAll(tr)
+		}
+	}
+}
+
+/*
+Detect the format of an archive from its content, returning a reader of its entries if it is a TAR archive, or nil if
+it is a ZIP archive. The returned function releases the decompressor.
+*/
+func openTar(f *os.File) (*tar.Reader, func(), error) {
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return nil, nil, fmt.Errorf("%s is not a backup archive", filepath.Base(f.Name()))
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+
+	switch {
+	case bytes.Equal(magic, []byte("PK\x03\x04")):
+		return nil, func() {}, nil
+	case bytes.Equal(magic[:2], []byte{0x1f, 0x8b}):
+		gr, err := gzip.NewReader(bufio.NewReader(f))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", filepath.Base(f.Name()), err)
+		}
+
+		return tar.NewReader(gr), func() { gr.Close() }, nil
+	case bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", filepath.Base(f.Name()), err)
+		}
+
+		return tar.NewReader(zr), zr.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("%s is not a backup archive", filepath.Base(f.Name()))
+	}
+}
+
+/* Extract the directories and regular files of a TAR archive to a directory, ignoring other entries. */
+func extractTar(tr *tar.Reader, dst string) error {
+	for {
+		head, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		name := strings.TrimSuffix(head.Name, "/")
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("illegal path in archive \"%s\"", head.Name)
+		}
+
+		p := filepath.Join(dst, filepath.FromSlash(name))
+
+		switch head.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(p, 0o777); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(p), 0o777); err != nil {
+				return err
+			}
+
+			if err := writeFile(p, tr, 0o644); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+/* Extract the files under a directory of an archive to a directory on disk. Nothing is done if it does not exist. */
+func extractDir(fsys fs.FS, dir, dst string) error {
+	if _, err := fs.Stat(fsys, dir); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return fs.WalkDir(fsys, dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+
+		p := filepath.Join(dst, rel)
+
+		if d.IsDir() {
+			return os.MkdirAll(p, 0o777)
+		} else if !d.Type().IsRegular() {
+			return nil
+		}
+
+		return extractFile(fsys, name, p)
+	})
+}
+
+func extractFile(fsys fs.FS, name, dst string) error {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	return writeFile(dst, f, info.Mode().Perm()|0o600)
+}
+
+func writeFile(dst string, r io.Reader, perm fs.FileMode) error {
+	fo, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(fo, r); err != nil {
+		fo.Close()
+		return err
+	}
+
+	return fo.Close()
+}
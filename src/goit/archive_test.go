@@ -0,0 +1,110 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package goit_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Jamozed/Goit/src/goit"
+)
+
+func TestBackupFormats(t *testing.T) {
+	formats := []goit.BackupFormat{goit.BackupZip, goit.BackupZipDeflate, goit.BackupTarGz, goit.BackupTarZst}
+
+	for _, format := range formats {
+		t.Run(format.String(), func(t *testing.T) {
+			_, hash, token := newTestBackup(t)
+
+			archive, err := goit.Backup(0, format)
+			if err != nil {
+				t.Fatal(err.Error())
+			} else if filepath.Ext(archive) != filepath.Ext(format.Ext()) {
+				t.Error("Expected extension", format.Ext(), "got", archive)
+			}
+
+			/* Stream an archive of the same format to a file */
+			stream := filepath.Join(t.TempDir(), "stream")
+			f, err := os.Create(stream)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			if err := goit.BackupTo(f, format); err != nil {
+				f.Close()
+				t.Fatal(err.Error())
+			}
+
+			if err := f.Close(); err != nil {
+				t.Fatal(err.Error())
+			}
+
+			for _, a := range []string{archive, stream} {
+				newTestInstance(t)
+
+				if _, err := goit.Restore(a, goit.RestoreOptions{}); err != nil {
+					t.Fatal(err.Error())
+				}
+
+				checkTestBackup(t, hash, token)
+			}
+		})
+	}
+}
+
+func TestExtractTar(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"goit/goit.json", true},
+		{"goit/", true},
+		{"../goit.json", false},
+		{"goit/../../goit.json", false},
+		{"/goit.json", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			tw := tar.NewWriter(buf)
+
+			head := &tar.Header{Typeflag: tar.TypeReg, Name: tt.name, Size: 2, Mode: 0o644}
+			if tt.name[len(tt.name)-1] == '/' {
+				head.Typeflag, head.Size = tar.TypeDir, 0
+			}
+
+			if err := tw.WriteHeader(head); err != nil {
+				t.Fatal(err.Error())
+			}
+
+			if _, err := tw.Write(make([]byte, head.Size)); err != nil {
+				t.Fatal(err.Error())
+			}
+
+			if err := tw.Close(); err != nil {
+				t.Fatal(err.Error())
+			}
+
+			dir := filepath.Join(t.TempDir(), "a", "b")
+			if err := os.MkdirAll(dir, 0o777); err != nil {
+				t.Fatal(err.Error())
+			}
+
+			err := goit.ExtractTar(tar.NewReader(buf), dir)
+			if tt.ok && err != nil {
+				t.Fatal(err.Error())
+			} else if !tt.ok && err == nil {
+				t.Fatal("Expected path to be rejected")
+			}
+
+			if _, err := os.Stat(filepath.Join(dir, "..", "goit.json")); !os.IsNotExist(err) {
+				t.Error("Expected no file outside of the directory, got", err)
+			}
+		})
+	}
+}
@@ -5,13 +5,13 @@ package goit
 
 import (
 	"crypto/rand"
+	"database/sql"
 	"encoding/base64"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/Jamozed/Goit/src/util"
@@ -19,13 +19,11 @@ import (
 )
 
 type Session struct {
+	Id           int64
 	Token, Ip    string
 	Seen, Expiry time.Time
 }
 
-var Sessions = map[int64][]Session{}
-var SessionsMutex = sync.RWMutex{}
-
 /* Generate a new user session. */
 func NewSession(uid int64, ip string, expiry time.Time) (Session, error) {
 	var b = make([]byte, 24)
@@ -36,75 +34,84 @@ func NewSession(uid int64, ip string, expiry time.Time) (Session, error) {
 	var t = base64.StdEncoding.EncodeToString(b)
 	var s = Session{Token: t, Ip: util.If(Conf.IpSessions, ip, ""), Seen: time.Now(), Expiry: expiry}
 
-	SessionsMutex.Lock()
-	util.Debugln("[goit.NewSession] SessionsMutex lock")
-
-	if Sessions[uid] == nil {
-		Sessions[uid] = []Session{}
+	res, err := db.Exec(
+		"INSERT INTO sessions (owner_id, hash, ip, seen, expiry) VALUES (?, ?, ?, ?, ?)",
+		uid, hashToken(s.Token), s.Ip, s.Seen.Unix(), s.Expiry.Unix(),
+	)
+	if err != nil {
+		return Session{}, err
 	}
 
-	Sessions[uid] = append(Sessions[uid], s)
-
-	SessionsMutex.Unlock()
-	util.Debugln("[goit.EndSession] SessionsMutex unlock")
-
+	s.Id, _ = res.LastInsertId()
 	return s, nil
 }
 
-/* End a user session. */
-func EndSession(uid int64, token string) {
-	SessionsMutex.Lock()
-	util.Debugln("[goit.EndSession] SessionsMutex lock")
-	defer SessionsMutex.Unlock()
-	defer util.Debugln("[goit.EndSession] SessionsMutex unlock")
+/* Get the sessions of a user, without their tokens. */
+func GetSessions(uid int64) ([]Session, error) {
+	sessions := []Session{}
 
-	if Sessions[uid] == nil {
-		return
+	rows, err := db.Query("SELECT id, ip, seen, expiry FROM sessions WHERE owner_id = ? ORDER BY id", uid)
+	if err != nil {
+		return nil, err
 	}
 
-	for i, t := range Sessions[uid] {
-		if t.Token == token {
-			Sessions[uid] = append(Sessions[uid][:i], Sessions[uid][i+1:]...)
-			break
+	defer rows.Close()
+
+	for rows.Next() {
+		s := Session{}
+		var seen, expiry int64
+
+		if err := rows.Scan(&s.Id, &s.Ip, &seen, &expiry); err != nil {
+			return nil, err
 		}
+
+		s.Seen, s.Expiry = time.Unix(seen, 0), time.Unix(expiry, 0)
+		sessions = append(sessions, s)
 	}
 
-	if len(Sessions[uid]) == 0 {
-		delete(Sessions, uid)
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
+
+	return sessions, nil
 }
 
-/* Cleanup expired user sessions. */
-func CleanupSessions() {
-	var n int = 0
-
-	SessionsMutex.Lock()
-	util.Debugln("[goit.CleanupSessions] SessionsMutex lock")
-
-	for uid, v := range Sessions {
-		var i = 0
-		for _, s := range v {
-			if s.Expiry.After(time.Now()) {
-				v[i] = s
-				i += 1
-			}
-		}
+/* End a user session. */
+func EndSession(uid int64, token string) {
+	if _, err := db.Exec("DELETE FROM sessions WHERE owner_id = ? AND hash = ?", uid, hashToken(token)); err != nil {
+		util.Errorln("[session]", err.Error())
+	}
+}
 
-		n += len(v) - i
+/* End a user session by its ID. */
+func EndSessionById(uid, sid int64) {
+	if _, err := db.Exec("DELETE FROM sessions WHERE owner_id = ? AND id = ?", uid, sid); err != nil {
+		util.Errorln("[session]", err.Error())
+	}
+}
 
-		if i == 0 {
-			delete(Sessions, uid)
-		} else {
-			Sessions[uid] = v[:i]
-		}
+/* End all of the sessions of a user, returning the number ended. */
+func EndSessions(uid int64) (int64, error) {
+	res, err := db.Exec("DELETE FROM sessions WHERE owner_id = ?", uid)
+	if err != nil {
+		return 0, err
 	}
 
-	SessionsMutex.Unlock()
-	util.Debugln("[goit.CleanupSessions] SessionsMutex unlock")
+	return res.RowsAffected()
+}
+
+/* Cleanup expired user sessions. */
+func CleanupSessions() error {
+	res, err := db.Exec("DELETE FROM sessions WHERE expiry <= ?", time.Now().Unix())
+	if err != nil {
+		return err
+	}
 
-	if n > 0 {
-		log.Println("[Cleanup] cleaned up", n, "expired sessions")
+	if n, _ := res.RowsAffected(); n > 0 {
+		util.Infoln("[cleanup] cleaned up", n, "expired sessions")
 	}
+
+	return nil
 }
 
 /* Set a user session cookie. */
@@ -115,7 +122,7 @@ func SetSessionCookie(w http.ResponseWriter, uid int64, s Session) {
 	}
 
 	if err := c.Valid(); err != nil {
-		log.Println("[Cookie]", err.Error())
+		util.Errorln("[Cookie]", err.Error())
 	}
 
 	http.SetCookie(w, c)
@@ -134,23 +141,29 @@ func GetSessionCookie(r *http.Request) (int64, Session) {
 			return -1, Session{}
 		}
 
-		SessionsMutex.Lock()
-		util.Debugln("[goit.GetSessionCookie] SessionsMutex lock")
-		defer SessionsMutex.Unlock()
-		defer util.Debugln("[goit.GetSessionCookie] SessionsMutex unlock")
+		s := Session{Token: ss[1]}
+		var seen, expiry int64
+
+		if err := db.QueryRow(
+			"SELECT id, ip, seen, expiry FROM sessions WHERE owner_id = ? AND hash = ?", uid, hashToken(s.Token),
+		).Scan(&s.Id, &s.Ip, &seen, &expiry); err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				util.Errorln("[session]", err.Error())
+			}
+
+			return uid, Session{}
+		}
 
-		for i, s := range Sessions[uid] {
-			if ss[1] == s.Token {
-				if s != (Session{}) {
-					s.Seen = time.Now()
-					Sessions[uid][i] = s
-				}
+		s.Seen, s.Expiry = time.Now(), time.Unix(expiry, 0)
 
-				return uid, s
+		/* Avoid writing to the database on every request */
+		if s.Seen.Sub(time.Unix(seen, 0)) > time.Minute {
+			if _, err := db.Exec("UPDATE sessions SET seen = ? WHERE id = ?", s.Seen.Unix(), s.Id); err != nil {
+				util.Errorln("[session]", err.Error())
 			}
 		}
 
-		return uid, Session{}
+		return uid, s
 	}
 
 	return -1, Session{}
@@ -186,7 +199,7 @@ func Auth(w http.ResponseWriter, r *http.Request, renew bool) (bool, *User, erro
 
 		s1, err := NewSession(uid, ip, time.Now().Add(2*24*time.Hour))
 		if err != nil {
-			log.Println("[auth/renew]", err.Error())
+			util.Errorln("[auth/renew]", err.Error())
 		} else {
 			SetSessionCookie(w, uid, s1)
 			EndSession(uid, s.Token)
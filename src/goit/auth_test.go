@@ -5,8 +5,8 @@ package goit_test
 
 import (
 	"fmt"
+	"path/filepath"
 	"slices"
-	"sync"
 	"testing"
 	"time"
 
@@ -14,8 +14,9 @@ import (
 )
 
 func TestNewSession(t *testing.T) {
-	goit.Sessions = map[int64][]goit.Session{}
-	goit.SessionsMutex = sync.RWMutex{}
+	if err := goit.OpenDatabase(filepath.Join(t.TempDir(), "goit.db")); err != nil {
+		t.Fatal(err.Error())
+	}
 
 	var uid int64 = 1
 	var session = goit.Session{Ip: "127.0.0.1", Expiry: time.Unix(0, 0)}
@@ -26,19 +27,21 @@ func TestNewSession(t *testing.T) {
 		t.Fatal(err.Error())
 	}
 
-	if goit.Sessions[uid] == nil {
-		t.Fatal("UID slice not added to the sessions map")
+	sessions, err := goit.GetSessions(uid)
+	if err != nil {
+		t.Fatal(err.Error())
 	}
-	if len(goit.Sessions[uid]) != 1 {
-		t.Fatal("Incorrect number of sessions added to the sessions map")
+
+	if len(sessions) != 1 {
+		t.Fatal("Incorrect number of sessions added to the database")
 	}
-	if s != goit.Sessions[uid][0] {
+	if s.Id != sessions[0].Id {
 		t.Fatal("Added and returned sessions do not match")
 	}
-	if s.Ip != session.Ip {
+	if s.Ip != session.Ip || sessions[0].Ip != session.Ip {
 		t.Fatal("Added session IP is incorrect")
 	}
-	if s.Expiry != session.Expiry {
+	if s.Expiry != session.Expiry || !sessions[0].Expiry.Equal(session.Expiry) {
 		t.Fatal("Added session expiry is incorrect")
 	}
 	if !s.Seen.Before(time.Now()) {
@@ -47,8 +50,13 @@ func TestNewSession(t *testing.T) {
 	if len(s.Token) != 32 {
 		t.Fatal("Session token length is incorrect")
 	}
-	if goit.SessionsMutex.TryLock() == false {
-		t.Fatal("Sessions mutex was not unlocked")
+
+	goit.EndSession(uid, s.Token)
+
+	if sessions, err := goit.GetSessions(uid); err != nil {
+		t.Fatal(err.Error())
+	} else if len(sessions) != 0 {
+		t.Fatal("Session was not ended")
 	}
 }
 
@@ -0,0 +1,841 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package goit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jamozed/Goit/src/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+/* How a backup is taken, either as configured, or always a full or incremental backup. */
+type BackupMode int32
+
+const (
+	BackupAuto        BackupMode = 0
+	BackupFull        BackupMode = 1
+	BackupIncremental BackupMode = 2
+)
+
+func BackupModeFromString(s string) BackupMode {
+	switch strings.ToLower(s) {
+	case "", "auto":
+		return BackupAuto
+	case "full":
+		return BackupFull
+	case "incremental":
+		return BackupIncremental
+	default:
+		return -1
+	}
+}
+
+/* A backup archive in the backup directory. */
+type BackupFile struct {
+	Name        string
+	Time        time.Time
+	Size        int64
+	Incremental bool
+}
+
+/*
+The database dump written to backup archives as "goit.json". Sessions and webhook deliveries are not included, and
+archives written before the other tables were included only contain users and repositories.
+*/
+type backupData struct {
+	Users         []User            `json:"users"`
+	Repos         []Repo            `json:"repos"`
+	SshKeys       []backupSshKey    `json:"ssh_keys,omitempty"`
+	Tokens        []backupToken     `json:"tokens,omitempty"`
+	Collaborators []Collaborator    `json:"collaborators,omitempty"`
+	Webhooks      []backupWebhook   `json:"webhooks,omitempty"`
+	PushRules     []backupPushRules `json:"push_rules,omitempty"`
+	Protected     []backupProtected `json:"protected_branches,omitempty"`
+}
+
+/* Rows of the database in a backup, with times as Unix timestamps as they are stored. */
+type backupSshKey struct {
+	OwnerId     int64  `json:"owner_id"`
+	Name        string `json:"name"`
+	Key         string `json:"key"`
+	Fingerprint string `json:"fingerprint"`
+	Created     int64  `json:"created"`
+}
+
+type backupToken struct {
+	OwnerId  int64  `json:"owner_id"`
+	Name     string `json:"name"`
+	Hash     []byte `json:"hash"`
+	Scope    Access `json:"scope"`
+	Created  int64  `json:"created"`
+	Expiry   int64  `json:"expiry"`
+	LastUsed int64  `json:"last_used"`
+}
+
+type backupWebhook struct {
+	OwnerId int64  `json:"owner_id"`
+	RepoId  int64  `json:"repo_id"`
+	Url     string `json:"url"`
+	Secret  string `json:"secret"`
+	Created int64  `json:"created"`
+}
+
+type backupPushRules struct {
+	RepoId        int64  `json:"repo_id"`
+	MaxFileSize   int64  `json:"max_file_size"`
+	DenyForcePush bool   `json:"deny_force_push"`
+	BranchPattern string `json:"branch_pattern"`
+}
+
+type backupProtected struct {
+	Id      int64   `json:"-"`
+	RepoId  int64   `json:"repo_id"`
+	Pattern string  `json:"pattern"`
+	Created int64   `json:"created"`
+	Users   []int64 `json:"users"`
+}
+
+/*
+The manifest written to backup archives as "manifest.json", recording the refs and LFS objects of each repository so
+that incremental backups can be chained onto it. Incremental backups name the archive they are based on.
+*/
+type backupManifest struct {
+	Incremental bool                         `json:"incremental"`
+	Previous    string                       `json:"previous,omitempty"`
+	Depth       int                          `json:"depth"`
+	Repos       map[int64]backupManifestRepo `json:"repos"`
+}
+
+type backupManifestRepo struct {
+	Name   string            `json:"name"`
+	Refs   map[string]string `json:"refs"`
+	Bundle string            `json:"bundle,omitempty"`
+	Lfs    []string          `json:"lfs,omitempty"`
+}
+
+/* The manifest of the last backup, which the next incremental backup is based on. */
+type backupState struct {
+	Archive  string         `json:"archive"`
+	Manifest backupManifest `json:"manifest"`
+}
+
+var backupMutex sync.Mutex
+var backupPattern = regexp.MustCompile(`^goit_([0-9]{8}T[0-9]{6}Z)\.`)
+
+/* The path of the directory that backup archives are written to. */
+func BackupPath() string {
+	return filepath.Join(Conf.DataPath, "backup")
+}
+
+/* Options of a backup, selecting the kind of backup and the format of the archive. */
+type BackupOptions struct {
+	Mode   BackupMode
+	Format BackupFormat
+}
+
+/*
+Perform a backup, then prune old backups according to the retention policy, returning the path of the archive. Only one
+backup is run at a time.
+*/
+func RunBackup(opts BackupOptions) (string, error) {
+	if !backupMutex.TryLock() {
+		return "", errors.New("a backup is already running")
+	}
+	defer backupMutex.Unlock()
+
+	depth := Conf.BackupIncremental
+	switch opts.Mode {
+	case BackupFull:
+		depth = 0
+	case BackupIncremental:
+		depth = math.MaxInt
+	}
+
+	path, err := Backup(depth, opts.Format)
+	if err != nil {
+		return "", err
+	}
+
+	util.Infoln("[backup] wrote", path)
+
+	if pruned, err := PruneBackups(); err != nil {
+		return path, fmt.Errorf("pruning: %w", err)
+	} else if len(pruned) != 0 {
+		util.Infoln("[backup] pruned", strings.Join(pruned, ", "))
+	}
+
+	return path, nil
+}
+
+/*
+Write a backup archive of the users, repositories, and LFS objects to the backup directory, returning its path. If the
+last backup still exists and is based on fewer than depth incremental backups, then an incremental backup is written,
+containing Git bundles of only the objects that are not in the last backup. The archive is removed if the backup fails.
+*/
+func Backup(depth int, format BackupFormat) (_ string, err error) {
+	bdir := BackupPath()
+	if err := os.MkdirAll(bdir, 0o777); err != nil {
+		return "", err
+	}
+
+	/* Base an incremental backup on the last backup */
+	var prev *backupState
+	if depth > 0 {
+		if prev, err = loadBackupState(); err != nil {
+			return "", err
+		} else if prev != nil && prev.Manifest.Depth >= depth {
+			prev = nil
+		}
+	}
+
+	/* Archives are named by the second they are taken in, so wait for the next if one has already been taken */
+	var ts string
+	for {
+		ts = backupName()
+		if m, err := filepath.Glob(filepath.Join(bdir, ts+".*")); err != nil {
+			return "", err
+		} else if len(m) == 0 {
+			break
+		}
+
+		time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+	}
+
+	/* Open an output archive file */
+	archive := filepath.Join(bdir, ts+util.If(prev != nil, ".inc", "")+format.Ext())
+
+	f, err := os.Create(archive)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(archive)
+		}
+	}()
+	defer f.Close()
+
+	aw, err := newArchiveWriter(f, format)
+	if err != nil {
+		return "", err
+	}
+
+	manifest, err := writeBackup(aw, ts, prev)
+	if err != nil {
+		aw.Close()
+		return "", err
+	}
+
+	if err := aw.Close(); err != nil {
+		return "", err
+	}
+
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	if err := saveBackupState(backupState{Archive: filepath.Base(archive), Manifest: manifest}); err != nil {
+		return "", err
+	}
+
+	return archive, nil
+}
+
+/*
+Write a full backup archive to a writer as it is taken, such as to stream it elsewhere. The archive is not kept in the
+backup directory, so incremental backups are never based on it. It is not written while another backup is running.
+*/
+func BackupTo(w io.Writer, format BackupFormat) error {
+	if !backupMutex.TryLock() {
+		return errors.New("a backup is already running")
+	}
+	defer backupMutex.Unlock()
+
+	aw, err := newArchiveWriter(w, format)
+	if err != nil {
+		return err
+	}
+
+	if _, err := writeBackup(aw, backupName(), nil); err != nil {
+		aw.Close()
+		return err
+	}
+
+	return aw.Close()
+}
+
+func backupName() string {
+	return "goit_" + time.Now().UTC().Format("20060102T150405Z")
+}
+
+/*
+Write the entries of a backup to an archive under the directory ts, returning its manifest. If the state of a previous
+backup is given then an incremental backup is written.
+*/
+func writeBackup(aw archiveWriter, ts string, prev *backupState) (backupManifest, error) {
+	manifest := backupManifest{Repos: map[int64]backupManifestRepo{}}
+
+	if prev != nil {
+		manifest.Incremental, manifest.Previous, manifest.Depth = true, prev.Archive, prev.Manifest.Depth+1
+	}
+
+	data, err := dumpDatabase()
+	if err != nil {
+		return manifest, err
+	}
+
+	/* Add repositories and their LFS objects to the archive */
+	for _, r := range data.Repos {
+		var base backupManifestRepo
+		if prev != nil {
+			base = prev.Manifest.Repos[r.Id]
+		}
+
+		mr := backupManifestRepo{Name: r.Name, Refs: map[string]string{}}
+
+		if mr.Lfs, err = backupLfs(aw, ts, r.Id, base.Lfs); err != nil {
+			return manifest, err
+		}
+
+		if prev != nil {
+			err = backupBundle(aw, ts, r, base.Refs, &mr)
+		} else {
+			err = backupRepo(aw, ts, r, &mr)
+		}
+		if err != nil {
+			return manifest, fmt.Errorf("repository \"%s\": %w", r.Name, err)
+		}
+
+		manifest.Repos[r.Id] = mr
+	}
+
+	/* Write database and manifest as JSON to the archive */
+	if err := archiveJson(aw, path.Join(ts, "goit.json"), data); err != nil {
+		return manifest, err
+	}
+
+	return manifest, archiveJson(aw, path.Join(ts, "manifest.json"), manifest)
+}
+
+func dumpDatabase() (data backupData, err error) {
+	if data.Users, err = dumpRows("SELECT id, name, name_full, pass, pass_algo, salt, is_admin FROM users",
+		func(u *User) []any {
+			return []any{&u.Id, &u.Name, &u.FullName, &u.Pass, &u.PassAlgo, &u.Salt, &u.IsAdmin}
+		},
+	); err != nil {
+		return data, err
+	}
+
+	if data.Repos, err = dumpRows(
+		"SELECT id, owner_id, name, description, default_branch, upstream, visibility, is_mirror FROM repos",
+		func(r *Repo) []any {
+			return []any{
+				&r.Id, &r.OwnerId, &r.Name, &r.Description, &r.DefaultBranch, &r.Upstream, &r.Visibility, &r.IsMirror,
+			}
+		},
+	); err != nil {
+		return data, err
+	}
+
+	if data.SshKeys, err = dumpRows("SELECT owner_id, name, key, fingerprint, created FROM ssh_keys ORDER BY id",
+		func(k *backupSshKey) []any { return []any{&k.OwnerId, &k.Name, &k.Key, &k.Fingerprint, &k.Created} },
+	); err != nil {
+		return data, err
+	}
+
+	if data.Tokens, err = dumpRows(
+		"SELECT owner_id, name, hash, scope, created, expiry, last_used FROM tokens ORDER BY id",
+		func(t *backupToken) []any {
+			return []any{&t.OwnerId, &t.Name, &t.Hash, &t.Scope, &t.Created, &t.Expiry, &t.LastUsed}
+		},
+	); err != nil {
+		return data, err
+	}
+
+	if data.Collaborators, err = dumpRows("SELECT repo_id, user_id, access FROM collaborators",
+		func(c *Collaborator) []any { return []any{&c.RepoId, &c.UserId, &c.Access} },
+	); err != nil {
+		return data, err
+	}
+
+	if data.Webhooks, err = dumpRows("SELECT owner_id, repo_id, url, secret, created FROM webhooks ORDER BY id",
+		func(h *backupWebhook) []any { return []any{&h.OwnerId, &h.RepoId, &h.Url, &h.Secret, &h.Created} },
+	); err != nil {
+		return data, err
+	}
+
+	if data.PushRules, err = dumpRows(
+		"SELECT repo_id, max_file_size, deny_force_push, branch_pattern FROM push_rules",
+		func(p *backupPushRules) []any {
+			return []any{&p.RepoId, &p.MaxFileSize, &p.DenyForcePush, &p.BranchPattern}
+		},
+	); err != nil {
+		return data, err
+	}
+
+	if data.Protected, err = dumpRows("SELECT id, repo_id, pattern, created FROM protected_branches ORDER BY id",
+		func(b *backupProtected) []any { return []any{&b.Id, &b.RepoId, &b.Pattern, &b.Created} },
+	); err != nil {
+		return data, err
+	}
+
+	for i := range data.Protected {
+		if data.Protected[i].Users, err = protectedUsers(data.Protected[i].Id); err != nil {
+			return data, err
+		}
+	}
+
+	return data, nil
+}
+
+/* Query rows of the database, scanning each into the fields of a value given by a function. */
+func dumpRows[T any](query string, fields func(*T) []any) ([]T, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var values []T
+	for rows.Next() {
+		var v T
+		if err := rows.Scan(fields(&v)...); err != nil {
+			return nil, err
+		}
+
+		values = append(values, v)
+	}
+
+	return values, rows.Err()
+}
+
+/*
+Add a repository to a backup archive under "repos", streaming its files from disk rather than copying it first. The
+refs are read before the objects and written as packed refs, so every object they point to is already stored. Objects
+that are moved while they are added, such as by Git repacking, are added again from where they were moved to.
+*/
+func backupRepo(aw archiveWriter, ts string, r Repo, mr *backupManifestRepo) error {
+	gr, err := git.PlainOpen(RepoPath(r.Name, true))
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	/* Empty repositories are initialised when they are restored */
+	if mr.Refs, err = repoRefs(gr); err != nil || len(mr.Refs) == 0 {
+		return err
+	}
+
+	head, err := gr.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return err
+	}
+
+	dir := path.Join(ts, "repos", filepath.ToSlash(RepoPath(r.Name, false)))
+	now := time.Now()
+
+	for _, d := range []string{"", "refs", "refs/heads", "refs/tags", "objects"} {
+		if err := aw.Dir(path.Join(dir, d), now); err != nil {
+			return err
+		}
+	}
+
+	if head.Type() == plumbing.SymbolicReference {
+		err = archiveBytes(aw, path.Join(dir, "HEAD"), []byte("ref: "+head.Target().String()+"\n"))
+	} else {
+		err = archiveBytes(aw, path.Join(dir, "HEAD"), []byte(head.Hash().String()+"\n"))
+	}
+	if err != nil {
+		return err
+	}
+
+	config := "[core]\n\trepositoryformatversion = 0\n\tfilemode = true\n\tbare = true\n"
+	if err := archiveBytes(aw, path.Join(dir, "config"), []byte(config)); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(mr.Refs))
+	for name := range mr.Refs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	packed := "# pack-refs with: sorted \n"
+	for _, name := range names {
+		packed += mr.Refs[name] + " " + name + "\n"
+	}
+
+	if err := archiveBytes(aw, path.Join(dir, "packed-refs"), []byte(packed)); err != nil {
+		return err
+	}
+
+	return backupObjects(aw, path.Join(dir, "objects"), filepath.Join(RepoPath(r.Name, true), "objects"))
+}
+
+/*
+Add the object directory of a repository to a backup archive. Objects are never modified, only added or moved into
+packs, so the directory is walked again until no file has disappeared while it was being walked.
+*/
+func backupObjects(aw archiveWriter, name, dir string) error {
+	added := map[string]bool{".": true}
+
+	for {
+		moved := false
+
+		if err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) && p != dir {
+					moved = true
+					return nil
+				}
+
+				return err
+			}
+
+			/* Skip temporary files and the quarantine directories of pushes in progress */
+			if strings.HasPrefix(d.Name(), "tmp_") || strings.HasPrefix(d.Name(), "incoming-") {
+				return util.If(d.IsDir(), fs.SkipDir, nil)
+			}
+
+			rel, err := filepath.Rel(dir, p)
+			if err != nil || added[rel] {
+				return err
+			}
+
+			if d.IsDir() {
+				err = aw.Dir(path.Join(name, filepath.ToSlash(rel)), time.Now())
+			} else if d.Type().IsRegular() {
+				if err = archiveFile(aw, path.Join(name, filepath.ToSlash(rel)), p); errors.Is(err, fs.ErrNotExist) {
+					moved = true
+					return nil
+				}
+			}
+
+			added[rel] = err == nil
+			return err
+		}); err != nil || !moved {
+			return err
+		}
+	}
+}
+
+/*
+Add a Git bundle of the objects of a repository that are not reachable from the refs of the last backup to a backup
+archive, under "bundles". No bundle is written if there are no new objects. The bundle is written to a temporary file
+first, as its size must be known before it is added.
+*/
+func backupBundle(aw archiveWriter, ts string, r Repo, prev map[string]string, mr *backupManifestRepo) error {
+	gr, err := git.PlainOpen(RepoPath(r.Name, true))
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if mr.Refs, err = repoRefs(gr); err != nil {
+		return err
+	}
+
+	if len(mr.Refs) == 0 || maps.Equal(mr.Refs, prev) {
+		return nil
+	}
+
+	/* Exclude objects in the last backup, ignoring any that no longer exist in the repository */
+	var exclude []string
+	for _, h := range prev {
+		if gr.Storer.HasEncodedObject(plumbing.NewHash(h)) == nil {
+			exclude = append(exclude, "^"+h+"\n")
+		}
+	}
+
+	tmp, err := os.CreateTemp("", "goit-*.bundle")
+	if err != nil {
+		return err
+	}
+
+	bundle := tmp.Name()
+	tmp.Close()
+	defer os.Remove(bundle)
+
+	stderr := &bytes.Buffer{}
+	c := NewGitCommand("bundle", "create", bundle, "--all", "--stdin")
+	c.Dir = RepoPath(r.Name, true)
+	c.Stderr = stderr
+
+	if _, _, err := c.Run(strings.NewReader(strings.Join(exclude, "")), nil); err != nil {
+		if strings.Contains(stderr.String(), "empty bundle") {
+			return nil
+		}
+
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	mr.Bundle = path.Join("bundles", fmt.Sprint(r.Id, ".bundle"))
+	return archiveFile(aw, path.Join(ts, mr.Bundle), bundle)
+}
+
+/*
+Add the LFS objects of a repository to a backup archive, under "lfs/<repo id>", other than those already in the last
+backup. The IDs of all of the objects are returned.
+*/
+func backupLfs(aw archiveWriter, ts string, rid int64, prev []string) ([]string, error) {
+	var oids []string
+
+	err := filepath.WalkDir(LfsRepoPath(rid), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		/* Skip directories and incomplete uploads */
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+
+		oids = append(oids, d.Name())
+		if slices.Contains(prev, d.Name()) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(Conf.DataPath, path)
+		if err != nil {
+			return err
+		}
+
+		return archiveFile(aw, filepath.ToSlash(filepath.Join(ts, rel)), path)
+	})
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+
+	return oids, err
+}
+
+func archiveJson(aw archiveWriter, name string, v any) error {
+	b, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return archiveBytes(aw, name, b)
+}
+
+/* Get the refs of a repository, other than symbolic refs, as a map of names to hashes. */
+func repoRefs(gr *git.Repository) (map[string]string, error) {
+	refs := map[string]string{}
+
+	iter, err := gr.References()
+	if err != nil {
+		return nil, err
+	}
+
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference && ref.Name() != plumbing.HEAD {
+			refs[ref.Name().String()] = ref.Hash().String()
+		}
+
+		return nil
+	})
+
+	return refs, err
+}
+
+/* Read the manifest of a backup archive without extracting it. */
+func readBackupManifest(archive string) (*backupManifest, error) {
+	b, err := readArchiveFile(archive, "*/manifest.json")
+	if err != nil {
+		return nil, err
+	}
+
+	m := &backupManifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("%s: manifest.json: %w", filepath.Base(archive), err)
+	}
+
+	return m, nil
+}
+
+/* Load the state of the last backup, or nil if there is none or its archive no longer exists. */
+func loadBackupState() (*backupState, error) {
+	b, err := os.ReadFile(filepath.Join(BackupPath(), "state.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	state := &backupState{}
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, fmt.Errorf("backup state: %w", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(BackupPath(), state.Archive)); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+func saveBackupState(state backupState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(BackupPath(), ".state.json")
+	if err := os.WriteFile(tmp, b, 0o666); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(BackupPath(), "state.json"))
+}
+
+/* Get the backup archives in the backup directory, newest first. */
+func GetBackups() ([]BackupFile, error) {
+	entries, err := os.ReadDir(BackupPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return []BackupFile{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	backups := []BackupFile{}
+	for _, e := range entries {
+		m := backupPattern.FindStringSubmatch(e.Name())
+		if m == nil || !e.Type().IsRegular() {
+			continue
+		}
+
+		t, err := time.Parse("20060102T150405Z", m[1])
+		if err != nil {
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		backups = append(backups, BackupFile{
+			Name: e.Name(), Time: t, Size: info.Size(), Incremental: strings.Contains(e.Name(), ".inc."),
+		})
+	}
+
+	slices.SortFunc(backups, func(a, b BackupFile) int { return b.Time.Compare(a.Time) })
+	return backups, nil
+}
+
+/*
+Delete the backups that are not kept by the retention policy, returning their names. If no retention is configured
+then all backups are kept.
+*/
+func PruneBackups() ([]string, error) {
+	if Conf.BackupKeepLast <= 0 && Conf.BackupKeepDaily <= 0 && Conf.BackupKeepWeekly <= 0 {
+		return nil, nil
+	}
+
+	backups, err := GetBackups()
+	if err != nil {
+		return nil, err
+	}
+
+	/* Incremental backups name the backup they are based on in their manifest */
+	parents := map[string]string{}
+	for _, b := range backups {
+		if !b.Incremental {
+			continue
+		}
+
+		m, err := readBackupManifest(filepath.Join(BackupPath(), b.Name))
+		if err != nil {
+			return nil, err
+		}
+
+		parents[b.Name] = filepath.Base(m.Previous)
+	}
+
+	var pruned []string
+	keep := retainBackups(backups, parents, Conf.BackupKeepLast, Conf.BackupKeepDaily, Conf.BackupKeepWeekly)
+
+	for i, b := range backups {
+		if keep[i] {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(BackupPath(), b.Name)); err != nil {
+			return pruned, err
+		}
+
+		pruned = append(pruned, b.Name)
+	}
+
+	return pruned, nil
+}
+
+/*
+Select the backups to keep, given newest first. The newest "last" backups are kept, along with the newest backup of each
+of the most recent "daily" days and "weekly" weeks. Incremental backups that are kept also keep the backups they are
+based on, as given by parents, back to the last full backup.
+*/
+func retainBackups(backups []BackupFile, parents map[string]string, last, daily, weekly int) []bool {
+	keep := make([]bool, len(backups))
+	days, weeks := map[string]bool{}, map[string]bool{}
+	index := map[string]int{}
+
+	for i, b := range backups {
+		index[b.Name] = i
+
+		if i < last {
+			keep[i] = true
+		}
+
+		if day := b.Time.Format(time.DateOnly); len(days) < daily && !days[day] {
+			days[day], keep[i] = true, true
+		}
+
+		year, w := b.Time.ISOWeek()
+		if week := fmt.Sprint(year, "-", w); len(weeks) < weekly && !weeks[week] {
+			weeks[week], keep[i] = true, true
+		}
+	}
+
+	for i := range backups {
+		if !keep[i] {
+			continue
+		}
+
+		/* Stop at a backup that is already kept, as the rest of its chain is kept when it is reached */
+		for j := i; backups[j].Incremental; {
+			p, ok := index[parents[backups[j].Name]]
+			if !ok || keep[p] {
+				break
+			}
+
+			j, keep[p] = p, true
+		}
+	}
+
+	return keep
+}
@@ -0,0 +1,138 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package goit_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Jamozed/Goit/src/goit"
+)
+
+/* Create full backups taken at offsets before the newest backup, given newest first. */
+func testBackups(offsets ...time.Duration) []goit.BackupFile {
+	newest := time.Date(2024, 3, 13, 12, 0, 0, 0, time.UTC) /* A Wednesday */
+
+	var backups []goit.BackupFile
+	for i, o := range offsets {
+		backups = append(backups, goit.BackupFile{Name: fmt.Sprint("backup", i), Time: newest.Add(-o)})
+	}
+
+	return backups
+}
+
+func TestRetainBackups(t *testing.T) {
+	h, d := time.Hour, 24*time.Hour
+
+	tests := []struct {
+		name                string
+		backups             []goit.BackupFile
+		parents             map[int]int
+		last, daily, weekly int
+		want                []bool
+	}{
+		{
+			"last", testBackups(0, h, 2*h, 3*h, 4*h), nil, 2, 0, 0,
+			[]bool{true, true, false, false, false},
+		},
+		{
+			"daily", testBackups(0, h, d, d+h, 2*d, 3*d), nil, 0, 2, 0,
+			[]bool{true, false, true, false, false, false},
+		},
+		{
+			"weekly", testBackups(0, d, 2*d, 3*d, 7*d, 8*d, 14*d), nil, 0, 0, 2,
+			[]bool{true, false, false, true, false, false, false},
+		},
+		{
+			"combined", testBackups(0, h, d, 2*d, 7*d, 14*d), nil, 1, 2, 2,
+			[]bool{true, false, true, false, true, false},
+		},
+		{
+			"incremental chain", testBackups(0, h, 2*h, 3*h, 4*h), map[int]int{0: 1, 1: 2, 3: 4}, 1, 0, 0,
+			[]bool{true, true, true, false, false},
+		},
+		{
+			"incremental in chain", testBackups(0, 2*d, 2*d+h, 2*d+2*h, 3*d), map[int]int{1: 2, 2: 3}, 0, 2, 0,
+			[]bool{true, true, true, true, false},
+		},
+		{
+			"incremental of older backup", testBackups(0, h, 2*h, 3*h), map[int]int{0: 2}, 1, 0, 0,
+			[]bool{true, false, true, false},
+		},
+		{
+			"missing parent", testBackups(0, h), map[int]int{0: 5}, 1, 0, 0,
+			[]bool{true, false},
+		},
+		{
+			"none", testBackups(0, h), nil, 0, 0, 0,
+			[]bool{false, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parents := map[string]string{}
+			for c, p := range tt.parents {
+				tt.backups[c].Incremental = true
+				parents[tt.backups[c].Name] = fmt.Sprint("backup", p)
+			}
+
+			got := goit.RetainBackups(tt.backups, parents, tt.last, tt.daily, tt.weekly)
+			if !slices.Equal(got, tt.want) {
+				t.Error("Expected", tt.want, "got", got)
+			}
+		})
+	}
+}
+
+func TestIncrementalBackup(t *testing.T) {
+	full, _, token := newTestBackup(t)
+	hash := testCommit(t, "proj", "master", "Second commit")
+
+	inc, err := goit.Backup(1, goit.BackupTarZst)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if !strings.Contains(filepath.Base(inc), ".inc.") {
+		t.Fatal("Expected an incremental backup got", inc)
+	}
+
+	t.Run("prune", func(t *testing.T) {
+		last := goit.Conf.BackupKeepLast
+		t.Cleanup(func() { goit.Conf.BackupKeepLast = last })
+		goit.Conf.BackupKeepLast = 1
+
+		if pruned, err := goit.PruneBackups(); err != nil {
+			t.Fatal(err.Error())
+		} else if len(pruned) != 0 {
+			t.Error("Expected the full backup to be kept, got", pruned)
+		}
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		newTestInstance(t)
+
+		if _, err := goit.Restore(inc, goit.RestoreOptions{}); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		checkTestBackup(t, hash, token)
+	})
+
+	t.Run("missing previous", func(t *testing.T) {
+		newTestInstance(t)
+
+		if err := os.Remove(full); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if _, err := goit.Restore(inc, goit.RestoreOptions{}); err == nil {
+			t.Error("Expected restoring without the previous backup to fail")
+		}
+	})
+}
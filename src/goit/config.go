@@ -16,11 +16,42 @@ type config struct {
 	RuntimePath string `json:"runtime_path"`
 	HttpAddr    string `json:"http_addr"`
 	HttpPort    string `json:"http_port"`
+	SshAddr     string `json:"ssh_addr"`
+	SshPort     string `json:"ssh_port"`
+	SshHostKey  string `json:"ssh_host_key"`
 	GitPath     string `json:"git_path"`
 	IpSessions  bool   `json:"ip_sessions"`
 	UsesHttps   bool   `json:"uses_https"`
 	IpForwarded bool   `json:"ip_forwarded"`
 	CsrfSecret  string `json:"csrf_secret"`
+
+	/* Serve HTTPS instead of HTTP on the HTTP port, optionally redirecting plain HTTP from another port */
+	TlsCert          string `json:"tls_cert"`
+	TlsKey           string `json:"tls_key"`
+	HttpRedirectPort string `json:"http_redirect_port"`
+
+	/* Serve Prometheus metrics on a separate address if set, otherwise at /metrics if a bearer token is set */
+	MetricsAddr  string `json:"metrics_addr"`
+	MetricsToken string `json:"metrics_token"`
+
+	/* Log level and format, and log files are rotated by size in MiB and age in days, with 0 for no limit */
+	LogLevel    string `json:"log_level"`
+	LogFormat   string `json:"log_format"`
+	LogMaxSize  int    `json:"log_max_size"`
+	LogMaxAge   int    `json:"log_max_age"`
+	LogKeepLast int    `json:"log_keep_last"`
+	LogKeepDays int    `json:"log_keep_days"`
+	AccessLog   bool   `json:"access_log"`
+
+	/* Seconds to wait for requests, Git commands, and cron jobs to finish when shutting down, or 0 for no limit */
+	ShutdownTimeout int `json:"shutdown_timeout"`
+
+	BackupSchedule    string `json:"backup_schedule"`
+	BackupFormat      string `json:"backup_format"`
+	BackupIncremental int    `json:"backup_incremental"`
+	BackupKeepLast    int    `json:"backup_keep_last"`
+	BackupKeepDaily   int    `json:"backup_keep_daily"`
+	BackupKeepWeekly  int    `json:"backup_keep_weekly"`
 }
 
 func loadConfig() (config, error) {
@@ -30,11 +61,38 @@ func loadConfig() (config, error) {
 		RuntimePath: runtimePath(),
 		HttpAddr:    "",
 		HttpPort:    "8080",
+		SshAddr:     "",
+		SshPort:     "",
+		SshHostKey:  "",
 		GitPath:     "git",
 		IpSessions:  true,
 		UsesHttps:   false,
 		IpForwarded: false,
 		CsrfSecret:  "1234567890abcdef1234567890abcdef",
+
+		TlsCert:          "",
+		TlsKey:           "",
+		HttpRedirectPort: "",
+
+		MetricsAddr:  "",
+		MetricsToken: "",
+
+		LogLevel:    "info",
+		LogFormat:   "text",
+		LogMaxSize:  10,
+		LogMaxAge:   7,
+		LogKeepLast: 0,
+		LogKeepDays: 0,
+		AccessLog:   false,
+
+		ShutdownTimeout: 30,
+
+		BackupSchedule:    "",
+		BackupFormat:      "zip",
+		BackupIncremental: 0,
+		BackupKeepLast:    0,
+		BackupKeepDaily:   0,
+		BackupKeepWeekly:  0,
 	}
 
 	/* Load config file(s) */
@@ -66,6 +124,17 @@ func loadConfig() (config, error) {
 	return conf, nil
 }
 
+/* Load the configuration without initialising Goit, for commands that talk to a running instance. */
+func LoadConfig() error {
+	conf, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	Conf = conf
+	return nil
+}
+
 func userConfigBase() string {
 	if path := os.Getenv("XDG_CONFIG_HOME"); path != "" {
 		return path
@@ -3,7 +3,6 @@ package goit
 import (
 	"database/sql"
 	"fmt"
-	"log"
 
 	"github.com/Jamozed/Goit/src/util"
 )
@@ -33,8 +32,24 @@ import (
 	)
 */
 
+/* Open the database at a path, updating it to the latest version if necessary. */
+func OpenDatabase(path string) error {
+	d, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+
+	if err := dbUpdate(d); err != nil {
+		d.Close()
+		return err
+	}
+
+	db = d
+	return nil
+}
+
 func dbUpdate(db *sql.DB) error {
-	latestVersion := 3
+	latestVersion := 10
 
 	var version int
 	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
@@ -47,7 +62,7 @@ func dbUpdate(db *sql.DB) error {
 
 	if version == 0 {
 		/* Database is empty or new, initialise the newest version */
-		log.Println("Initialising database at version", latestVersion)
+		util.Infoln("[database] Initialising database at version", latestVersion)
 
 		if _, err := db.Exec(
 			`CREATE TABLE IF NOT EXISTS users (
@@ -79,15 +94,131 @@ func dbUpdate(db *sql.DB) error {
 			return err
 		}
 
+		if _, err := db.Exec(
+			`CREATE TABLE IF NOT EXISTS ssh_keys (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				owner_id INTEGER NOT NULL,
+				name TEXT NOT NULL,
+				key TEXT UNIQUE NOT NULL,
+				fingerprint TEXT NOT NULL,
+				created INTEGER NOT NULL
+			)`,
+		); err != nil {
+			return err
+		}
+
+		if _, err := db.Exec(
+			`CREATE TABLE IF NOT EXISTS collaborators (
+				repo_id INTEGER NOT NULL,
+				user_id INTEGER NOT NULL,
+				access INTEGER NOT NULL,
+				PRIMARY KEY (repo_id, user_id)
+			)`,
+		); err != nil {
+			return err
+		}
+
+		if _, err := db.Exec(
+			`CREATE TABLE IF NOT EXISTS tokens (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				owner_id INTEGER NOT NULL,
+				name TEXT NOT NULL,
+				hash BLOB UNIQUE NOT NULL,
+				scope INTEGER NOT NULL,
+				created INTEGER NOT NULL,
+				expiry INTEGER NOT NULL,
+				last_used INTEGER NOT NULL
+			)`,
+		); err != nil {
+			return err
+		}
+
+		if _, err := db.Exec(
+			`CREATE TABLE IF NOT EXISTS sessions (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				owner_id INTEGER NOT NULL,
+				hash BLOB UNIQUE NOT NULL,
+				ip TEXT NOT NULL,
+				seen INTEGER NOT NULL,
+				expiry INTEGER NOT NULL
+			)`,
+		); err != nil {
+			return err
+		}
+
+		if _, err := db.Exec(
+			`CREATE TABLE IF NOT EXISTS webhooks (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				owner_id INTEGER NOT NULL,
+				repo_id INTEGER NOT NULL,
+				url TEXT NOT NULL,
+				secret TEXT NOT NULL,
+				created INTEGER NOT NULL
+			)`,
+		); err != nil {
+			return err
+		}
+
+		if _, err := db.Exec(
+			`CREATE TABLE IF NOT EXISTS deliveries (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				hook_id INTEGER NOT NULL,
+				event TEXT NOT NULL,
+				payload BLOB NOT NULL,
+				status INTEGER NOT NULL,
+				response TEXT NOT NULL,
+				error TEXT NOT NULL,
+				created INTEGER NOT NULL,
+				duration INTEGER NOT NULL
+			)`,
+		); err != nil {
+			return err
+		}
+
+		if _, err := db.Exec(
+			`CREATE TABLE IF NOT EXISTS push_rules (
+				repo_id INTEGER PRIMARY KEY,
+				max_file_size INTEGER NOT NULL,
+				deny_force_push BOOLEAN NOT NULL,
+				branch_pattern TEXT NOT NULL
+			)`,
+		); err != nil {
+			return err
+		}
+
+		if _, err := db.Exec(
+			`CREATE TABLE IF NOT EXISTS protected_branches (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				repo_id INTEGER NOT NULL,
+				pattern TEXT NOT NULL,
+				created INTEGER NOT NULL,
+				UNIQUE (repo_id, pattern)
+			)`,
+		); err != nil {
+			return err
+		}
+
+		if _, err := db.Exec(
+			`CREATE TABLE IF NOT EXISTS protected_users (
+				protected_id INTEGER NOT NULL,
+				user_id INTEGER NOT NULL,
+				PRIMARY KEY (protected_id, user_id)
+			)`,
+		); err != nil {
+			return err
+		}
+
 		if _, err := db.Exec(fmt.Sprint("PRAGMA user_version = ", latestVersion)); err != nil {
 			return err
 		}
+
+		version = latestVersion
 	}
 
 	for {
 		switch version {
 		case 1: /* 1 -> 2 */
-			log.Println("Migrating database from version 1 to 2")
+			util.Infoln("[database] Migrating database from version 1 to 2")
 
 			if _, err := db.Exec(
 				"ALTER TABLE repos ADD COLUMN default_branch TEXT NOT NULL DEFAULT 'master'",
@@ -98,7 +229,7 @@ func dbUpdate(db *sql.DB) error {
 			version = 2
 
 		case 2: /* 2 -> 3 */
-			log.Println("Migrating database from version 2 to 3")
+			util.Infoln("[database] Migrating database from version 2 to 3")
 
 			if _, err := db.Exec(
 				"ALTER TABLE repos ADD COLUMN visibility INTEGER NOT NULL DEFAULT 0",
@@ -141,6 +272,155 @@ func dbUpdate(db *sql.DB) error {
 
 			version = 3
 
+		case 3: /* 3 -> 4 */
+			util.Infoln("[database] Migrating database from version 3 to 4")
+
+			if _, err := db.Exec(
+				`CREATE TABLE IF NOT EXISTS ssh_keys (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					owner_id INTEGER NOT NULL,
+					name TEXT NOT NULL,
+					key TEXT UNIQUE NOT NULL,
+					fingerprint TEXT NOT NULL,
+					created INTEGER NOT NULL
+				)`,
+			); err != nil {
+				return err
+			}
+
+			version = 4
+
+		case 4: /* 4 -> 5 */
+			util.Infoln("[database] Migrating database from version 4 to 5")
+
+			if _, err := db.Exec(
+				`CREATE TABLE IF NOT EXISTS collaborators (
+					repo_id INTEGER NOT NULL,
+					user_id INTEGER NOT NULL,
+					access INTEGER NOT NULL,
+					PRIMARY KEY (repo_id, user_id)
+				)`,
+			); err != nil {
+				return err
+			}
+
+			version = 5
+
+		case 5: /* 5 -> 6 */
+			util.Infoln("[database] Migrating database from version 5 to 6")
+
+			if _, err := db.Exec(
+				`CREATE TABLE IF NOT EXISTS tokens (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					owner_id INTEGER NOT NULL,
+					name TEXT NOT NULL,
+					hash BLOB UNIQUE NOT NULL,
+					scope INTEGER NOT NULL,
+					created INTEGER NOT NULL,
+					expiry INTEGER NOT NULL,
+					last_used INTEGER NOT NULL
+				)`,
+			); err != nil {
+				return err
+			}
+
+			version = 6
+
+		case 6: /* 6 -> 7 */
+			util.Infoln("[database] Migrating database from version 6 to 7")
+
+			if _, err := db.Exec(
+				`CREATE TABLE IF NOT EXISTS sessions (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					owner_id INTEGER NOT NULL,
+					hash BLOB UNIQUE NOT NULL,
+					ip TEXT NOT NULL,
+					seen INTEGER NOT NULL,
+					expiry INTEGER NOT NULL
+				)`,
+			); err != nil {
+				return err
+			}
+
+			version = 7
+
+		case 7: /* 7 -> 8 */
+			util.Infoln("[database] Migrating database from version 7 to 8")
+
+			if _, err := db.Exec(
+				`CREATE TABLE IF NOT EXISTS webhooks (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					owner_id INTEGER NOT NULL,
+					repo_id INTEGER NOT NULL,
+					url TEXT NOT NULL,
+					secret TEXT NOT NULL,
+					created INTEGER NOT NULL
+				)`,
+			); err != nil {
+				return err
+			}
+
+			if _, err := db.Exec(
+				`CREATE TABLE IF NOT EXISTS deliveries (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					hook_id INTEGER NOT NULL,
+					event TEXT NOT NULL,
+					payload BLOB NOT NULL,
+					status INTEGER NOT NULL,
+					response TEXT NOT NULL,
+					error TEXT NOT NULL,
+					created INTEGER NOT NULL,
+					duration INTEGER NOT NULL
+				)`,
+			); err != nil {
+				return err
+			}
+
+			version = 8
+
+		case 8: /* 8 -> 9 */
+			util.Infoln("[database] Migrating database from version 8 to 9")
+
+			if _, err := db.Exec(
+				`CREATE TABLE IF NOT EXISTS push_rules (
+					repo_id INTEGER PRIMARY KEY,
+					max_file_size INTEGER NOT NULL,
+					deny_force_push BOOLEAN NOT NULL,
+					branch_pattern TEXT NOT NULL
+				)`,
+			); err != nil {
+				return err
+			}
+
+			version = 9
+
+		case 9: /* 9 -> 10 */
+			util.Infoln("[database] Migrating database from version 9 to 10")
+
+			if _, err := db.Exec(
+				`CREATE TABLE IF NOT EXISTS protected_branches (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					repo_id INTEGER NOT NULL,
+					pattern TEXT NOT NULL,
+					created INTEGER NOT NULL,
+					UNIQUE (repo_id, pattern)
+				)`,
+			); err != nil {
+				return err
+			}
+
+			if _, err := db.Exec(
+				`CREATE TABLE IF NOT EXISTS protected_users (
+					protected_id INTEGER NOT NULL,
+					user_id INTEGER NOT NULL,
+					PRIMARY KEY (protected_id, user_id)
+				)`,
+			); err != nil {
+				return err
+			}
+
+			version = 10
+
 		default: /* No required migrations */
 			goto done
 		}
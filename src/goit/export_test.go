@@ -0,0 +1,26 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package goit
+
+type RefUpdate = refUpdate
+
+var (
+	CheckWebhookAddr = checkWebhookAddr
+	SignPayload      = signPayload
+	ReadPushLog      = readPushLog
+	RetainBackups    = retainBackups
+	ExtractTar       = extractTar
+	CheckPushRules   = checkPushRules
+	LargeBlobs       = largeBlobs
+	ProtectedEnv     = protectedEnv
+	GitProtocolEnv   = gitProtocolEnv
+	OpenLogFile      = openLogFile
+	SshExec          = sshExec
+)
+
+func (l *logFile) Due(n int) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.due(n)
+}
@@ -6,44 +6,53 @@ package goit
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
+	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"os/exec"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/Jamozed/Goit/src/util"
 	"github.com/go-chi/chi/v5"
+	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/format/diff"
 	"github.com/go-git/go-git/v5/plumbing/object"
 )
 
 type gitCommand struct {
-	prog string
-	args []string
-	Dir  string
-	env  []string
+	prog   string
+	args   []string
+	Dir    string
+	env    []string
+	Stderr io.Writer
 }
 
+/* The number of Git commands that are running, which are waited for when shutting down. */
+var gitRunning atomic.Int64
+
 func HandleInfoRefs(w http.ResponseWriter, r *http.Request) {
 	service := r.FormValue("service")
 
-	repo := gitHttpBase(w, r, service)
+	repo, _ := gitHttpBase(w, r, service)
 	if repo == nil {
 		return
 	}
 
 	c := NewGitCommand(strings.TrimPrefix(service, "git-"), "--stateless-rpc", "--advertise-refs", ".")
 	c.AddEnv(os.Environ()...)
-	c.AddEnv("GIT_PROTOCOL=version=2")
+	c.AddEnv(gitProtocolEnv(r)...)
 	c.Dir = RepoPath(repo.Name, true)
 
 	refs, _, err := c.Run(nil, nil)
 	if err != nil {
-		log.Println("[Git HTTP]", err.Error())
+		util.Errorln("[Git HTTP]", err.Error())
 		HttpError(w, http.StatusInternalServerError)
 		return
 	}
@@ -62,93 +71,103 @@ func HandleInfoRefs(w http.ResponseWriter, r *http.Request) {
 func HandleUploadPack(w http.ResponseWriter, r *http.Request) {
 	const service = "git-upload-pack"
 
-	repo := gitHttpBase(w, r, service)
+	repo, user := gitHttpBase(w, r, service)
 	if repo == nil {
 		return
 	}
 
-	gitHttpRpc(w, r, service, repo)
+	gitHttpRpc(w, r, service, repo, user)
 }
 
 func HandleReceivePack(w http.ResponseWriter, r *http.Request) {
 	const service = "git-receive-pack"
 
-	repo := gitHttpBase(w, r, service)
+	repo, user := gitHttpBase(w, r, service)
 	if repo == nil {
 		return
 	}
 
-	gitHttpRpc(w, r, service, repo)
+	gitHttpRpc(w, r, service, repo, user)
 }
 
-func gitHttpBase(w http.ResponseWriter, r *http.Request, service string) *Repo {
+/* Check a Git HTTP request, returning the repository and the authenticated user, which is nil for public pulls. */
+func gitHttpBase(w http.ResponseWriter, r *http.Request, service string) (*Repo, *User) {
 	reponame := chi.URLParam(r, "repo")
 
-	/* Check that the Git service and protocol version are supported */
+	/* Check that the Git service is supported */
 	if service != "git-upload-pack" && service != "git-receive-pack" {
 		w.WriteHeader(http.StatusForbidden)
-		return nil
-	}
-	if service == "git-upload-pack" && r.Header.Get("Git-Protocol") != "version=2" {
-		w.WriteHeader(http.StatusForbidden)
-		return nil
+		return nil, nil
 	}
 
 	/* Load the repository from the database */
 	repo, err := GetRepoByName(reponame)
 	if err != nil {
-		log.Println("[Git HTTP]", err.Error())
+		util.Errorln("[Git HTTP]", err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
-		return nil
+		return nil, nil
 	}
 
 	/* Require authentication other than for public pull */
+	var user *User
 	if repo == nil || repo.Visibility != Public || service == "git-receive-pack" {
-		username, password, ok := r.BasicAuth()
-		if !ok {
-			w.Header().Set("WWW-Authenticate", "Basic realm=\"git\"")
-			w.WriteHeader(http.StatusUnauthorized)
-			return nil
-		}
-
-		user, err := GetUserByName(username)
+		u, scope, err := BasicAuth(r)
 		if err != nil {
-			log.Println("[Git HTTP]", err.Error())
+			util.Errorln("[Git HTTP]", err.Error())
 			w.WriteHeader(http.StatusInternalServerError)
-			return nil
+			return nil, nil
 		}
 
-		/* If the user doesn't exist or has invalid credentials */
-		if user == nil || !bytes.Equal(Hash(password, user.Salt), user.Pass) {
+		user = u
+
+		/* If credentials are missing, the user doesn't exist, or has invalid credentials */
+		if user == nil {
 			w.Header().Set("WWW-Authenticate", "Basic realm=\"git\"")
 			w.WriteHeader(http.StatusUnauthorized)
-			return nil
+			return nil, nil
 		}
 
-		/* If the repo doesn't exist or is private and not owned by the user */
-		if repo == nil || (repo.Visibility == Private && user.Id != repo.OwnerId) {
+		/* If the repo doesn't exist or is not visible to the user */
+		if repo == nil || !IsVisible(repo, true, user) {
 			w.WriteHeader(http.StatusNotFound)
-			return nil
+			return nil, nil
+		}
+
+		/* If the user does not have sufficient access for the service */
+		if !gitAllowed(repo, user, scope, service) {
+			w.WriteHeader(http.StatusForbidden)
+			return nil, nil
 		}
 	}
 
 	if repo == nil {
 		w.WriteHeader(http.StatusNotFound)
-		return nil
+		return nil, nil
 	}
 
-	return repo
+	return repo, user
 }
 
-func gitHttpRpc(w http.ResponseWriter, r *http.Request, service string, repo *Repo) {
+/* Check if a user is allowed to use a Git service on a repository, limited by the scope of their credentials. */
+func gitAllowed(repo *Repo, user *User, scope Access, service string) bool {
+	access := min(RepoAccess(repo, user != nil, user), scope)
+
+	if service == "git-receive-pack" {
+		return access >= AccessWrite
+	}
+
+	return access >= AccessRead
+}
+
+func gitHttpRpc(w http.ResponseWriter, r *http.Request, service string, repo *Repo, user *User) {
 	defer func() {
 		if err := r.Body.Close(); err != nil {
-			log.Println("[Git RPC]", err.Error())
+			util.Errorln("[Git RPC]", err.Error())
 		}
 	}()
 
 	if r.Header.Get("Content-Type") != "application/x-"+service+"-request" {
-		log.Println("[Git RPC]", "Content-Type mismatch")
+		util.Errorln("[Git RPC]", "Content-Type mismatch")
 		HttpError(w, http.StatusUnauthorized)
 		return
 	}
@@ -156,7 +175,7 @@ func gitHttpRpc(w http.ResponseWriter, r *http.Request, service string, repo *Re
 	body := r.Body
 	if r.Header.Get("Content-Encoding") == "gzip" {
 		if b, err := gzip.NewReader(r.Body); err != nil {
-			log.Println("[Git RPC]", err.Error())
+			util.Errorln("[Git RPC]", err.Error())
 			HttpError(w, http.StatusInternalServerError)
 			return
 		} else {
@@ -164,22 +183,56 @@ func gitHttpRpc(w http.ResponseWriter, r *http.Request, service string, repo *Re
 		}
 	}
 
-	c := NewGitCommand(strings.TrimPrefix(service, "git-"), "--stateless-rpc", ".")
-	c.AddEnv(os.Environ()...)
-	c.Dir = RepoPath(repo.Name, true)
+	c, err := gitServiceCommand(service, repo, user, "--stateless-rpc", ".")
+	if err != nil {
+		util.Errorln("[Git RPC]", err.Error())
+		HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	c.AddEnv(gitProtocolEnv(r)...)
 
-	if p := r.Header.Get("Git-Protocol"); p == "version=2" {
-		c.AddEnv("GIT_PROTOCOL=version=2")
+	/* Record the refs updated by a push for webhooks */
+	var pushLog string
+	if service == "git-receive-pack" {
+		if pushLog, err = newPushLog(); err != nil {
+			util.Errorln("[Git RPC]", err.Error())
+			HttpError(w, http.StatusInternalServerError)
+			return
+		}
+
+		defer os.Remove(pushLog)
+		c.AddEnv("GOIT_PUSH_LOG=" + pushLog)
 	}
 
 	w.Header().Add("Content-Type", "application/x-"+service+"-result")
 	w.WriteHeader(http.StatusOK)
 
-	if _, _, err := c.Run(body, w); err != nil {
-		log.Println("[Git RPC]", err.Error())
+	gc := newGitCounter(service, repo.Name)
+	if _, _, err := c.Run(gc.Reader(body), gc.Writer(w)); err != nil {
+		util.Errorln("[Git RPC]", err.Error())
 		HttpError(w, http.StatusInternalServerError)
 		return
 	}
+
+	gc.Count()
+
+	if service == "git-receive-pack" {
+		pushWebhooks(repo, user, pushLog)
+	}
+}
+
+/*
+Pass the protocol requested by a client to Git, which serves version 2 if it is requested, and otherwise falls back to
+version 0 or 1.
+*/
+func gitProtocolEnv(r *http.Request) []string {
+	p := r.Header.Get("Git-Protocol")
+	if p == "" || strings.ContainsFunc(p, func(c rune) bool { return c < ' ' || c > '~' }) {
+		return nil
+	}
+
+	return []string{"GIT_PROTOCOL=" + p}
 }
 
 func pktLine(str string) []byte {
@@ -199,7 +252,15 @@ func (C *gitCommand) AddEnv(env ...string) {
 }
 
 func (C *gitCommand) Run(in io.Reader, out io.Writer) ([]byte, []byte, error) {
-	c := exec.Command(C.prog, C.args...)
+	return C.RunContext(context.Background(), in, out)
+}
+
+/* Run the command, killing it if the context is done before it exits. */
+func (C *gitCommand) RunContext(ctx context.Context, in io.Reader, out io.Writer) ([]byte, []byte, error) {
+	gitRunning.Add(1)
+	defer gitRunning.Add(-1)
+
+	c := exec.CommandContext(ctx, C.prog, C.args...)
 	c.Dir = C.Dir
 	c.Env = C.env
 	c.Stdin = in
@@ -209,6 +270,10 @@ func (C *gitCommand) Run(in io.Reader, out io.Writer) ([]byte, []byte, error) {
 	c.Stdout = stdout
 	c.Stderr = os.Stderr
 
+	if C.Stderr != nil {
+		c.Stderr = C.Stderr
+	}
+
 	if out != nil {
 		c.Stdout = out
 	}
@@ -220,6 +285,39 @@ func (C *gitCommand) Run(in io.Reader, out io.Writer) ([]byte, []byte, error) {
 	return stdout.Bytes(), stderr.Bytes(), nil
 }
 
+/* Wait for running Git commands to exit, or for a context to be done. */
+func WaitGitCommands(ctx context.Context) error {
+	t := time.NewTicker(100 * time.Millisecond)
+	defer t.Stop()
+
+	for {
+		n := gitRunning.Load()
+		if n == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d Git commands still running: %w", n, ctx.Err())
+		case <-t.C:
+		}
+	}
+}
+
+/* Resolve a branch, tag, or commit hash to a commit reference, or return HEAD if the revision is empty. */
+func ResolveRef(gr *git.Repository, rev string) (*plumbing.Reference, error) {
+	if rev == "" {
+		return gr.Head()
+	}
+
+	hash, err := gr.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return nil, plumbing.ErrReferenceNotFound
+	}
+
+	return plumbing.NewHashReference(plumbing.ReferenceName(rev), *hash), nil
+}
+
 type DiffStat struct {
 	Name, Prev string
 	Status     string
@@ -242,25 +340,39 @@ func DiffStats(c *object.Commit) ([]DiffStat, error) {
 	}
 	diffsLock.RUnlock()
 
-	from, err := c.Tree()
+	to, err := c.Tree()
 	if err != nil {
 		return nil, err
 	}
 
-	to := &object.Tree{}
+	from := &object.Tree{}
 	if c.NumParents() != 0 {
 		parent, err := c.Parents().Next()
 		if err != nil {
 			return nil, err
 		}
 
-		to, err = parent.Tree()
+		from, err = parent.Tree()
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	patch, err := to.Patch(from)
+	stats, err := TreeDiffStats(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	diffsLock.Lock()
+	diffs[c.Hash] = stats
+	diffsLock.Unlock()
+
+	return stats, nil
+}
+
+/* Calculate the changes to each file between two trees. */
+func TreeDiffStats(from, to *object.Tree) ([]DiffStat, error) {
+	patch, err := from.Patch(to)
 	if err != nil {
 		return nil, err
 	}
@@ -318,10 +430,6 @@ func DiffStats(c *object.Commit) ([]DiffStat, error) {
 		stats = append(stats, stat)
 	}
 
-	diffsLock.Lock()
-	diffs[c.Hash] = stats
-	diffsLock.Unlock()
-
 	return stats, nil
 }
 
@@ -0,0 +1,96 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package goit_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Jamozed/Goit/src/goit"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestGitProtocolEnv(t *testing.T) {
+	tests := []struct {
+		name, header string
+		want         []string
+	}{
+		{"none", "", nil},
+		{"version 1", "version=1", []string{"GIT_PROTOCOL=version=1"}},
+		{"version 2", "version=2", []string{"GIT_PROTOCOL=version=2"}},
+		{"parameters", "version=2:object-format=sha1", []string{"GIT_PROTOCOL=version=2:object-format=sha1"}},
+		{"newline", "version=2\nGIT_DIR=/", nil},
+		{"nul", "version=2\x00", nil},
+		{"non-ascii", "version=2é", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/proj/info/refs", nil)
+			if tt.header != "" {
+				r.Header["Git-Protocol"] = []string{tt.header}
+			}
+
+			if got := goit.GitProtocolEnv(r); !slices.Equal(got, tt.want) {
+				t.Error("Expected", tt.want, "got", got)
+			}
+		})
+	}
+}
+
+func TestInfoRefsProtocol(t *testing.T) {
+	newTestInstance(t)
+
+	if _, err := goit.CreateRepo(goit.Repo{
+		OwnerId: 1, Name: "proj", DefaultBranch: "master", Visibility: goit.Public,
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	hash := testCommit(t, "proj", "master", "Initial commit")
+
+	tests := []struct {
+		name, header string
+		want         string
+	}{
+		{"version 0", "", hash + " HEAD\x00"},
+		{"version 1", "version=1", "version 1\n"},
+		{"version 2", "version=2", "version 2\n"},
+		{"invalid", "version=2\n", hash + " HEAD\x00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/proj/info/refs?service=git-upload-pack", nil)
+			if tt.header != "" {
+				r.Header["Git-Protocol"] = []string{tt.header}
+			}
+
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("repo", "proj")
+			r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+
+			w := httptest.NewRecorder()
+			goit.HandleInfoRefs(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatal("Expected status 200 got", w.Code)
+			}
+
+			body, ok := strings.CutPrefix(w.Body.String(), "001e# service=git-upload-pack\n0000")
+			if !ok {
+				t.Fatal("Expected a service advertisement got", w.Body.String())
+			}
+
+			/* Skip the length of the first packet line */
+			if len(body) < 4 || !strings.HasPrefix(body[4:], tt.want) {
+				t.Errorf("Expected a response beginning %q got %q", tt.want, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,342 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package goit
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Jamozed/Goit/src/util"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+/* Push rules of a repository, checked by the pre-receive hook. */
+type PushRules struct {
+	MaxFileSize   int64
+	DenyForcePush bool
+	BranchPattern string
+}
+
+/* A ref update read by a hook, as given by Git on standard input. */
+type refUpdate struct{ Old, New, Ref string }
+
+var hookNames = []string{"pre-receive", "post-receive"}
+
+/* The path of the hooks directory, containing hook scripts generated by Goit and administrator hook directories. */
+func HooksPath() string {
+	return filepath.Join(Conf.DataPath, "hooks")
+}
+
+/*
+Write the hook scripts that Git runs for receive-pack, which call back into this executable. Administrator scripts are
+installed in "<hook>.d" subdirectories, which are created if they do not exist.
+*/
+func installHooks() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range hookNames {
+		if err := os.MkdirAll(filepath.Join(HooksPath(), name+".d"), 0o777); err != nil {
+			return err
+		}
+
+		script := "#!/bin/sh\nexec " + shellQuote(exe) + " -hook " + name + "\n"
+		if err := os.WriteFile(filepath.Join(HooksPath(), name), []byte(script), 0o755); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+/* Create a command for a Git service, which runs Goit hooks for receive-pack. */
+func gitServiceCommand(service string, repo *Repo, user *User, args ...string) (*gitCommand, error) {
+	c := NewGitCommand(append([]string{strings.TrimPrefix(service, "git-")}, args...)...)
+	c.AddEnv(os.Environ()...)
+	c.Dir = RepoPath(repo.Name, true)
+
+	if service == "git-receive-pack" {
+		rules, err := GetPushRules(repo.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		protected, err := GetProtectedBranches(repo.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		c.args = append([]string{"-c", "core.hooksPath=" + HooksPath()}, c.args...)
+		c.AddEnv(
+			"GOIT_HOOKS_PATH="+HooksPath(),
+			"GOIT_REPO_ID="+fmt.Sprint(repo.Id),
+			"GOIT_REPO_NAME="+repo.Name,
+			"GOIT_USER_ID="+fmt.Sprint(user.Id),
+			"GOIT_USER_NAME="+user.Name,
+			"GOIT_MAX_FILE_SIZE="+fmt.Sprint(rules.MaxFileSize),
+			"GOIT_DENY_FORCE_PUSH="+strconv.FormatBool(rules.DenyForcePush),
+			"GOIT_BRANCH_PATTERN="+rules.BranchPattern,
+			"GOIT_PROTECTED_BRANCHES="+protectedEnv(protected, user),
+		)
+	}
+
+	return c, nil
+}
+
+/* Get the push rules of a repository, or the default rules if none have been set. */
+func GetPushRules(rid int64) (PushRules, error) {
+	var rules PushRules
+
+	if err := db.QueryRow(
+		"SELECT max_file_size, deny_force_push, branch_pattern FROM push_rules WHERE repo_id = ?", rid,
+	).Scan(&rules.MaxFileSize, &rules.DenyForcePush, &rules.BranchPattern); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return PushRules{}, err
+		}
+	}
+
+	return rules, nil
+}
+
+func SetPushRules(rid int64, rules PushRules) error {
+	if _, err := db.Exec(
+		`INSERT INTO push_rules (repo_id, max_file_size, deny_force_push, branch_pattern) VALUES (?, ?, ?, ?)
+		ON CONFLICT (repo_id) DO UPDATE SET max_file_size = excluded.max_file_size,
+		deny_force_push = excluded.deny_force_push, branch_pattern = excluded.branch_pattern`,
+		rid, rules.MaxFileSize, rules.DenyForcePush, rules.BranchPattern,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+/* Check push rules, returning a message if they are invalid. */
+func ValidatePushRules(rules PushRules) string {
+	if rules.MaxFileSize < 0 {
+		return "Maximum file size cannot be negative"
+	}
+
+	if len(rules.BranchPattern) > 256 {
+		return "Branch pattern cannot exceed 256 characters"
+	} else if _, err := regexp.Compile(rules.BranchPattern); err != nil {
+		return "Branch pattern is invalid: " + err.Error()
+	}
+
+	return ""
+}
+
+/*
+Run a Git hook, called by the scripts installed by installHooks, returning its exit status. The pre-receive hook checks
+the push rules of the repository, then any administrator scripts are run with the same input. Output is written to
+standard error, which Git relays to the client.
+*/
+func RunHook(name string) int {
+	if !slices.Contains(hookNames, name) {
+		fmt.Fprintln(os.Stderr, "goit: unknown hook", name)
+		return 1
+	}
+
+	input, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "goit:", err.Error())
+		return 1
+	}
+
+	if name == "pre-receive" {
+		if msgs, err := checkPushRules(parseRefUpdates(string(input))); err != nil {
+			fmt.Fprintln(os.Stderr, "goit:", err.Error())
+			return 1
+		} else if len(msgs) != 0 {
+			for _, msg := range msgs {
+				fmt.Fprintln(os.Stderr, "goit:", msg)
+			}
+
+			return 1
+		}
+	}
+
+	/* Record the updated refs for push webhooks, before scripts are run as they cannot undo the push */
+	if path := os.Getenv("GOIT_PUSH_LOG"); name == "post-receive" && path != "" {
+		if err := os.WriteFile(path, input, 0o600); err != nil {
+			fmt.Fprintln(os.Stderr, "goit:", err.Error())
+		}
+	}
+
+	return runHookScripts(name, input)
+}
+
+/* Parse the "<old> <new> <ref>" lines that Git gives hooks on standard input. */
+func parseRefUpdates(input string) []refUpdate {
+	var updates []refUpdate
+	for _, line := range strings.Split(strings.TrimSpace(input), "\n") {
+		if f := strings.Fields(line); len(f) == 3 {
+			updates = append(updates, refUpdate{Old: f[0], New: f[1], Ref: f[2]})
+		}
+	}
+
+	return updates
+}
+
+/* Check ref updates against the push rules in the environment, returning a message for each violation. */
+func checkPushRules(updates []refUpdate) ([]string, error) {
+	var msgs []string
+	zero := plumbing.ZeroHash.String()
+
+	maxSize, _ := strconv.ParseInt(os.Getenv("GOIT_MAX_FILE_SIZE"), 10, 64)
+	denyForce := os.Getenv("GOIT_DENY_FORCE_PUSH") == "true"
+
+	var pattern *regexp.Regexp
+	p := os.Getenv("GOIT_BRANCH_PATTERN")
+	if p != "" {
+		var err error
+		if pattern, err = regexp.Compile("^(?:" + p + ")$"); err != nil {
+			return nil, err
+		}
+	}
+
+	/* Protected branch patterns, mapped to whether the pushing user may push to them */
+	protected := map[string]bool{}
+	for _, line := range strings.Split(os.Getenv("GOIT_PROTECTED_BRANCHES"), "\n") {
+		if allow, glob, ok := strings.Cut(line, " "); ok {
+			protected[glob] = allow == "1"
+		}
+	}
+
+	for _, u := range updates {
+		branch, isBranch := strings.CutPrefix(u.Ref, "refs/heads/")
+
+		/* Check protected branches, forbidding force pushes regardless of the push rules */
+		isProtected, allowed := false, true
+		for glob, allow := range protected {
+			if isBranch && (ProtectedBranch{Pattern: glob}).Matches(branch) {
+				isProtected, allowed = true, allowed && allow
+			}
+		}
+
+		if isProtected && !allowed {
+			msgs = append(msgs, "branch \""+branch+"\" is protected, you are not allowed to push to it")
+		} else if isProtected && u.New == zero {
+			msgs = append(msgs, "branch \""+branch+"\" is protected and cannot be deleted")
+		}
+
+		if isBranch && pattern != nil && u.Old == zero && !pattern.MatchString(branch) {
+			msgs = append(msgs, "branch \""+branch+"\" does not match the pattern \""+p+"\"")
+		}
+
+		if isBranch && (denyForce || isProtected) && u.Old != zero && u.New != zero {
+			c := NewGitCommand("merge-base", "--is-ancestor", u.Old, u.New)
+			if _, _, err := c.Run(nil, nil); err != nil {
+				var ee *exec.ExitError
+				if !errors.As(err, &ee) || ee.ExitCode() != 1 {
+					return nil, err
+				}
+
+				msgs = append(msgs, "force pushing to \""+branch+"\" is not allowed"+
+					util.If(isProtected, ", it is protected", ""))
+			}
+		}
+
+		if maxSize > 0 && u.New != zero {
+			large, err := largeBlobs(u.New, maxSize)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, path := range large {
+				msgs = append(msgs, fmt.Sprint("file \"", path, "\" in ", u.Ref, " exceeds ", maxSize, " bytes"))
+			}
+		}
+	}
+
+	return msgs, nil
+}
+
+/* Find the paths of new blobs reachable from a commit that exceed a size. */
+func largeBlobs(rev string, size int64) ([]string, error) {
+	objs, _, err := NewGitCommand("rev-list", "--objects", rev, "--not", "--all").Run(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c := NewGitCommand("cat-file", "--batch-check=%(objecttype) %(objectsize) %(rest)")
+	out, _, err := c.Run(bytes.NewReader(objs), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, line := range strings.Split(string(out), "\n") {
+		typ, rest, _ := strings.Cut(line, " ")
+		n, path, _ := strings.Cut(rest, " ")
+
+		if typ != "blob" {
+			continue
+		}
+
+		if i, err := strconv.ParseInt(n, 10, 64); err == nil && i > size && !slices.Contains(paths, path) {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths, nil
+}
+
+/* Run the administrator scripts for a hook in lexical order, stopping at the first that fails. */
+func runHookScripts(name string, input []byte) int {
+	dir := filepath.Join(os.Getenv("GOIT_HOOKS_PATH"), name+".d")
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0
+		}
+
+		fmt.Fprintln(os.Stderr, "goit:", err.Error())
+		return 1
+	}
+
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+
+		if info, err := e.Info(); err != nil || info.Mode()&0o111 == 0 {
+			continue
+		}
+
+		c := exec.Command(filepath.Join(dir, e.Name()))
+		c.Stdin = bytes.NewReader(input)
+		c.Stdout = os.Stderr
+		c.Stderr = os.Stderr
+
+		if err := c.Run(); err != nil {
+			var ee *exec.ExitError
+			if errors.As(err, &ee) && ee.ExitCode() > 0 {
+				return ee.ExitCode()
+			}
+
+			fmt.Fprintln(os.Stderr, "goit:", e.Name()+":", err.Error())
+			return 1
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,173 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package goit_test
+
+import (
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Jamozed/Goit/src/goit"
+)
+
+/*
+Create a commit of files of the given sizes in a repository without updating any ref, returning its hash. The commit
+has no parent unless one is given.
+*/
+func testFileCommit(t *testing.T, repo, parent string, files map[string]int) string {
+	t.Helper()
+
+	git := func(in string, args ...string) string {
+		c := exec.Command("git", append([]string{"--git-dir", goit.RepoPath(repo, true)}, args...)...)
+		c.Stdin = strings.NewReader(in)
+
+		out, err := c.Output()
+		if err != nil {
+			t.Fatal("git", args, err.Error())
+		}
+
+		return strings.TrimSpace(string(out))
+	}
+
+	var tree []string
+	for name, size := range files {
+		blob := git(strings.Repeat("x", size), "hash-object", "-w", "--stdin")
+		tree = append(tree, "100644 blob "+blob+"\t"+name)
+	}
+
+	args := []string{"commit-tree", git(strings.Join(tree, "\n")+"\n", "mktree"), "-m", "Files"}
+	if parent != "" {
+		args = append(args, "-p", parent)
+	}
+
+	return git("", args...)
+}
+
+func TestCheckPushRules(t *testing.T) {
+	newTestInstance(t)
+
+	if err := goit.CreateUser(goit.User{Name: "alice", Pass: []byte{}, Salt: []byte{}}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if _, err := goit.CreateRepo(goit.Repo{OwnerId: 1, Name: "proj", DefaultBranch: "master"}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	/* The hook runs in the repository, with the rules in its environment */
+	t.Setenv("GIT_DIR", goit.RepoPath("proj", true))
+
+	zero := strings.Repeat("0", 40)
+	base := testCommit(t, "proj", "master", "Initial commit")
+	child := testFileCommit(t, "proj", base, map[string]int{"small.txt": 10})
+	large := testFileCommit(t, "proj", base, map[string]int{"small.txt": 10, "large.bin": 2048})
+	other := testFileCommit(t, "proj", "", map[string]int{"other.txt": 10})
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		updates []goit.RefUpdate
+		want    []string
+	}{
+		{
+			"no rules", nil,
+			[]goit.RefUpdate{{base, other, "refs/heads/master"}, {zero, large, "refs/heads/x"}},
+			nil,
+		},
+		{
+			"max file size", map[string]string{"GOIT_MAX_FILE_SIZE": "1024"},
+			[]goit.RefUpdate{{base, child, "refs/heads/master"}, {zero, large, "refs/heads/big"}},
+			[]string{"file \"large.bin\" in refs/heads/big exceeds 1024 bytes"},
+		},
+		{
+			"max file size deletion", map[string]string{"GOIT_MAX_FILE_SIZE": "1024"},
+			[]goit.RefUpdate{{large, zero, "refs/heads/big"}},
+			nil,
+		},
+		{
+			"deny force push", map[string]string{"GOIT_DENY_FORCE_PUSH": "true"},
+			[]goit.RefUpdate{
+				{base, child, "refs/heads/master"}, {base, other, "refs/heads/dev"}, {base, other, "refs/tags/v1"},
+			},
+			[]string{"force pushing to \"dev\" is not allowed"},
+		},
+		{
+			"branch pattern", map[string]string{"GOIT_BRANCH_PATTERN": "master|feature/.+"},
+			[]goit.RefUpdate{
+				{zero, child, "refs/heads/feature/a"}, {zero, child, "refs/heads/fix"},
+				{base, child, "refs/heads/old"}, {zero, child, "refs/tags/v1"},
+			},
+			[]string{"branch \"fix\" does not match the pattern \"master|feature/.+\""},
+		},
+		{
+			"protected", map[string]string{"GOIT_PROTECTED_BRANCHES": "1 master\n0 release/*"},
+			[]goit.RefUpdate{
+				{base, child, "refs/heads/master"}, {base, other, "refs/heads/master"},
+				{base, zero, "refs/heads/master"}, {zero, child, "refs/heads/release/1"},
+			},
+			[]string{
+				"force pushing to \"master\" is not allowed, it is protected",
+				"branch \"master\" is protected and cannot be deleted",
+				"branch \"release/1\" is protected, you are not allowed to push to it",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range []string{
+				"GOIT_MAX_FILE_SIZE", "GOIT_DENY_FORCE_PUSH", "GOIT_BRANCH_PATTERN", "GOIT_PROTECTED_BRANCHES",
+			} {
+				t.Setenv(k, tt.env[k])
+			}
+
+			msgs, err := goit.CheckPushRules(tt.updates)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			if !slices.Equal(msgs, tt.want) {
+				t.Error("Expected", tt.want, "got", msgs)
+			}
+		})
+	}
+
+	t.Run("invalid pattern", func(t *testing.T) {
+		t.Setenv("GOIT_BRANCH_PATTERN", "(")
+
+		if _, err := goit.CheckPushRules(nil); err == nil {
+			t.Error("Expected an invalid branch pattern to fail")
+		}
+	})
+}
+
+func TestLargeBlobs(t *testing.T) {
+	newTestInstance(t)
+
+	if _, err := goit.CreateRepo(goit.Repo{OwnerId: 1, Name: "proj", DefaultBranch: "master"}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	t.Setenv("GIT_DIR", goit.RepoPath("proj", true))
+
+	/* Blobs that are already reachable from a ref are not new */
+	base := testFileCommit(t, "proj", "", map[string]int{"pushed.bin": 2048})
+	if out, err := exec.Command("git", "update-ref", "refs/heads/master", base).CombinedOutput(); err != nil {
+		t.Fatal(string(out))
+	}
+
+	head := testFileCommit(t, "proj", base, map[string]int{
+		"pushed.bin": 2048, "small.txt": 100, "exact.bin": 1024, "large.bin": 1025,
+	})
+
+	paths, err := goit.LargeBlobs(head, 1024)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !slices.Equal(paths, []string{"large.bin"}) {
+		t.Error("Expected [large.bin] got", paths)
+	}
+}
@@ -17,6 +17,7 @@ var Tmpl = template.Must(template.New("error").Parse(res.Error))
 func init() {
 	template.Must(Tmpl.New("index").Parse(res.Index))
 	template.Must(Tmpl.New("base/head").Parse(res.BaseHead))
+	template.Must(Tmpl.New("base/webhooks").Parse(res.BaseWebhooks))
 
 	template.Must(Tmpl.New("admin/header").Parse(res.AdminHeader))
 	template.Must(Tmpl.New("admin/status").Parse(res.AdminStatus))
@@ -31,6 +32,9 @@ func init() {
 	template.Must(Tmpl.New("user/login").Parse(res.UserLogin))
 	template.Must(Tmpl.New("user/sessions").Parse(res.UserSessions))
 	template.Must(Tmpl.New("user/edit").Parse(res.UserEdit))
+	template.Must(Tmpl.New("user/keys").Parse(res.UserKeys))
+	template.Must(Tmpl.New("user/tokens").Parse(res.UserTokens))
+	template.Must(Tmpl.New("user/webhooks").Parse(res.UserWebhooks))
 
 	template.Must(Tmpl.New("repo/header").Parse(res.RepoHeader))
 	template.Must(Tmpl.New("repo/create").Parse(res.RepoCreate))
@@ -40,6 +44,8 @@ func init() {
 	template.Must(Tmpl.New("repo/commit").Parse(res.RepoCommit))
 	template.Must(Tmpl.New("repo/tree").Parse(res.RepoTree))
 	template.Must(Tmpl.New("repo/file").Parse(res.RepoFile))
+	template.Must(Tmpl.New("repo/blame").Parse(res.RepoBlame))
+	template.Must(Tmpl.New("repo/compare").Parse(res.RepoCompare))
 	template.Must(Tmpl.New("repo/refs").Parse(res.RepoRefs))
 }
 
@@ -5,12 +5,12 @@ package goit
 
 import (
 	"errors"
-	"log"
 	"net/http"
 	"sort"
 	"strings"
 	"time"
 
+	"github.com/Jamozed/Goit/src/util"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
 )
@@ -18,7 +18,7 @@ import (
 func HandleIndex(w http.ResponseWriter, r *http.Request) {
 	auth, user, err := Auth(w, r, true)
 	if err != nil {
-		log.Println("[index]", err.Error())
+		util.Errorln("[index]", err.Error())
 		HttpError(w, http.StatusInternalServerError)
 		return
 	}
@@ -39,14 +39,21 @@ func HandleIndex(w http.ResponseWriter, r *http.Request) {
 
 	repos, err := GetRepos()
 	if err != nil {
-		log.Println("[/]", err.Error())
+		util.Errorln("[/]", err.Error())
+		HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	grants, err := GetGrants(user)
+	if err != nil {
+		util.Errorln("[/]", err.Error())
 		HttpError(w, http.StatusInternalServerError)
 		return
 	}
 
 	rtemp := repos[:0]
 	for _, repo := range repos {
-		if IsVisible(&repo, auth, user) {
+		if grants.IsVisible(&repo, auth, user) {
 			rtemp = append(rtemp, repo)
 		}
 	}
@@ -60,7 +67,7 @@ func HandleIndex(w http.ResponseWriter, r *http.Request) {
 	for _, repo := range repos {
 		owner, err := GetUser(repo.OwnerId)
 		if err != nil {
-			log.Println("[/]", err.Error())
+			util.Errorln("[/]", err.Error())
 		}
 
 		/* Only display repositories matching user query if present */
@@ -70,13 +77,13 @@ func HandleIndex(w http.ResponseWriter, r *http.Request) {
 
 		var lastCommit string
 		if gr, err := git.PlainOpen(RepoPath(repo.Name, true)); err != nil {
-			log.Println("[/]", err.Error())
+			util.Errorln("[/]", err.Error())
 		} else if ref, err := gr.Head(); err != nil {
 			if !errors.Is(err, plumbing.ErrReferenceNotFound) {
-				log.Println("[/]", err.Error())
+				util.Errorln("[/]", err.Error())
 			}
 		} else if commit, err := gr.CommitObject(ref.Hash()); err != nil {
-			log.Println("[/]", err.Error())
+			util.Errorln("[/]", err.Error())
 		} else {
 			lastCommit = commit.Author.When.UTC().Format(time.DateTime)
 		}
@@ -88,6 +95,6 @@ func HandleIndex(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := Tmpl.ExecuteTemplate(w, "index", data); err != nil {
-		log.Println("[/]", err.Error())
+		util.Errorln("[/]", err.Error())
 	}
 }
@@ -0,0 +1,129 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package goit
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type SshKey struct {
+	Id          int64     `json:"id"`
+	OwnerId     int64     `json:"owner_id"`
+	Name        string    `json:"name"`
+	Key         string    `json:"key"`
+	Fingerprint string    `json:"fingerprint"`
+	Created     time.Time `json:"created"`
+}
+
+/* Parse an authorized_keys formatted public key, using its comment as the default name. */
+func ParseSshKey(s string) (SshKey, error) {
+	pub, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(s))
+	if err != nil {
+		return SshKey{}, err
+	}
+
+	return SshKey{
+		Name:        comment,
+		Key:         strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))),
+		Fingerprint: ssh.FingerprintSHA256(pub),
+	}, nil
+}
+
+func GetSshKeys(uid int64) ([]SshKey, error) {
+	keys := []SshKey{}
+
+	rows, err := db.Query(
+		"SELECT id, owner_id, name, key, fingerprint, created FROM ssh_keys WHERE owner_id = ? ORDER BY id", uid,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		k := SshKey{}
+		var created int64
+
+		if err := rows.Scan(&k.Id, &k.OwnerId, &k.Name, &k.Key, &k.Fingerprint, &created); err != nil {
+			return nil, err
+		}
+
+		k.Created = time.Unix(created, 0)
+		keys = append(keys, k)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+/* Count the SSH keys of each user that has any. */
+func CountSshKeys() (map[int64]int, error) {
+	counts := map[int64]int{}
+
+	rows, err := db.Query("SELECT owner_id, COUNT(*) FROM ssh_keys GROUP BY owner_id")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var uid int64
+		var n int
+
+		if err := rows.Scan(&uid, &n); err != nil {
+			return nil, err
+		}
+
+		counts[uid] = n
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+/* Check if a public key is registered to any user. */
+func SshKeyExists(key string) (bool, error) {
+	if err := db.QueryRow("SELECT key FROM ssh_keys WHERE key = ?", key).Scan(&key); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return false, err
+		}
+
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func CreateSshKey(key SshKey) error {
+	if _, err := db.Exec(
+		"INSERT INTO ssh_keys (owner_id, name, key, fingerprint, created) VALUES (?, ?, ?, ?, ?)",
+		key.OwnerId, key.Name, key.Key, key.Fingerprint, time.Now().Unix(),
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+/* Delete an SSH key owned by a user. */
+func DelSshKey(uid, kid int64) error {
+	if _, err := db.Exec("DELETE FROM ssh_keys WHERE id = ? AND owner_id = ?", kid, uid); err != nil {
+		return err
+	}
+
+	return nil
+}
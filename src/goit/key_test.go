@@ -0,0 +1,130 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package goit_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/Jamozed/Goit/src/goit"
+	"golang.org/x/crypto/ssh"
+)
+
+/* Generate a public key in the authorized_keys format, with a comment. */
+func testSshKey(t *testing.T, comment string) (ssh.PublicKey, string) {
+	t.Helper()
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return key, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))) + " " + comment
+}
+
+func TestSshKeys(t *testing.T) {
+	newTestInstance(t)
+
+	for _, name := range []string{"alice", "bob"} {
+		if err := goit.CreateUser(goit.User{Name: name, Pass: []byte{}, Salt: []byte{}}); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	pub, s := testSshKey(t, "alice@laptop")
+	key, err := goit.ParseSshKey(s)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if key.Name != "alice@laptop" || key.Fingerprint != ssh.FingerprintSHA256(pub) {
+		t.Error("Expected the comment and fingerprint of the key, got", key.Name, key.Fingerprint)
+	}
+
+	key.OwnerId = 1
+	if err := goit.CreateSshKey(key); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	t.Run("add", func(t *testing.T) {
+		keys, err := goit.GetSshKeys(1)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if len(keys) != 1 || keys[0].Key != key.Key || keys[0].Name != key.Name {
+			t.Fatal("Expected", key.Key, "got", keys)
+		}
+
+		if user, err := goit.GetUserBySshKey(pub); err != nil {
+			t.Fatal(err.Error())
+		} else if user == nil || user.Id != 1 {
+			t.Error("Expected the key to authenticate alice, got", user)
+		}
+
+		if counts, err := goit.CountSshKeys(); err != nil {
+			t.Fatal(err.Error())
+		} else if counts[1] != 1 || counts[2] != 0 {
+			t.Error("Expected 1 key for alice and 0 for bob, got", counts)
+		}
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		if exists, err := goit.SshKeyExists(key.Key); err != nil {
+			t.Fatal(err.Error())
+		} else if !exists {
+			t.Error("Expected the key to exist")
+		}
+
+		/* A key cannot be added twice, even by another user with another name */
+		dup := goit.SshKey{OwnerId: 2, Name: "bob", Key: key.Key, Fingerprint: key.Fingerprint}
+		if err := goit.CreateSshKey(dup); err == nil {
+			t.Error("Expected a duplicate key to be rejected")
+		}
+
+		if keys, err := goit.GetSshKeys(2); err != nil {
+			t.Fatal(err.Error())
+		} else if len(keys) != 0 {
+			t.Error("Expected bob to have no keys, got", keys)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		keys, err := goit.GetSshKeys(1)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		/* A key is only deleted by its owner */
+		for _, uid := range []int64{2, 1} {
+			if err := goit.DelSshKey(uid, keys[0].Id); err != nil {
+				t.Fatal(err.Error())
+			}
+
+			user, err := goit.GetUserBySshKey(pub)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			if uid == 2 && user == nil {
+				t.Error("Expected the key to remain after bob deleted it")
+			} else if uid == 1 && user != nil {
+				t.Error("Expected the key to be deleted by alice, got", user)
+			}
+		}
+
+		if counts, err := goit.CountSshKeys(); err != nil {
+			t.Fatal(err.Error())
+		} else if len(counts) != 0 {
+			t.Error("Expected no keys, got", counts)
+		}
+	})
+}
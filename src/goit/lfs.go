@@ -0,0 +1,354 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package goit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Jamozed/Goit/src/util"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const lfsMediaType = "application/vnd.git-lfs+json"
+const lfsPointerMax = 1024
+
+var lfsOidPattern = regexp.MustCompile("^[0-9a-f]{64}$")
+
+/* A Git LFS pointer file, which stands in for the content of a file stored as an LFS object. */
+type LfsPointer struct {
+	Oid  string
+	Size int64
+}
+
+type lfsObject struct {
+	Oid           string                `json:"oid"`
+	Size          int64                 `json:"size"`
+	Authenticated bool                  `json:"authenticated,omitempty"`
+	Actions       map[string]*lfsAction `json:"actions,omitempty"`
+	Error         *lfsObjectError       `json:"error,omitempty"`
+}
+
+type lfsAction struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header,omitempty"`
+}
+
+type lfsObjectError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+/* The path of the directory containing the LFS objects of a repository. */
+func LfsRepoPath(rid int64) string {
+	return filepath.Join(Conf.DataPath, "lfs", fmt.Sprint(rid))
+}
+
+/* The path of an LFS object of a repository. */
+func LfsPath(rid int64, oid string) string {
+	return filepath.Join(LfsRepoPath(rid), oid[0:2], oid[2:4], oid)
+}
+
+/* Parse a Git LFS pointer file, reporting whether the content is a valid pointer. */
+func ParseLfsPointer(b []byte) (LfsPointer, bool) {
+	var p LfsPointer
+
+	if len(b) >= lfsPointerMax || !bytes.HasPrefix(b, []byte("version https://git-lfs.github.com/spec/v1\n")) {
+		return p, false
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		if v, ok := strings.CutPrefix(line, "oid sha256:"); ok {
+			p.Oid = v
+		} else if v, ok := strings.CutPrefix(line, "size "); ok {
+			size, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || size < 0 {
+				return p, false
+			}
+
+			p.Size = size
+		}
+	}
+
+	return p, lfsOidPattern.MatchString(p.Oid)
+}
+
+/* Read a file of a repository as a Git LFS pointer, reporting whether it is one. */
+func GetLfsPointer(file *object.File) (LfsPointer, bool) {
+	if file.Size >= lfsPointerMax {
+		return LfsPointer{}, false
+	}
+
+	s, err := file.Contents()
+	if err != nil {
+		return LfsPointer{}, false
+	}
+
+	return ParseLfsPointer([]byte(s))
+}
+
+/* Check if an LFS object of a repository is stored, with the expected size. */
+func LfsExists(rid int64, p LfsPointer) bool {
+	info, err := os.Stat(LfsPath(rid, p.Oid))
+	return err == nil && info.Mode().IsRegular() && info.Size() == p.Size
+}
+
+/*
+Open the content of a file of a repository, returning a reader and the size of the content. Git LFS pointer files are
+resolved to their LFS object if it is stored, otherwise the pointer itself is returned.
+*/
+func OpenFile(repo *Repo, file *object.File) (io.ReadCloser, int64, error) {
+	if p, ok := GetLfsPointer(file); ok && LfsExists(repo.Id, p) {
+		f, err := os.Open(LfsPath(repo.Id, p.Oid))
+		return f, p.Size, err
+	}
+
+	rc, err := file.Blob.Reader()
+	return rc, file.Size, err
+}
+
+/* Handle a Git LFS batch API request, granting upload or download actions using the basic transfer adapter. */
+func HandleLfsBatch(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Operation string      `json:"operation"`
+		Transfers []string    `json:"transfers"`
+		Objects   []lfsObject `json:"objects"`
+		HashAlgo  string      `json:"hash_algo"`
+	}{}
+
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		lfsError(w, http.StatusUnprocessableEntity, "Invalid batch request")
+		return
+	}
+
+	service := ""
+	switch req.Operation {
+	case "download":
+		service = "git-upload-pack"
+	case "upload":
+		service = "git-receive-pack"
+	default:
+		lfsError(w, http.StatusUnprocessableEntity, "Invalid operation \""+req.Operation+"\"")
+		return
+	}
+
+	if req.HashAlgo != "" && req.HashAlgo != "sha256" {
+		lfsError(w, http.StatusConflict, "Unsupported hash algorithm \""+req.HashAlgo+"\"")
+		return
+	}
+
+	if len(req.Transfers) != 0 && !slices.Contains(req.Transfers, "basic") {
+		lfsError(w, http.StatusUnprocessableEntity, "Unsupported transfer adapters")
+		return
+	}
+
+	repo, _ := gitHttpBase(w, r, service)
+	if repo == nil {
+		return
+	}
+
+	rules, err := GetPushRules(repo.Id)
+	if err != nil {
+		util.Errorln("[LFS]", err.Error())
+		lfsError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	href := util.If(Conf.UsesHttps, "https://", "http://") + r.Host + "/" + repo.Name + "/info/lfs/objects/"
+	header := map[string]string{}
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		header["Authorization"] = auth
+	}
+
+	objects := make([]lfsObject, 0, len(req.Objects))
+	for _, o := range req.Objects {
+		obj := lfsObject{Oid: o.Oid, Size: o.Size, Authenticated: true}
+
+		switch {
+		case !lfsOidPattern.MatchString(o.Oid) || o.Size < 0:
+			obj.Error = &lfsObjectError{http.StatusUnprocessableEntity, "Invalid object"}
+
+		case req.Operation == "download":
+			if !LfsExists(repo.Id, LfsPointer{o.Oid, o.Size}) {
+				obj.Error = &lfsObjectError{http.StatusNotFound, "Object does not exist"}
+			} else {
+				obj.Actions = map[string]*lfsAction{"download": {Href: href + o.Oid, Header: header}}
+			}
+
+		case rules.MaxFileSize > 0 && o.Size > rules.MaxFileSize:
+			obj.Error = &lfsObjectError{
+				http.StatusUnprocessableEntity, fmt.Sprint("Object exceeds ", rules.MaxFileSize, " bytes"),
+			}
+
+		case !LfsExists(repo.Id, LfsPointer{o.Oid, o.Size}):
+			obj.Actions = map[string]*lfsAction{
+				"upload": {Href: href + o.Oid, Header: header},
+				"verify": {Href: href + "verify", Header: header},
+			}
+		}
+
+		objects = append(objects, obj)
+	}
+
+	w.Header().Set("Content-Type", lfsMediaType)
+	if err := json.NewEncoder(w).Encode(map[string]any{
+		"transfer": "basic", "objects": objects, "hash_algo": "sha256",
+	}); err != nil {
+		util.Errorln("[LFS]", err.Error())
+	}
+}
+
+/* Handle a Git LFS basic transfer download. */
+func HandleLfsDownload(w http.ResponseWriter, r *http.Request) {
+	oid := chi.URLParam(r, "oid")
+
+	repo, _ := gitHttpBase(w, r, "git-upload-pack")
+	if repo == nil {
+		return
+	}
+
+	if !lfsOidPattern.MatchString(oid) {
+		lfsError(w, http.StatusNotFound, "Object does not exist")
+		return
+	}
+
+	f, err := os.Open(LfsPath(repo.Id, oid))
+	if errors.Is(err, os.ErrNotExist) {
+		lfsError(w, http.StatusNotFound, "Object does not exist")
+		return
+	} else if err != nil {
+		util.Errorln("[LFS]", err.Error())
+		lfsError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		util.Errorln("[LFS]", err.Error())
+		lfsError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, oid, info.ModTime(), f)
+}
+
+/*
+Handle a Git LFS basic transfer upload. The object is written to a temporary file and only moved into place once its
+content matches the object ID.
+*/
+func HandleLfsUpload(w http.ResponseWriter, r *http.Request) {
+	oid := chi.URLParam(r, "oid")
+
+	repo, _ := gitHttpBase(w, r, "git-receive-pack")
+	if repo == nil {
+		return
+	}
+
+	if !lfsOidPattern.MatchString(oid) {
+		lfsError(w, http.StatusUnprocessableEntity, "Invalid object ID")
+		return
+	}
+
+	rules, err := GetPushRules(repo.Id)
+	if err != nil {
+		util.Errorln("[LFS]", err.Error())
+		lfsError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if rules.MaxFileSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, rules.MaxFileSize)
+	}
+
+	dst := LfsPath(repo.Id, oid)
+	if err := os.MkdirAll(filepath.Dir(dst), 0o777); err != nil {
+		util.Errorln("[LFS]", err.Error())
+		lfsError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(dst), ".upload-")
+	if err != nil {
+		util.Errorln("[LFS]", err.Error())
+		lfsError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	defer os.Remove(f.Name())
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), r.Body); err != nil {
+		f.Close()
+
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			lfsError(w, http.StatusRequestEntityTooLarge, fmt.Sprint("Object exceeds ", mbe.Limit, " bytes"))
+		} else {
+			util.Errorln("[LFS]", err.Error())
+			lfsError(w, http.StatusInternalServerError, "Internal server error")
+		}
+
+		return
+	}
+
+	if err := f.Close(); err != nil {
+		util.Errorln("[LFS]", err.Error())
+		lfsError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if hex.EncodeToString(h.Sum(nil)) != oid {
+		lfsError(w, http.StatusUnprocessableEntity, "Object content does not match its ID")
+		return
+	}
+
+	if err := os.Rename(f.Name(), dst); err != nil {
+		util.Errorln("[LFS]", err.Error())
+		lfsError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+/* Handle a Git LFS basic transfer verification, confirming that an uploaded object is stored. */
+func HandleLfsVerify(w http.ResponseWriter, r *http.Request) {
+	repo, _ := gitHttpBase(w, r, "git-receive-pack")
+	if repo == nil {
+		return
+	}
+
+	var obj lfsObject
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&obj); err != nil {
+		lfsError(w, http.StatusUnprocessableEntity, "Invalid verify request")
+		return
+	}
+
+	if !lfsOidPattern.MatchString(obj.Oid) || !LfsExists(repo.Id, LfsPointer{obj.Oid, obj.Size}) {
+		lfsError(w, http.StatusNotFound, "Object does not exist")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func lfsError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", lfsMediaType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
@@ -0,0 +1,158 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package goit_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Jamozed/Goit/src/goit"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestParseLfsPointer(t *testing.T) {
+	oid := strings.Repeat("ab", 32)
+	header := "version https://git-lfs.github.com/spec/v1\n"
+
+	tests := []struct {
+		name    string
+		content string
+		ok      bool
+		size    int64
+	}{
+		{"valid", header + "oid sha256:" + oid + "\nsize 1234\n", true, 1234},
+		{"no trailing newline", header + "oid sha256:" + oid + "\nsize 0", true, 0},
+		{"no version", "oid sha256:" + oid + "\nsize 1234\n", false, 0},
+		{"other version", "version https://example.com/v2\noid sha256:" + oid + "\nsize 1\n", false, 0},
+		{"no oid", header + "size 1234\n", false, 0},
+		{"short oid", header + "oid sha256:" + oid[:63] + "\nsize 1\n", false, 0},
+		{"uppercase oid", header + "oid sha256:" + strings.ToUpper(oid) + "\nsize 1\n", false, 0},
+		{"path oid", header + "oid sha256:../../" + oid[6:] + "\nsize 1\n", false, 0},
+		{"other hash", header + "oid sha1:" + oid[:40] + "\nsize 1\n", false, 0},
+		{"negative size", header + "oid sha256:" + oid + "\nsize -1\n", false, 0},
+		{"invalid size", header + "oid sha256:" + oid + "\nsize 1k\n", false, 0},
+		{"too large", header + "oid sha256:" + oid + "\nsize 1\n" + strings.Repeat("x", 1024), false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, ok := goit.ParseLfsPointer([]byte(tt.content))
+			if ok != tt.ok {
+				t.Fatal("Expected ok", tt.ok, "got", ok)
+			}
+
+			if ok && (p.Oid != oid || p.Size != tt.size) {
+				t.Error("Expected", oid, tt.size, "got", p.Oid, p.Size)
+			}
+		})
+	}
+}
+
+/* Create a request to an LFS route of a public repository. */
+func newLfsRequest(method, target, body string, params map[string]string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+
+	rctx := chi.NewRouteContext()
+	for k, v := range params {
+		rctx.URLParams.Add(k, v)
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+}
+
+func TestLfsOidValidation(t *testing.T) {
+	newTestInstance(t)
+
+	if err := goit.CreateUser(goit.User{Name: "alice", Pass: []byte{}, Salt: []byte{}}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	rid, err := goit.CreateRepo(goit.Repo{OwnerId: 1, Name: "proj", DefaultBranch: "master", Visibility: goit.Public})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	/* Store an object, and a file outside of the LFS directory that an invalid object ID could point to */
+	oid := strings.Repeat("0a", 32)
+	if err := os.MkdirAll(filepath.Dir(goit.LfsPath(rid, oid)), 0o777); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := os.WriteFile(goit.LfsPath(rid, oid), []byte("object"), 0o666); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := os.WriteFile(filepath.Join(goit.Conf.DataPath, "secret"), []byte("secret"), 0o666); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	t.Run("batch", func(t *testing.T) {
+		body := `{"operation": "download", "objects": [{"oid": "` + oid + `", "size": 6},
+			{"oid": "../../../secret", "size": 6}, {"oid": "` + strings.ToUpper(oid) + `", "size": 6},
+			{"oid": "` + oid + `", "size": -1}]}`
+
+		w := httptest.NewRecorder()
+		goit.HandleLfsBatch(w, newLfsRequest(http.MethodPost, "/proj/info/lfs/objects/batch", body,
+			map[string]string{"repo": "proj"}))
+
+		if w.Code != http.StatusOK {
+			t.Fatal("Expected status 200 got", w.Code, w.Body.String())
+		}
+
+		res := struct {
+			Objects []struct {
+				Actions map[string]any `json:"actions"`
+				Error   *struct {
+					Code int `json:"code"`
+				} `json:"error"`
+			} `json:"objects"`
+		}{}
+
+		if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if len(res.Objects) != 4 {
+			t.Fatal("Expected 4 objects got", len(res.Objects))
+		}
+
+		if res.Objects[0].Error != nil || res.Objects[0].Actions["download"] == nil {
+			t.Error("Expected a download action for a valid object")
+		}
+
+		for i, o := range res.Objects[1:] {
+			if o.Error == nil || o.Error.Code != http.StatusUnprocessableEntity || o.Actions != nil {
+				t.Error("Expected object", i+1, "to be invalid")
+			}
+		}
+	})
+
+	t.Run("download", func(t *testing.T) {
+		for _, tt := range []struct {
+			oid    string
+			status int
+		}{
+			{oid, http.StatusOK},
+			{strings.Repeat("0b", 32), http.StatusNotFound},
+			{"../../../secret", http.StatusNotFound},
+			{strings.ToUpper(oid), http.StatusNotFound},
+		} {
+			w := httptest.NewRecorder()
+			goit.HandleLfsDownload(w, newLfsRequest(http.MethodGet, "/proj/info/lfs/objects/"+tt.oid, "",
+				map[string]string{"repo": "proj", "oid": tt.oid}))
+
+			if w.Code != tt.status {
+				t.Error("Object", tt.oid, "expected status", tt.status, "got", w.Code)
+			} else if tt.status == http.StatusOK && w.Body.String() != "object" {
+				t.Error("Expected object content got", w.Body.String())
+			}
+		}
+	})
+}
@@ -0,0 +1,308 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package goit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jamozed/Goit/src/util"
+)
+
+var accessLog *logFile
+
+/*
+Log with slog to standard error and to a rotated log file in the logs path, at the configured level and in the
+configured format. Messages of the log package are logged at the info level.
+*/
+func setupLogs() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(Conf.LogLevel)); err != nil {
+		return fmt.Errorf("[config] log_level: invalid level \"%s\"", Conf.LogLevel)
+	}
+
+	if util.Debug {
+		level = slog.LevelDebug
+	}
+
+	if err := os.MkdirAll(Conf.LogsPath, 0o777); err != nil {
+		return fmt.Errorf("[config] %w", err)
+	}
+
+	lf, err := openLogFile("goit")
+	if err != nil {
+		return fmt.Errorf("[log] %w", err)
+	}
+
+	w := io.MultiWriter(os.Stderr, lf)
+	opts := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler
+	switch strings.ToLower(Conf.LogFormat) {
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("[config] log_format: invalid format \"%s\"", Conf.LogFormat)
+	}
+
+	slog.SetDefault(slog.New(componentHandler{h}))
+
+	if Conf.AccessLog {
+		if accessLog, err = openLogFile("access"); err != nil {
+			return fmt.Errorf("[log] %w", err)
+		}
+	}
+
+	return nil
+}
+
+/* A handler that moves the "[component]" that messages begin with to an attribute. */
+type componentHandler struct{ slog.Handler }
+
+func (h componentHandler) Handle(ctx context.Context, r slog.Record) error {
+	if !strings.HasPrefix(r.Message, "[") {
+		return h.Handler.Handle(ctx, r)
+	}
+
+	component, msg, ok := strings.Cut(r.Message[1:], "]")
+	if !ok {
+		return h.Handler.Handle(ctx, r)
+	}
+
+	nr := slog.NewRecord(r.Time, r.Level, strings.TrimSpace(msg), r.PC)
+	nr.AddAttrs(slog.String("component", component))
+	r.Attrs(func(a slog.Attr) bool {
+		nr.AddAttrs(a)
+		return true
+	})
+
+	return h.Handler.Handle(ctx, nr)
+}
+
+func (h componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return componentHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h componentHandler) WithGroup(name string) slog.Handler {
+	return componentHandler{h.Handler.WithGroup(name)}
+}
+
+/*
+Log a request to the access log, if it is enabled, in the Combined Log Format. The user is the one given by basic
+authentication, if any, as used by Git clients.
+*/
+func LogAccess(r *http.Request, ip string, start time.Time, status, size int) {
+	if accessLog == nil {
+		return
+	}
+
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	user, _, ok := r.BasicAuth()
+	if !ok || user == "" {
+		user = "-"
+	}
+
+	quote := func(s string) string {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+	}
+
+	line := fmt.Sprintf("%s - %s [%s] %s %d %d %s %s\n", ip, strings.ReplaceAll(user, " ", "_"),
+		start.Format("02/Jan/2006:15:04:05 -0700"), quote(r.Method+" "+r.URL.RequestURI()+" "+r.Proto), status, size,
+		quote(util.If(r.Referer() == "", "-", r.Referer())), quote(util.If(r.UserAgent() == "", "-", r.UserAgent())))
+
+	if _, err := accessLog.Write([]byte(line)); err != nil {
+		fmt.Fprintln(os.Stderr, "[access]", err.Error())
+	}
+}
+
+/*
+A log file in the logs path, named by its prefix and the time it was started, such as "goit_1700000000.log". It is
+rotated to a new file when it would exceed the configured size or is older than the configured age, after which old
+files are pruned.
+*/
+type logFile struct {
+	prefix string
+	f      *os.File
+	size   int64
+	start  time.Time
+	mutex  sync.Mutex
+}
+
+/* Open the newest log file with a prefix to append to, or start a new log file if it is due to be rotated. */
+func openLogFile(prefix string) (*logFile, error) {
+	l := &logFile{prefix: prefix}
+
+	files, err := logFiles(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) != 0 {
+		newest := files[len(files)-1]
+
+		info, err := os.Stat(newest.path)
+		if err != nil {
+			return nil, err
+		}
+
+		l.start, l.size = newest.start, info.Size()
+		if !l.due(0) {
+			if l.f, err = os.OpenFile(newest.path, os.O_WRONLY|os.O_APPEND, 0o666); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if l.f == nil {
+		return l, l.rotate()
+	}
+
+	l.prune()
+	return l, nil
+}
+
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	/* Errors are not logged, as the log would be written to again */
+	if l.due(len(p)) {
+		if err := l.rotate(); err != nil {
+			fmt.Fprintln(os.Stderr, "[log]", err.Error())
+		}
+	}
+
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+/* Report whether the log file should be rotated before writing n bytes to it. */
+func (l *logFile) due(n int) bool {
+	if Conf.LogMaxSize > 0 && l.size > 0 && l.size+int64(n) > int64(Conf.LogMaxSize)<<20 {
+		return true
+	}
+
+	return Conf.LogMaxAge > 0 && time.Since(l.start) >= time.Duration(Conf.LogMaxAge)*24*time.Hour
+}
+
+/* Start a new log file, then prune old log files. */
+func (l *logFile) rotate() error {
+	now := time.Now()
+	path := filepath.Join(Conf.LogsPath, fmt.Sprint(l.prefix, "_", now.Unix(), ".log"))
+
+	/*
+		Keep writing to the current file if it was started within the same second, counting from now so that it is not
+		due again until it has grown by the configured size or aged by the configured age
+	*/
+	if l.f != nil && l.f.Name() == path {
+		l.size, l.start = 0, now
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o666)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	if l.f != nil {
+		l.f.Close()
+	}
+
+	l.f, l.size, l.start = f, info.Size(), now
+	l.prune()
+	return nil
+}
+
+/*
+Remove log files other than the current one that are beyond the configured number to keep, or that were last written
+to before the configured number of days. Errors are written to standard error, as they do not stop logging.
+*/
+func (l *logFile) prune() {
+	files, err := logFiles(l.prefix)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "[log]", err.Error())
+		return
+	}
+
+	files = slices.DeleteFunc(files, func(f logEntry) bool { return f.path == l.f.Name() })
+
+	for i := range files {
+		f := files[len(files)-1-i]
+
+		remove := Conf.LogKeepLast > 0 && i >= Conf.LogKeepLast
+		if !remove && Conf.LogKeepDays > 0 {
+			info, err := os.Stat(f.path)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "[log]", err.Error())
+				continue
+			}
+
+			remove = time.Since(info.ModTime()) >= time.Duration(Conf.LogKeepDays)*24*time.Hour
+		}
+
+		if remove {
+			if err := os.Remove(f.path); err != nil {
+				fmt.Fprintln(os.Stderr, "[log]", err.Error())
+			}
+		}
+	}
+}
+
+type logEntry struct {
+	path  string
+	start time.Time
+}
+
+/* List the log files with a prefix in the logs path, oldest first. */
+func logFiles(prefix string) ([]logEntry, error) {
+	entries, err := os.ReadDir(Conf.LogsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []logEntry
+	for _, e := range entries {
+		ts, ok := strings.CutPrefix(e.Name(), prefix+"_")
+		if !ok || !e.Type().IsRegular() {
+			continue
+		}
+
+		if ts, ok = strings.CutSuffix(ts, ".log"); !ok {
+			continue
+		}
+
+		unix, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		files = append(files, logEntry{filepath.Join(Conf.LogsPath, e.Name()), time.Unix(unix, 0)})
+	}
+
+	slices.SortFunc(files, func(a, b logEntry) int { return a.start.Compare(b.start) })
+	return files, nil
+}
@@ -0,0 +1,226 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package goit_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Jamozed/Goit/src/goit"
+)
+
+/* Use a temporary logs path with no rotation or pruning, restoring the configuration after the test. */
+func newTestLogs(t *testing.T) {
+	t.Helper()
+
+	conf := goit.Conf
+	t.Cleanup(func() { goit.Conf = conf })
+
+	goit.Conf.LogsPath = t.TempDir()
+	goit.Conf.LogMaxSize, goit.Conf.LogMaxAge, goit.Conf.LogKeepLast, goit.Conf.LogKeepDays = 0, 0, 0, 0
+}
+
+/* Create a log file started at a time before now, returning its name. */
+func testLogFile(t *testing.T, age time.Duration, content string) string {
+	t.Helper()
+
+	name := fmt.Sprint("goit_", time.Now().Add(-age).Unix(), ".log")
+	if err := os.WriteFile(filepath.Join(goit.Conf.LogsPath, name), []byte(content), 0o666); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return name
+}
+
+/* List the names of the files in the logs path, oldest first. */
+func testLogNames(t *testing.T) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(goit.Conf.LogsPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+
+	return names
+}
+
+func readLog(t *testing.T, name string) string {
+	t.Helper()
+
+	b, err := os.ReadFile(filepath.Join(goit.Conf.LogsPath, name))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return string(b)
+}
+
+func TestLogFile(t *testing.T) {
+	t.Run("append", func(t *testing.T) {
+		newTestLogs(t)
+		goit.Conf.LogMaxAge = 1
+		name := testLogFile(t, time.Hour, "a\n")
+
+		l, err := goit.OpenLogFile("goit")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if _, err := l.Write([]byte("b\n")); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if names := testLogNames(t); !slices.Equal(names, []string{name}) {
+			t.Error("Expected", name, "got", names)
+		}
+
+		if got := readLog(t, name); got != "a\nb\n" {
+			t.Errorf("Expected %q got %q", "a\nb\n", got)
+		}
+	})
+
+	t.Run("age", func(t *testing.T) {
+		newTestLogs(t)
+		goit.Conf.LogMaxAge = 1
+		old := testLogFile(t, 25*time.Hour, "a\n")
+
+		l, err := goit.OpenLogFile("goit")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if _, err := l.Write([]byte("b\n")); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		names := testLogNames(t)
+		if len(names) != 2 || names[0] != old {
+			t.Fatal("Expected a new file after", old, "got", names)
+		}
+
+		if got := readLog(t, old); got != "a\n" {
+			t.Errorf("Expected the old file to be unchanged, got %q", got)
+		}
+
+		if got := readLog(t, names[1]); got != "b\n" {
+			t.Errorf("Expected %q got %q", "b\n", got)
+		}
+	})
+
+	t.Run("size", func(t *testing.T) {
+		newTestLogs(t)
+		goit.Conf.LogMaxSize = 1
+		old := testLogFile(t, time.Minute, string(make([]byte, 1<<20-1)))
+
+		l, err := goit.OpenLogFile("goit")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		/* The first write fits in the current file, the second does not */
+		for _, s := range []string{"a", "b"} {
+			if _, err := l.Write([]byte(s)); err != nil {
+				t.Fatal(err.Error())
+			}
+		}
+
+		names := testLogNames(t)
+		if len(names) != 2 || names[0] != old {
+			t.Fatal("Expected a new file after", old, "got", names)
+		}
+
+		if info, err := os.Stat(filepath.Join(goit.Conf.LogsPath, old)); err != nil {
+			t.Fatal(err.Error())
+		} else if info.Size() != 1<<20 {
+			t.Error("Expected the old file to be filled to 1 MiB, got", info.Size())
+		}
+
+		if got := readLog(t, names[1]); got != "b" {
+			t.Errorf("Expected %q got %q", "b", got)
+		}
+	})
+
+	t.Run("same second", func(t *testing.T) {
+		newTestLogs(t)
+		goit.Conf.LogMaxSize = 1
+
+		l, err := goit.OpenLogFile("goit")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		/* The second write is due to rotate, most likely to the name of the current file */
+		for _, b := range [][]byte{make([]byte, 1<<20), []byte("a")} {
+			if _, err := l.Write(b); err != nil {
+				t.Fatal(err.Error())
+			}
+		}
+
+		/* Whether or not a new file was started, rotation is not due again until the file has grown */
+		if l.Due(1) {
+			t.Error("Expected rotation to not be due after rotating")
+		}
+
+		if !l.Due(1 << 20) {
+			t.Error("Expected rotation to be due after growing by the configured size")
+		}
+	})
+
+	t.Run("keep last", func(t *testing.T) {
+		newTestLogs(t)
+		goit.Conf.LogKeepLast = 2
+
+		var old []string
+		for i := 4; i > 0; i -= 1 {
+			old = append(old, testLogFile(t, time.Duration(i)*time.Hour, ""))
+		}
+
+		/* Log files of another prefix are not pruned */
+		access := fmt.Sprint("access_", time.Now().Add(-5*time.Hour).Unix(), ".log")
+		if err := os.WriteFile(filepath.Join(goit.Conf.LogsPath, access), nil, 0o666); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if _, err := goit.OpenLogFile("goit"); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		/* The newest file is appended to, and the two before it are kept */
+		if names := testLogNames(t); !slices.Equal(names, []string{access, old[1], old[2], old[3]}) {
+			t.Error("Expected", []string{access, old[1], old[2], old[3]}, "got", names)
+		}
+	})
+
+	t.Run("keep days", func(t *testing.T) {
+		newTestLogs(t)
+		goit.Conf.LogKeepDays = 1
+
+		expired := testLogFile(t, 3*24*time.Hour, "")
+		recent := testLogFile(t, 2*24*time.Hour, "")
+		newest := testLogFile(t, time.Hour, "")
+
+		/* Files are pruned by when they were last written to rather than when they were started */
+		mod := time.Now().Add(-2 * 24 * time.Hour)
+		if err := os.Chtimes(filepath.Join(goit.Conf.LogsPath, expired), mod, mod); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if _, err := goit.OpenLogFile("goit"); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if names := testLogNames(t); !slices.Equal(names, []string{recent, newest}) {
+			t.Error("Expected", []string{recent, newest}, "got", names)
+		}
+	})
+}
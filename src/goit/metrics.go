@@ -0,0 +1,153 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package goit
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/Jamozed/Goit/src/metrics"
+	"github.com/Jamozed/Goit/src/util"
+)
+
+var gitOperations = metrics.NewCounter(
+	"goit_git_operations_total", "Clones, fetches, and pushes of repositories over HTTP and SSH.", "repo", "operation",
+)
+
+var pullDuration = metrics.NewHistogram(
+	"goit_pull_duration_seconds", "Duration of pulls of repositories from their upstream, such as mirror updates.",
+	[]float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}, "repo",
+)
+
+func init() {
+	metrics.NewGaugeFunc("goit_uptime_seconds", "Seconds since Goit started.", func() float64 {
+		return time.Since(StartTime).Seconds()
+	})
+
+	metrics.NewGaugeFunc("goit_goroutines", "Number of goroutines.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+
+	metrics.NewGaugeVecFunc("goit_memory_bytes", "Memory obtained from the OS, and in use by stacks and the heap.", "type",
+		func() map[string]float64 {
+			mem := runtime.MemStats{}
+			runtime.ReadMemStats(&mem)
+
+			return map[string]float64{
+				"sys": float64(mem.Sys), "stack": float64(mem.StackInuse), "heap": float64(mem.HeapInuse),
+			}
+		},
+	)
+
+	metrics.NewGaugeFunc("goit_sessions", "Number of unexpired user sessions.", func() float64 {
+		var n int64
+		if err := db.QueryRow("SELECT COUNT(*) FROM sessions WHERE expiry > ?", time.Now().Unix()).Scan(&n); err != nil {
+			util.Errorln("[metrics]", err.Error())
+			return math.NaN()
+		}
+
+		return float64(n)
+	})
+
+	metrics.NewGaugeVecFunc("goit_cache_entries", "Entries in the diff stat, size, and commit count caches.", "cache",
+		func() map[string]float64 {
+			diffsLock.RLock()
+			nDiffs := len(diffs)
+			diffsLock.RUnlock()
+
+			SizesLock.RLock()
+			nSizes := len(Sizes)
+			SizesLock.RUnlock()
+
+			countsLock.RLock()
+			nCounts := len(counts)
+			countsLock.RUnlock()
+
+			return map[string]float64{
+				"diffs": float64(nDiffs), "sizes": float64(nSizes), "counts": float64(nCounts),
+			}
+		},
+	)
+}
+
+/*
+Watches the Git protocol passing through a service to count it as a clone, fetch, or push of a repository. An
+upload-pack is counted once it sends a pack, which is a fetch if the client has any commits, and otherwise a clone.
+*/
+type gitCounter struct {
+	service, repo string
+	have, pack    watcher
+}
+
+func newGitCounter(service, repo string) *gitCounter {
+	return &gitCounter{service: service, repo: repo, have: watcher{token: []byte("have ")},
+		pack: watcher{token: []byte("PACK")}}
+}
+
+/* Wrap the input of the service, which is only watched for upload-pack. */
+func (gc *gitCounter) Reader(r io.Reader) io.Reader {
+	return util.If[io.Reader](gc.service == "git-upload-pack", watchReader{r, &gc.have}, r)
+}
+
+/* Wrap the output of the service, which is only watched for upload-pack. */
+func (gc *gitCounter) Writer(w io.Writer) io.Writer {
+	return util.If[io.Writer](gc.service == "git-upload-pack", watchWriter{w, &gc.pack}, w)
+}
+
+/* Count the service once it has succeeded. */
+func (gc *gitCounter) Count() {
+	if gc.service == "git-receive-pack" {
+		gitOperations.Inc(gc.repo, "push")
+	} else if gc.pack.found.Load() {
+		gitOperations.Inc(gc.repo, util.If(gc.have.found.Load(), "fetch", "clone"))
+	}
+}
+
+/* Notes whether a token has passed through a stream, which may be split across reads or writes. */
+type watcher struct {
+	token []byte
+	tail  []byte
+	found atomic.Bool
+}
+
+func (w *watcher) scan(p []byte) {
+	if w.found.Load() {
+		return
+	}
+
+	k := len(w.token) - 1
+	head := append(w.tail, p[:min(len(p), k)]...)
+	if bytes.Contains(head, w.token) || bytes.Contains(p, w.token) {
+		w.found.Store(true)
+	} else if len(p) >= k {
+		w.tail = bytes.Clone(p[len(p)-k:])
+	} else {
+		w.tail = head[max(0, len(head)-k):]
+	}
+}
+
+type watchReader struct {
+	io.Reader
+	*watcher
+}
+
+func (r watchReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.scan(p[:n])
+	return n, err
+}
+
+type watchWriter struct {
+	io.Writer
+	*watcher
+}
+
+func (w watchWriter) Write(p []byte) (int, error) {
+	w.scan(p)
+	return w.Writer.Write(p)
+}
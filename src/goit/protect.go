@@ -0,0 +1,196 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package goit
+
+import (
+	"database/sql"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Jamozed/Goit/src/util"
+)
+
+/*
+A protected branch pattern of a repository. Branches matching the pattern cannot be deleted or updated other than by
+a fast-forward, and can only be pushed to by the listed users if any are listed.
+*/
+type ProtectedBranch struct {
+	Id      int64
+	RepoId  int64
+	Pattern string
+	Users   []int64
+	Created time.Time
+}
+
+func GetProtectedBranches(rid int64) ([]ProtectedBranch, error) {
+	branches := []ProtectedBranch{}
+
+	rows, err := db.Query(
+		"SELECT id, repo_id, pattern, created FROM protected_branches WHERE repo_id = ? ORDER BY pattern", rid,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var b ProtectedBranch
+		var created int64
+
+		if err := rows.Scan(&b.Id, &b.RepoId, &b.Pattern, &created); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		b.Created = time.Unix(created, 0)
+		branches = append(branches, b)
+	}
+
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range branches {
+		if branches[i].Users, err = protectedUsers(branches[i].Id); err != nil {
+			return nil, err
+		}
+	}
+
+	return branches, nil
+}
+
+func protectedUsers(pid int64) ([]int64, error) {
+	users := []int64{}
+
+	rows, err := db.Query("SELECT user_id FROM protected_users WHERE protected_id = ? ORDER BY user_id", pid)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var uid int64
+		if err := rows.Scan(&uid); err != nil {
+			return nil, err
+		}
+
+		users = append(users, uid)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+/* Protect the branches of a repository matching a pattern, replacing the users of an existing identical pattern. */
+func ProtectBranch(b ProtectedBranch) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO protected_branches (repo_id, pattern, created) VALUES (?, ?, ?)
+		ON CONFLICT (repo_id, pattern) DO NOTHING`, b.RepoId, b.Pattern, time.Now().Unix(),
+	); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.QueryRow(
+		"SELECT id FROM protected_branches WHERE repo_id = ? AND pattern = ?", b.RepoId, b.Pattern,
+	).Scan(&b.Id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM protected_users WHERE protected_id = ?", b.Id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, uid := range b.Users {
+		if _, err := tx.Exec(
+			"INSERT OR IGNORE INTO protected_users (protected_id, user_id) VALUES (?, ?)", b.Id, uid,
+		); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func UnprotectBranch(rid, pid int64) error {
+	if res, err := db.Exec("DELETE FROM protected_branches WHERE id = ? AND repo_id = ?", pid, rid); err != nil {
+		return err
+	} else if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+
+	if _, err := db.Exec("DELETE FROM protected_users WHERE protected_id = ?", pid); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+/* Delete the protected branches of a repository. */
+func delProtectedBranches(tx *sql.Tx, rid int64) error {
+	if _, err := tx.Exec(
+		"DELETE FROM protected_users WHERE protected_id IN (SELECT id FROM protected_branches WHERE repo_id = ?)", rid,
+	); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM protected_branches WHERE repo_id = ?", rid); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+/* Check a protected branch pattern, returning a message if it is invalid. */
+func ValidateBranchPattern(pattern string) string {
+	if pattern == "" {
+		return "Pattern cannot be empty"
+	} else if len(pattern) > 256 {
+		return "Pattern cannot exceed 256 characters"
+	} else if strings.ContainsAny(pattern, " \t\n\\~^:") || strings.HasPrefix(pattern, "/") {
+		return "Pattern \"" + pattern + "\" is invalid"
+	} else if _, err := path.Match(pattern, ""); err != nil {
+		return "Pattern \"" + pattern + "\" is invalid"
+	}
+
+	return ""
+}
+
+/* Check if a branch name matches a protected branch pattern. */
+func (b ProtectedBranch) Matches(branch string) bool {
+	ok, _ := path.Match(b.Pattern, branch)
+	return ok
+}
+
+/* Check if a user may push to branches matching a protected branch pattern. */
+func (b ProtectedBranch) Allows(user *User) bool {
+	return len(b.Users) == 0 || (user != nil && slices.Contains(b.Users, user.Id))
+}
+
+/*
+Encode the protected branches of a repository for the pre-receive hook, as lines of a flag for whether the user may push
+followed by the pattern.
+*/
+func protectedEnv(branches []ProtectedBranch, user *User) string {
+	var lines []string
+	for _, b := range branches {
+		lines = append(lines, util.If(b.Allows(user), "1", "0")+" "+b.Pattern)
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,76 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package goit_test
+
+import (
+	"testing"
+
+	"github.com/Jamozed/Goit/src/goit"
+)
+
+func TestProtectedBranchMatches(t *testing.T) {
+	tests := []struct {
+		pattern, branch string
+		want            bool
+	}{
+		{"master", "master", true},
+		{"master", "master2", false},
+		{"release/*", "release/1.0", true},
+		{"release/*", "release/1.0/fix", false},
+		{"release/*", "release", false},
+		{"*", "master", true},
+		{"*", "feature/a", false},
+		{"v[0-9]*", "v2", true},
+		{"v[0-9]*", "vx", false},
+		{"[", "[", false},
+	}
+
+	for _, tt := range tests {
+		if got := (goit.ProtectedBranch{Pattern: tt.pattern}).Matches(tt.branch); got != tt.want {
+			t.Error("Pattern", tt.pattern, "branch", tt.branch, "expected", tt.want, "got", got)
+		}
+	}
+}
+
+func TestProtectedBranchAllows(t *testing.T) {
+	alice, bob := &goit.User{Id: 1, Name: "alice"}, &goit.User{Id: 2, Name: "bob"}
+
+	anyone := goit.ProtectedBranch{Pattern: "master"}
+	listed := goit.ProtectedBranch{Pattern: "release/*", Users: []int64{2}}
+
+	tests := []struct {
+		name   string
+		branch goit.ProtectedBranch
+		user   *goit.User
+		want   bool
+	}{
+		{"anyone", anyone, alice, true},
+		{"anyone anonymous", anyone, nil, true},
+		{"listed", listed, bob, true},
+		{"not listed", listed, alice, false},
+		{"listed anonymous", listed, nil, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.branch.Allows(tt.user); got != tt.want {
+			t.Error(tt.name, "expected", tt.want, "got", got)
+		}
+	}
+
+	t.Run("env", func(t *testing.T) {
+		branches := []goit.ProtectedBranch{anyone, listed}
+
+		if env := goit.ProtectedEnv(branches, alice); env != "1 master\n0 release/*" {
+			t.Error("Expected alice to only be allowed to push to master, got", env)
+		}
+
+		if env := goit.ProtectedEnv(branches, bob); env != "1 master\n1 release/*" {
+			t.Error("Expected bob to be allowed to push to both, got", env)
+		}
+
+		if env := goit.ProtectedEnv(nil, bob); env != "" {
+			t.Error("Expected no protected branches, got", env)
+		}
+	})
+}
@@ -6,11 +6,13 @@ package goit
 import (
 	"database/sql"
 	"errors"
-	"log"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/Jamozed/Goit/src/cron"
 	"github.com/Jamozed/Goit/src/util"
 	"github.com/go-git/go-git/v5"
 	gitconfig "github.com/go-git/go-git/v5/config"
@@ -178,11 +180,25 @@ func DelRepo(rid int64) error {
 		return err
 	}
 
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := delRepoRows(tx, rid); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
 	if err := os.RemoveAll(RepoPath(repo.Name, true)); err != nil {
 		return err
 	}
 
-	if _, err := db.Exec("DELETE FROM repos WHERE id = ?", rid); err != nil {
+	if err := os.RemoveAll(LfsRepoPath(rid)); err != nil {
 		return err
 	}
 
@@ -192,6 +208,31 @@ func DelRepo(rid int64) error {
 	return nil
 }
 
+/* Delete a repository and its collaborators, webhooks, push rules, and protected branches from the database. */
+func delRepoRows(tx *sql.Tx, rid int64) error {
+	if _, err := tx.Exec("DELETE FROM repos WHERE id = ?", rid); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM collaborators WHERE repo_id = ?", rid); err != nil {
+		return err
+	}
+
+	if err := delRepoWebhooks(tx, rid); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM push_rules WHERE repo_id = ?", rid); err != nil {
+		return err
+	}
+
+	if err := delProtectedBranches(tx, rid); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func RepoExists(name string) (bool, error) {
 	if err := db.QueryRow(
 		"SELECT name FROM repos WHERE name_lower = ?", strings.ToLower(name),
@@ -206,6 +247,52 @@ func RepoExists(name string) (bool, error) {
 	}
 }
 
+/*
+Validate the fields of a repository to be created, or to replace prev if it is not nil. Returns a message describing
+the first invalid field, or an empty string if the repository is valid.
+*/
+func ValidateRepo(repo Repo, prev *Repo) (string, error) {
+	if repo.Name == "" {
+		return "Name cannot be empty", nil
+	} else if !legalRepoName(repo.Name) {
+		return "Name \"" + repo.Name + "\" is illegal", nil
+	}
+
+	if prev == nil || !strings.EqualFold(repo.Name, prev.Name) {
+		if exists, err := RepoExists(repo.Name); err != nil {
+			return "", err
+		} else if exists {
+			return "Name \"" + repo.Name + "\" is taken", nil
+		}
+	}
+
+	if len(repo.Description) > 256 {
+		return "Description cannot exceed 256 characters", nil
+	} else if repo.Visibility < Public || repo.Visibility > Limited {
+		return "Visibility is invalid", nil
+	}
+
+	return "", nil
+}
+
+/*
+Check that a repository name is legal and is not reserved. Names are paths in the repositories directory, so their
+elements cannot be empty, ".", or "..".
+*/
+func legalRepoName(name string) bool {
+	if slices.Contains(Reserved, strings.SplitN(name, "/", 2)[0]) || !IsLegal(name) {
+		return false
+	}
+
+	for _, e := range strings.Split(name, "/") {
+		if e == "" || e == "." || e == ".." {
+			return false
+		}
+	}
+
+	return true
+}
+
 func UpdateRepo(rid int64, repo Repo) error {
 	old, err := GetRepo(rid)
 	if err != nil {
@@ -292,13 +379,13 @@ func UpdateRepo(rid int64, repo Repo) error {
 			Mirror: util.If(repo.IsMirror, true, false),
 			Fetch:  []gitconfig.RefSpec{gitconfig.RefSpec("+refs/heads/*:refs/heads/*")},
 		}); err != nil {
-			log.Println("[repo/update]", err.Error())
+			util.Errorln("[repo/update]", err.Error())
 		}
 	}
 
 	if err := tx.Commit(); err != nil {
 		os.Rename(RepoPath(repo.Name, true), RepoPath(old.Name, true))
-		log.Println("[repo/update]", "error while editing, check repo \""+old.Name+"\"/\""+repo.Name+"\"")
+		util.Errorln("[repo/update]", "error while editing, check repo \""+old.Name+"\"/\""+repo.Name+"\"")
 		return err
 	}
 
@@ -313,6 +400,43 @@ func ChownRepo(rid int64, uid int64) error {
 	return nil
 }
 
+/* Schedule an immediate pull of a repository from its upstream, followed by daily pulls if it is a mirror. */
+func ScheduleImport(rid int64, name string, mirror bool) {
+	Cron.Add(rid, "import", cron.Immediate, func() error {
+		if err := Pull(rid); err != nil {
+			return err
+		}
+
+		util.Infoln("[cron:import] imported", rid, name)
+		return nil
+	})
+
+	if mirror {
+		util.Debugln("[cron:mirror] adding job for", name)
+		Cron.Add(rid, "mirror", cron.Daily, func() error {
+			if err := Pull(rid); err != nil {
+				return err
+			}
+
+			util.Infoln("[cron:mirror] updated", rid, name)
+			return nil
+		})
+	}
+
+	Cron.Update()
+}
+
+/* Update the cron jobs of a repository after its upstream or mirror status has been edited. */
+func RescheduleMirror(prev, repo Repo) {
+	if (repo.Upstream == "" && prev.Upstream != "") || !repo.IsMirror {
+		Cron.RemoveFor(prev.Id)
+		Cron.Update()
+	} else if repo.Upstream != "" && repo.IsMirror && (repo.Upstream != prev.Upstream || !prev.IsMirror) {
+		Cron.RemoveFor(prev.Id)
+		ScheduleImport(prev.Id, repo.Name, true)
+	}
+}
+
 func Pull(rid int64) error {
 	repo, err := GetRepo(rid)
 	if err != nil {
@@ -324,17 +448,15 @@ func Pull(rid int64) error {
 		return err
 	}
 
-	if err := r.Fetch(&git.FetchOptions{}); err != nil {
+	t1 := time.Now()
+	if err := r.Fetch(&git.FetchOptions{}); err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
 		return err
 	}
 
+	pullDuration.Observe(time.Since(t1).Seconds(), repo.Name)
 	return nil
 }
 
 func IsVisible(repo *Repo, auth bool, user *User) bool {
-	if repo.Visibility == Public || (repo.Visibility == Limited && auth) || (auth && user.Id == repo.OwnerId) {
-		return true
-	}
-
-	return false
+	return RepoAccess(repo, auth, user) >= AccessRead
 }
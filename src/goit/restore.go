@@ -0,0 +1,759 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package goit
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/Jamozed/Goit/src/util"
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+/* How a restore handles users and repositories that already exist. */
+type Conflict int32
+
+const (
+	ConflictFail      Conflict = 0
+	ConflictSkip      Conflict = 1
+	ConflictOverwrite Conflict = 2
+)
+
+func ConflictFromString(s string) Conflict {
+	switch strings.ToLower(s) {
+	case "fail":
+		return ConflictFail
+	case "skip":
+		return ConflictSkip
+	case "overwrite":
+		return ConflictOverwrite
+	default:
+		return -1
+	}
+}
+
+func (c Conflict) String() string {
+	names := [...]string{"fail", "skip", "overwrite"}
+	if c < 0 || int(c) >= len(names) {
+		return "unknown"
+	}
+
+	return names[c]
+}
+
+type RestoreOptions struct {
+	DryRun   bool
+	Conflict Conflict
+}
+
+/* Archives written before repositories were stored under "repos" contain the path of a temporary directory instead. */
+var legacyBackupPrefix = regexp.MustCompile(`^[^/]+/(?:.+/)?goit-[0-9]+/$`)
+
+/* An opened backup archive, with its database dump and manifest, which is nil for archives written without one. */
+type backupArchive struct {
+	name     string
+	fsys     fs.FS
+	close    func() error
+	ts       string
+	data     backupData
+	manifest *backupManifest
+}
+
+/*
+Restore users and repositories from a backup archive written by Backup, along with their SSH keys, tokens, webhooks,
+collaborators, push rules, and protected branches, returning a description of each action taken. Incremental backups
+are restored by chaining the archives they are based on, which must be in the same directory. Existing users and
+repositories with the same ID or name are handled according to the conflict option, and nothing is changed if any
+conflict cannot be resolved or if the restore is a dry run.
+*/
+func Restore(archive string, opts RestoreOptions) ([]string, error) {
+	chain, err := openBackupChain(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, a := range chain {
+			a.close()
+		}
+	}()
+
+	data := chain[len(chain)-1].data
+
+	var msgs, problems []string
+	if len(chain) > 1 {
+		msgs = append(msgs, fmt.Sprint("chain ", len(chain), " backups from full backup ", chain[0].name))
+	}
+
+	var create, overwrite []User
+	var repos []Repo
+	var replace []int64
+
+	owners := map[int64]bool{}
+
+	/* Plan the restoration of users, checking their names as when they are created */
+	for _, u := range data.Users {
+		if u.Name == "" || slices.Contains(Reserved, u.Name) && u.Id != 0 || !IsLegal(u.Name) {
+			problems = append(problems, "user name \""+u.Name+"\" is illegal")
+			continue
+		}
+
+		byId, err := GetUser(u.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		byName, err := GetUserByName(u.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if byId == nil && byName == nil {
+			create = append(create, u)
+			owners[u.Id] = true
+			msgs = append(msgs, fmt.Sprint("create user \"", u.Name, "\" (", u.Id, ")"))
+			continue
+		}
+
+		switch opts.Conflict {
+		case ConflictFail:
+			problems = append(problems, "user \""+u.Name+"\" already exists")
+		case ConflictSkip:
+			msgs = append(msgs, "skip existing user \""+u.Name+"\"")
+		case ConflictOverwrite:
+			if byId == nil || (byName != nil && byName.Id != byId.Id) {
+				problems = append(problems, "user \""+u.Name+"\" exists with a different ID")
+			} else {
+				overwrite = append(overwrite, u)
+				msgs = append(msgs, fmt.Sprint("overwrite user \"", u.Name, "\" (", u.Id, ")"))
+			}
+		}
+	}
+
+	/* SSH keys are unique, so they cannot be restored if another user has added them since */
+	for _, k := range data.SshKeys {
+		if !owners[k.OwnerId] && !slices.ContainsFunc(overwrite, func(u User) bool { return u.Id == k.OwnerId }) {
+			continue
+		}
+
+		var uid int64
+		if err := db.QueryRow("SELECT owner_id FROM ssh_keys WHERE key = ?", k.Key).Scan(&uid); err == nil {
+			if uid != k.OwnerId {
+				problems = append(problems, "SSH key \""+k.Name+"\" is used by another user")
+			}
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+	}
+
+	/* Plan the restoration of repositories, whose names must not lead outside of the repositories directory */
+	for _, r := range data.Repos {
+		if !legalRepoName(r.Name) {
+			problems = append(problems, "repository name \""+r.Name+"\" is illegal")
+			continue
+		}
+
+		if !owners[r.OwnerId] {
+			if owner, err := GetUser(r.OwnerId); err != nil {
+				return nil, err
+			} else if owner == nil {
+				problems = append(problems, "owner of repository \""+r.Name+"\" does not exist")
+				continue
+			}
+		}
+
+		byId, err := GetRepo(r.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		byName, err := GetRepoByName(r.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if byId == nil && byName == nil {
+			repos = append(repos, r)
+			msgs = append(msgs, fmt.Sprint("create repository \"", r.Name, "\" (", r.Id, ")"))
+			continue
+		}
+
+		switch opts.Conflict {
+		case ConflictFail:
+			problems = append(problems, "repository \""+r.Name+"\" already exists")
+		case ConflictSkip:
+			msgs = append(msgs, "skip existing repository \""+r.Name+"\"")
+		case ConflictOverwrite:
+			for _, e := range []*Repo{byId, byName} {
+				if e != nil && !slices.Contains(replace, e.Id) {
+					replace = append(replace, e.Id)
+				}
+			}
+
+			repos = append(repos, r)
+			msgs = append(msgs, fmt.Sprint("overwrite repository \"", r.Name, "\" (", r.Id, ")"))
+		}
+	}
+
+	if len(problems) != 0 {
+		return msgs, errors.New(strings.Join(problems, "; "))
+	}
+
+	if opts.DryRun {
+		return msgs, nil
+	}
+
+	/* Stage repositories and LFS objects before changing anything */
+	staging, err := os.MkdirTemp(Conf.DataPath, ".restore-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+
+	for _, r := range repos {
+		if warnings, err := stageRepo(chain, r, staging); err != nil {
+			return nil, fmt.Errorf("repository \"%s\": %w", r.Name, err)
+		} else {
+			msgs = append(msgs, warnings...)
+		}
+	}
+
+	var old []Repo
+	for _, rid := range replace {
+		if r, err := GetRepo(rid); err != nil {
+			return nil, err
+		} else if r != nil {
+			old = append(old, *r)
+		}
+	}
+
+	/* Write to the database first, so that nothing is changed if any of it fails */
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rid := range replace {
+		if err := delRepoRows(tx, rid); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	for _, u := range create {
+		if _, err := tx.Exec(
+			"INSERT INTO users (id, name, name_full, pass, pass_algo, salt, is_admin) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			u.Id, u.Name, u.FullName, u.Pass, u.PassAlgo, u.Salt, u.IsAdmin,
+		); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	for _, u := range overwrite {
+		if _, err := tx.Exec(
+			"UPDATE users SET name = ?, name_full = ?, pass = ?, pass_algo = ?, salt = ?, is_admin = ? WHERE id = ?",
+			u.Name, u.FullName, u.Pass, u.PassAlgo, u.Salt, u.IsAdmin, u.Id,
+		); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	for _, r := range repos {
+		if _, err := tx.Exec(
+			`INSERT INTO repos (id, owner_id, name, name_lower, description, default_branch, upstream, visibility,
+			is_mirror) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, r.Id, r.OwnerId, r.Name, strings.ToLower(r.Name),
+			r.Description, r.DefaultBranch, r.Upstream, r.Visibility, r.IsMirror,
+		); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := restoreRows(tx, data, create, overwrite, repos); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	/* Swap the replaced repositories for the staged ones, then commit, reversing the swap if either fails */
+	var sw dirSwap
+	if err := swapRepoDirs(&sw, old, repos, staging); err != nil {
+		if uerr := sw.undo(); uerr != nil {
+			err = errors.Join(err, uerr)
+		}
+
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if uerr := sw.undo(); uerr != nil {
+			err = errors.Join(err, uerr)
+		}
+
+		return nil, err
+	}
+
+	for _, r := range old {
+		Cron.RemoveFor(r.Id)
+	}
+
+	Cron.Update()
+
+	for _, r := range repos {
+		if r.IsMirror && r.Upstream != "" {
+			ScheduleImport(r.Id, r.Name, true)
+		}
+	}
+
+	return msgs, nil
+}
+
+/*
+Restore the SSH keys, tokens, and webhooks of restored users, replacing those of overwritten users, and the
+collaborators, webhooks, push rules, and protected branches of restored repositories. Collaborators and the users of
+protected branches are only restored if the user exists.
+*/
+func restoreRows(tx *sql.Tx, data backupData, create, overwrite []User, repos []Repo) error {
+	users, rids := map[int64]bool{}, map[int64]bool{}
+	for _, u := range create {
+		users[u.Id] = true
+	}
+
+	for _, u := range overwrite {
+		users[u.Id] = true
+
+		for _, query := range []string{
+			"DELETE FROM ssh_keys WHERE owner_id = ?",
+			"DELETE FROM tokens WHERE owner_id = ?",
+			"DELETE FROM deliveries WHERE hook_id IN (SELECT id FROM webhooks WHERE owner_id = ? AND repo_id = ?)",
+			"DELETE FROM webhooks WHERE owner_id = ? AND repo_id = ?",
+		} {
+			if _, err := tx.Exec(query, u.Id, AllRepos); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, r := range repos {
+		rids[r.Id] = true
+	}
+
+	for _, k := range data.SshKeys {
+		if users[k.OwnerId] {
+			if _, err := tx.Exec(
+				"INSERT INTO ssh_keys (owner_id, name, key, fingerprint, created) VALUES (?, ?, ?, ?, ?)",
+				k.OwnerId, k.Name, k.Key, k.Fingerprint, k.Created,
+			); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, t := range data.Tokens {
+		if users[t.OwnerId] {
+			if _, err := tx.Exec(
+				`INSERT INTO tokens (owner_id, name, hash, scope, created, expiry, last_used)
+				VALUES (?, ?, ?, ?, ?, ?, ?)`, t.OwnerId, t.Name, t.Hash, t.Scope, t.Created, t.Expiry, t.LastUsed,
+			); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, h := range data.Webhooks {
+		if util.If(h.RepoId == AllRepos, users[h.OwnerId], rids[h.RepoId]) {
+			if _, err := tx.Exec(
+				"INSERT INTO webhooks (owner_id, repo_id, url, secret, created) VALUES (?, ?, ?, ?, ?)",
+				h.OwnerId, h.RepoId, h.Url, h.Secret, h.Created,
+			); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, c := range data.Collaborators {
+		if rids[c.RepoId] {
+			if _, err := tx.Exec(
+				`INSERT OR REPLACE INTO collaborators (repo_id, user_id, access) SELECT ?, ?, ?
+				WHERE EXISTS (SELECT 1 FROM users WHERE id = ?)`, c.RepoId, c.UserId, c.Access, c.UserId,
+			); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, p := range data.PushRules {
+		if rids[p.RepoId] {
+			if _, err := tx.Exec(
+				`INSERT OR REPLACE INTO push_rules (repo_id, max_file_size, deny_force_push, branch_pattern)
+				VALUES (?, ?, ?, ?)`, p.RepoId, p.MaxFileSize, p.DenyForcePush, p.BranchPattern,
+			); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, b := range data.Protected {
+		if !rids[b.RepoId] {
+			continue
+		}
+
+		res, err := tx.Exec(
+			"INSERT INTO protected_branches (repo_id, pattern, created) VALUES (?, ?, ?)", b.RepoId, b.Pattern, b.Created,
+		)
+		if err != nil {
+			return err
+		}
+
+		pid, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		for _, uid := range b.Users {
+			if _, err := tx.Exec(
+				`INSERT OR IGNORE INTO protected_users (protected_id, user_id) SELECT ?, ?
+				WHERE EXISTS (SELECT 1 FROM users WHERE id = ?)`, pid, uid, uid,
+			); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+/* Directory renames made during a restore, which are reversed if the restore fails. */
+type dirSwap struct{ moves [][2]string }
+
+/* Rename a directory, creating the parent of its destination, unless it does not exist. */
+func (s *dirSwap) move(src, dst string) error {
+	if _, err := os.Stat(src); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0o777); err != nil {
+		return err
+	}
+
+	if err := os.Rename(src, dst); err != nil {
+		return err
+	}
+
+	s.moves = append(s.moves, [2]string{src, dst})
+	return nil
+}
+
+/* Reverse the renames in the opposite order that they were made. */
+func (s *dirSwap) undo() error {
+	var errs []error
+	for i := len(s.moves) - 1; i >= 0; i-- {
+		if err := os.Rename(s.moves[i][1], s.moves[i][0]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	s.moves = nil
+	return errors.Join(errs...)
+}
+
+/*
+Move the directories and LFS objects of replaced repositories aside to the staging directory, where they are removed
+once the restore is complete, then move the staged repositories and LFS objects into place.
+*/
+func swapRepoDirs(sw *dirSwap, old, repos []Repo, staging string) error {
+	for _, r := range old {
+		if err := sw.move(RepoPath(r.Name, true), filepath.Join(staging, "replaced", fmt.Sprint(r.Id))); err != nil {
+			return err
+		}
+
+		if err := sw.move(LfsRepoPath(r.Id), filepath.Join(staging, "replaced", fmt.Sprint(r.Id, ".lfs"))); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range repos {
+		if err := sw.move(filepath.Join(staging, "repos", RepoPath(r.Name, false)), RepoPath(r.Name, true)); err != nil {
+			return err
+		}
+
+		if err := sw.move(filepath.Join(staging, "lfs", fmt.Sprint(r.Id)), LfsRepoPath(r.Id)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/* Open a backup archive and the archives that it is based on, oldest first. */
+func openBackupChain(archive string) ([]*backupArchive, error) {
+	var chain []*backupArchive
+
+	for {
+		a, err := openBackupArchive(archive)
+		if err != nil {
+			for _, a := range chain {
+				a.close()
+			}
+
+			if len(chain) != 0 {
+				return nil, fmt.Errorf("previous backup: %w", err)
+			}
+
+			return nil, err
+		}
+
+		chain = append([]*backupArchive{a}, chain...)
+
+		if a.manifest == nil || !a.manifest.Incremental {
+			return chain, nil
+		}
+
+		if slices.ContainsFunc(chain, func(c *backupArchive) bool { return c.name == a.manifest.Previous }) {
+			for _, a := range chain {
+				a.close()
+			}
+
+			return nil, errors.New("backup chain of " + a.name + " is circular")
+		}
+
+		archive = filepath.Join(filepath.Dir(archive), filepath.Base(a.manifest.Previous))
+	}
+}
+
+/* Open a backup archive of any format, decoding its database dump and manifest. */
+func openBackupArchive(archive string) (*backupArchive, error) {
+	fsys, closer, err := openArchive(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &backupArchive{name: filepath.Base(archive), fsys: fsys, close: closer}
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		closer()
+		return nil, err
+	}
+
+	for _, e := range entries {
+		b, err := fs.ReadFile(fsys, path.Join(e.Name(), "goit.json"))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err == nil {
+			err = json.Unmarshal(b, &a.data)
+		}
+		if err != nil {
+			closer()
+			return nil, fmt.Errorf("%s: goit.json: %w", a.name, err)
+		}
+
+		a.ts = e.Name()
+		break
+	}
+
+	if a.ts == "" {
+		closer()
+		return nil, errors.New(a.name + " is not a Goit backup, goit.json not found")
+	}
+
+	b, err := fs.ReadFile(fsys, path.Join(a.ts, "manifest.json"))
+	if err == nil {
+		a.manifest = &backupManifest{}
+		err = json.Unmarshal(b, a.manifest)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		closer()
+		return nil, fmt.Errorf("%s: manifest.json: %w", a.name, err)
+	}
+
+	return a, nil
+}
+
+/*
+Extract a repository and its LFS objects from a chain of backup archives to a staging directory, returning a warning
+for each ref that could not be restored. The repository is extracted from the full backup, or initialised if it is not
+included, then the bundles of any incremental backups are applied and the refs are set from the last manifest.
+*/
+func stageRepo(chain []*backupArchive, repo Repo, staging string) ([]string, error) {
+	dst := filepath.Join(staging, "repos", RepoPath(repo.Name, false))
+	base := chain[0]
+
+	/* The repository may have had a different name when the full backup was taken */
+	name := repo.Name
+	if base.manifest != nil {
+		name = base.manifest.Repos[repo.Id].Name
+	}
+
+	if dir, err := backupRepoDir(base.fsys, base.ts, name); err != nil {
+		return nil, err
+	} else if dir != "" {
+		if err := extractDir(base.fsys, dir, dst); err != nil {
+			return nil, err
+		}
+	} else {
+		r, err := git.PlainInit(dst, true)
+		if err != nil {
+			return nil, err
+		}
+
+		ref := plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName(repo.DefaultBranch))
+		if err := r.Storer.SetReference(ref); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, a := range chain {
+		lfs := path.Join(a.ts, "lfs", fmt.Sprint(repo.Id))
+		if err := extractDir(a.fsys, lfs, filepath.Join(staging, "lfs", fmt.Sprint(repo.Id))); err != nil {
+			return nil, err
+		}
+	}
+
+	var warnings []string
+	if len(chain) > 1 {
+		for _, a := range chain[1:] {
+			if err := unbundle(a, repo.Id, dst, staging); err != nil {
+				return nil, fmt.Errorf("%s: %w", a.name, err)
+			}
+		}
+
+		var err error
+		if warnings, err = setBackupRefs(dst, repo, chain[len(chain)-1].manifest.Repos[repo.Id].Refs); err != nil {
+			return nil, err
+		}
+	}
+
+	/* Backups remove the upstream remote, so restore it from the repository settings */
+	if repo.Upstream != "" {
+		r, err := git.PlainOpen(dst)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := r.CreateRemote(&gitconfig.RemoteConfig{
+			Name:   "origin",
+			URLs:   []string{repo.Upstream},
+			Mirror: util.If(repo.IsMirror, true, false),
+			Fetch:  []gitconfig.RefSpec{gitconfig.RefSpec("+refs/heads/*:refs/heads/*")},
+		}); err != nil && !errors.Is(err, git.ErrRemoteExists) {
+			return nil, err
+		}
+	}
+
+	return warnings, nil
+}
+
+/* Unpack the objects of the bundle of a repository in an incremental backup archive, if it has one. */
+func unbundle(a *backupArchive, rid int64, dst, staging string) error {
+	bundle := a.manifest.Repos[rid].Bundle
+	if bundle == "" {
+		return nil
+	}
+
+	tmp := filepath.Join(staging, fmt.Sprint(rid, ".bundle"))
+	defer os.Remove(tmp)
+
+	if err := extractFile(a.fsys, path.Join(a.ts, bundle), tmp); errors.Is(err, fs.ErrNotExist) {
+		return errors.New("bundle \"" + bundle + "\" not found")
+	} else if err != nil {
+		return err
+	}
+
+	stderr := &bytes.Buffer{}
+	c := NewGitCommand("bundle", "unbundle", tmp)
+	c.Dir = dst
+	c.Stderr = stderr
+
+	if _, _, err := c.Run(nil, nil); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+/*
+Set the refs of a repository to those recorded in a backup manifest, deleting any others. Refs to missing objects are
+skipped, returning a warning for each.
+*/
+func setBackupRefs(dst string, repo Repo, refs map[string]string) ([]string, error) {
+	gr, err := git.PlainOpen(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := repoRefs(gr)
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range current {
+		if _, ok := refs[name]; !ok {
+			if err := gr.Storer.RemoveReference(plumbing.ReferenceName(name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var warnings []string
+	for name, hash := range refs {
+		h := plumbing.NewHash(hash)
+		if gr.Storer.HasEncodedObject(h) != nil {
+			warnings = append(warnings, fmt.Sprint(
+				"skip ref \"", name, "\" of repository \"", repo.Name, "\", object ", hash, " is missing",
+			))
+			continue
+		}
+
+		if err := gr.Storer.SetReference(plumbing.NewHashReference(plumbing.ReferenceName(name), h)); err != nil {
+			return nil, err
+		}
+	}
+
+	return warnings, nil
+}
+
+/* Find the directory of a repository in a backup archive, or an empty string if it is not present. */
+func backupRepoDir(fsys fs.FS, ts, name string) (string, error) {
+	dir := path.Join(ts, "repos", filepath.ToSlash(RepoPath(name, false)))
+	if _, err := fs.Stat(fsys, path.Join(dir, "HEAD")); err == nil {
+		return dir, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	/* Search for the directory of a legacy archive */
+	head := "/" + filepath.ToSlash(RepoPath(name, false)) + "/HEAD"
+	dir = ""
+
+	err := fs.WalkDir(fsys, ts, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if prefix, ok := strings.CutSuffix(p, head); ok && legacyBackupPrefix.MatchString(prefix+"/") {
+			dir = path.Dir(p)
+			return fs.SkipAll
+		}
+
+		return nil
+	})
+
+	return dir, err
+}
@@ -0,0 +1,361 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package goit_test
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Jamozed/Goit/src/cron"
+	"github.com/Jamozed/Goit/src/goit"
+)
+
+/* Open an empty instance in a temporary data path. */
+func newTestInstance(t *testing.T) {
+	t.Helper()
+
+	goit.Conf.DataPath = t.TempDir()
+	goit.Cron = cron.New()
+
+	if err := goit.OpenDatabase(filepath.Join(goit.Conf.DataPath, "goit.db")); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+/* Commit an empty tree to a branch of a repository, returning the commit hash. */
+func testCommit(t *testing.T, repo, branch, msg string) string {
+	t.Helper()
+
+	t.Setenv("GIT_AUTHOR_NAME", "Goit")
+	t.Setenv("GIT_AUTHOR_EMAIL", "goit@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "Goit")
+	t.Setenv("GIT_COMMITTER_EMAIL", "goit@example.com")
+
+	git := func(args ...string) string {
+		out, err := exec.Command("git", append([]string{"--git-dir", goit.RepoPath(repo, true)}, args...)...).Output()
+		if err != nil {
+			t.Fatal("git", args, err.Error())
+		}
+
+		return strings.TrimSpace(string(out))
+	}
+
+	tree := git("mktree")
+	args := []string{"commit-tree", tree, "-m", msg}
+	if parent, err := exec.Command(
+		"git", "--git-dir", goit.RepoPath(repo, true), "rev-parse", "--verify", "-q", "refs/heads/"+branch,
+	).Output(); err == nil {
+		args = append(args, "-p", strings.TrimSpace(string(parent)))
+	}
+
+	hash := git(args...)
+	git("update-ref", "refs/heads/"+branch, hash)
+	return hash
+}
+
+/*
+Create an instance with two users, a repository with a commit, and the settings that are backed up, then back it up,
+returning the archive, the commit, and the plaintext value of the token of the first user.
+*/
+func newTestBackup(t *testing.T) (string, string, string) {
+	t.Helper()
+	newTestInstance(t)
+
+	for _, name := range []string{"alice", "bob"} {
+		if err := goit.CreateUser(goit.User{Name: name, Pass: []byte{}, Salt: []byte{}}); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	rid, err := goit.CreateRepo(goit.Repo{OwnerId: 1, Name: "proj", Description: "Project", DefaultBranch: "master"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	hash := testCommit(t, "proj", "master", "Initial commit")
+
+	if err := goit.SetCollaborator(rid, 2, goit.AccessWrite); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, token, err := goit.NewToken(1, "ci", goit.AccessRead, time.Time{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	key := goit.SshKey{OwnerId: 1, Name: "laptop", Key: "ssh-ed25519 AAAA", Fingerprint: "fp"}
+	if err := goit.CreateSshKey(key); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for _, hook := range []goit.Webhook{
+		{OwnerId: 1, RepoId: rid, Url: "https://example.com/repo"},
+		{OwnerId: 1, RepoId: goit.AllRepos, Url: "https://example.com/user"},
+	} {
+		if _, err := goit.CreateWebhook(hook); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	if err := goit.SetPushRules(rid, goit.PushRules{MaxFileSize: 1024, DenyForcePush: true}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := goit.ProtectBranch(goit.ProtectedBranch{RepoId: rid, Pattern: "master", Users: []int64{2}}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	archive, err := goit.Backup(0, goit.BackupZip)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return archive, hash, token
+}
+
+/* Check that the repository and settings created by newTestBackup are present. */
+func checkTestBackup(t *testing.T, hash, token string) {
+	t.Helper()
+
+	repo, err := goit.GetRepoByName("proj")
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if repo == nil {
+		t.Fatal("Repository was not restored")
+	}
+
+	if repo.Description != "Project" {
+		t.Error("Expected description Project got", repo.Description)
+	}
+
+	out, err := exec.Command("git", "--git-dir", goit.RepoPath("proj", true), "rev-parse", "master").Output()
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if got := strings.TrimSpace(string(out)); got != hash {
+		t.Error("Expected master at", hash, "got", got)
+	}
+
+	if c, err := goit.GetCollaborators(repo.Id); err != nil {
+		t.Fatal(err.Error())
+	} else if !slices.Equal(c, []goit.Collaborator{{RepoId: repo.Id, UserId: 2, Access: goit.AccessWrite}}) {
+		t.Error("Expected bob as a collaborator, got", c)
+	}
+
+	if tok, err := goit.CheckToken(1, token); err != nil {
+		t.Fatal(err.Error())
+	} else if tok == nil {
+		t.Error("Token was not restored")
+	}
+
+	if keys, err := goit.GetSshKeys(1); err != nil {
+		t.Fatal(err.Error())
+	} else if len(keys) != 1 || keys[0].Name != "laptop" {
+		t.Error("Expected SSH key laptop, got", keys)
+	}
+
+	if hooks, err := goit.GetWebhooks(1, repo.Id); err != nil {
+		t.Fatal(err.Error())
+	} else if len(hooks) != 1 || hooks[0].Url != "https://example.com/repo" {
+		t.Error("Expected repository webhook, got", hooks)
+	}
+
+	if hooks, err := goit.GetWebhooks(1, goit.AllRepos); err != nil {
+		t.Fatal(err.Error())
+	} else if len(hooks) != 1 || hooks[0].Url != "https://example.com/user" {
+		t.Error("Expected user webhook, got", hooks)
+	}
+
+	if rules, err := goit.GetPushRules(repo.Id); err != nil {
+		t.Fatal(err.Error())
+	} else if rules != (goit.PushRules{MaxFileSize: 1024, DenyForcePush: true}) {
+		t.Error("Expected push rules to be restored, got", rules)
+	}
+
+	if branches, err := goit.GetProtectedBranches(repo.Id); err != nil {
+		t.Fatal(err.Error())
+	} else if len(branches) != 1 || branches[0].Pattern != "master" || !slices.Equal(branches[0].Users, []int64{2}) {
+		t.Error("Expected master to be protected for bob, got", branches)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	archive, hash, token := newTestBackup(t)
+
+	/* Restore to an empty instance */
+	newTestInstance(t)
+
+	if _, err := goit.Restore(archive, goit.RestoreOptions{}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if users, err := goit.GetUsers(); err != nil {
+		t.Fatal(err.Error())
+	} else if len(users) != 2 || users[0].Name != "alice" || users[1].Name != "bob" {
+		t.Error("Expected users alice and bob, got", users)
+	}
+
+	checkTestBackup(t, hash, token)
+}
+
+func TestRestoreDryRun(t *testing.T) {
+	archive, _, _ := newTestBackup(t)
+	newTestInstance(t)
+
+	msgs, err := goit.Restore(archive, goit.RestoreOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !slices.Contains(msgs, "create repository \"proj\" (1)") {
+		t.Error("Expected repository to be planned, got", msgs)
+	}
+
+	if users, err := goit.GetUsers(); err != nil {
+		t.Fatal(err.Error())
+	} else if len(users) != 0 {
+		t.Error("Expected no users, got", users)
+	}
+
+	if _, err := os.Stat(goit.RepoPath("proj", true)); !os.IsNotExist(err) {
+		t.Error("Expected repository not to be created, got", err)
+	}
+}
+
+func TestRestoreConflict(t *testing.T) {
+	t.Run("fail", func(t *testing.T) {
+		archive, _, _ := newTestBackup(t)
+
+		if _, err := goit.Restore(archive, goit.RestoreOptions{Conflict: goit.ConflictFail}); err == nil {
+			t.Error("Expected existing users and repositories to fail the restore")
+		}
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		archive, hash, _ := newTestBackup(t)
+		next := testCommit(t, "proj", "master", "Second commit")
+
+		msgs, err := goit.Restore(archive, goit.RestoreOptions{Conflict: goit.ConflictSkip})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if !slices.Contains(msgs, "skip existing repository \"proj\"") {
+			t.Error("Expected repository to be skipped, got", msgs)
+		}
+
+		out, err := exec.Command("git", "--git-dir", goit.RepoPath("proj", true), "rev-parse", "master").Output()
+		if err != nil {
+			t.Fatal(err.Error())
+		} else if got := strings.TrimSpace(string(out)); got != next || got == hash {
+			t.Error("Expected master to remain at", next, "got", got)
+		}
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		archive, hash, token := newTestBackup(t)
+		testCommit(t, "proj", "master", "Second commit")
+
+		repo, err := goit.GetRepoByName("proj")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		repo.Description = "Changed"
+		if err := goit.UpdateRepo(repo.Id, *repo); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if err := goit.SetCollaborator(repo.Id, 2, goit.AccessNone); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if _, err := goit.Restore(archive, goit.RestoreOptions{Conflict: goit.ConflictOverwrite}); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		checkTestBackup(t, hash, token)
+
+		/* Settings of overwritten users are replaced rather than duplicated */
+		if hooks, err := goit.GetWebhooks(1, goit.AllRepos); err != nil {
+			t.Fatal(err.Error())
+		} else if len(hooks) != 1 {
+			t.Error("Expected one user webhook, got", hooks)
+		}
+	})
+}
+
+/* Write a backup archive containing only a database dump of users and repositories. */
+func testDumpArchive(t *testing.T, users []goit.User, repos []goit.Repo) string {
+	t.Helper()
+
+	archive := filepath.Join(t.TempDir(), "goit_20240101T000000Z.zip")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("goit_20240101T000000Z/goit.json")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := json.NewEncoder(w).Encode(map[string]any{"users": users, "repos": repos}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return archive
+}
+
+func TestRestoreIllegalNames(t *testing.T) {
+	alice := goit.User{Id: 1, Name: "alice", Pass: []byte{}, Salt: []byte{}}
+
+	tests := []struct {
+		name  string
+		users []goit.User
+		repos []goit.Repo
+	}{
+		{"user", []goit.User{{Id: 1, Name: "al ice", Pass: []byte{}, Salt: []byte{}}}, nil},
+		{"reserved user", []goit.User{{Id: 1, Name: "api", Pass: []byte{}, Salt: []byte{}}}, nil},
+		{"parent", []goit.User{alice}, []goit.Repo{{Id: 1, OwnerId: 1, Name: "../escape"}}},
+		{"nested parent", []goit.User{alice}, []goit.Repo{{Id: 1, OwnerId: 1, Name: "group/../../escape"}}},
+		{"absolute", []goit.User{alice}, []goit.Repo{{Id: 1, OwnerId: 1, Name: "/escape"}}},
+		{"dot", []goit.User{alice}, []goit.Repo{{Id: 1, OwnerId: 1, Name: "group/./proj"}}},
+		{"reserved repository", []goit.User{alice}, []goit.Repo{{Id: 1, OwnerId: 1, Name: "static/proj"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestInstance(t)
+			archive := testDumpArchive(t, tt.users, tt.repos)
+
+			_, err := goit.Restore(archive, goit.RestoreOptions{})
+			if err == nil || !strings.Contains(err.Error(), "illegal") {
+				t.Fatal("Expected the restore to be refused, got", err)
+			}
+
+			if users, err := goit.GetUsers(); err != nil {
+				t.Fatal(err.Error())
+			} else if len(users) != 0 {
+				t.Error("Expected no users to be restored, got", users)
+			}
+
+			if _, err := os.Stat(filepath.Join(goit.Conf.DataPath, "escape.git")); !os.IsNotExist(err) {
+				t.Error("Expected nothing to be written outside of the repositories directory, got", err)
+			}
+		})
+	}
+}
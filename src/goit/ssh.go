@@ -0,0 +1,288 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package goit
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"database/sql"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Jamozed/Goit/src/util"
+	"golang.org/x/crypto/ssh"
+)
+
+/* Create the SSH server configuration, generating a host key if necessary. */
+func SshConfig() (*ssh.ServerConfig, error) {
+	conf := &ssh.ServerConfig{
+		ServerVersion: "SSH-2.0-Goit",
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			user, err := GetUserBySshKey(key)
+			if err != nil {
+				util.Errorln("[ssh]", err.Error())
+				return nil, err
+			}
+
+			if user == nil {
+				return nil, errors.New("unknown public key")
+			}
+
+			return &ssh.Permissions{Extensions: map[string]string{"uid": fmt.Sprint(user.Id)}}, nil
+		},
+	}
+
+	path := Conf.SshHostKey
+	if path == "" {
+		path = filepath.Join(Conf.DataPath, "ssh_host_ed25519_key")
+	}
+
+	signer, err := loadHostKey(path)
+	if err != nil {
+		return nil, err
+	}
+
+	conf.AddHostKey(signer)
+	return conf, nil
+}
+
+/* Accept and handle SSH connections until the listener is closed. */
+func ServeSsh(l net.Listener, conf *ssh.ServerConfig) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			util.Errorln("[ssh]", err.Error())
+			continue
+		}
+
+		go handleSshConn(c, conf)
+	}
+}
+
+/* Get the user that owns an SSH public key, or nil if the key is unknown. */
+func GetUserBySshKey(key ssh.PublicKey) (*User, error) {
+	var uid int64
+
+	if err := db.QueryRow(
+		"SELECT owner_id FROM ssh_keys WHERE key = ?", strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+	).Scan(&uid); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		return nil, nil
+	}
+
+	return GetUser(uid)
+}
+
+func loadHostKey(path string) (ssh.Signer, error) {
+	if data, err := os.ReadFile(path); err == nil {
+		return ssh.ParsePrivateKey(data)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	util.Infoln("[ssh] generating host key", path)
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		return nil, err
+	}
+
+	return ssh.NewSignerFromKey(key)
+}
+
+func handleSshConn(c net.Conn, conf *ssh.ServerConfig) {
+	defer c.Close()
+
+	sc, chans, reqs, err := ssh.NewServerConn(c, conf)
+	if err != nil {
+		util.Debugln("[ssh]", c.RemoteAddr().String(), err.Error())
+		return
+	}
+	defer sc.Close()
+
+	go ssh.DiscardRequests(reqs)
+
+	uid, err := strconv.ParseInt(sc.Permissions.Extensions["uid"], 10, 64)
+	if err != nil {
+		util.Errorln("[ssh]", err.Error())
+		return
+	}
+
+	ip, _, _ := net.SplitHostPort(sc.RemoteAddr().String())
+
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		ch, creqs, err := nc.Accept()
+		if err != nil {
+			util.Errorln("[ssh]", err.Error())
+			continue
+		}
+
+		go handleSshSession(ch, creqs, uid, ip)
+	}
+}
+
+func handleSshSession(ch ssh.Channel, reqs <-chan *ssh.Request, uid int64, ip string) {
+	defer ch.Close()
+
+	var env []string
+
+	for req := range reqs {
+		switch req.Type {
+		case "env":
+			var kv struct{ Name, Value string }
+			if err := ssh.Unmarshal(req.Payload, &kv); err == nil && kv.Name == "GIT_PROTOCOL" {
+				env = append(env, kv.Name+"="+kv.Value)
+			}
+
+			req.Reply(true, nil)
+
+		case "exec":
+			var cmd struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &cmd); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+
+			req.Reply(true, nil)
+			go ssh.DiscardRequests(reqs)
+
+			status := sshExec(ch, uid, ip, cmd.Command, env)
+
+			ch.CloseWrite()
+			ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+			return
+
+		case "shell":
+			req.Reply(true, nil)
+			go ssh.DiscardRequests(reqs)
+
+			fmt.Fprintln(ch.Stderr(), "Goit does not provide shell access")
+
+			ch.CloseWrite()
+			ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{1}))
+			return
+
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+/* Run a Git command requested over SSH, returning its exit status. */
+func sshExec(ch ssh.Channel, uid int64, ip, command string, env []string) uint32 {
+	service, arg, _ := strings.Cut(command, " ")
+	if service != "git-upload-pack" && service != "git-receive-pack" {
+		fmt.Fprintln(ch.Stderr(), "Unsupported command:", service)
+		return 1
+	}
+
+	reponame := strings.TrimSuffix(strings.TrimPrefix(strings.Trim(arg, "'\""), "/"), ".git")
+
+	user, err := GetUser(uid)
+	if err != nil {
+		util.Errorln("[ssh]", err.Error())
+		fmt.Fprintln(ch.Stderr(), "Internal server error")
+		return 1
+	}
+
+	repo, err := GetRepoByName(reponame)
+	if err != nil {
+		util.Errorln("[ssh]", err.Error())
+		fmt.Fprintln(ch.Stderr(), "Internal server error")
+		return 1
+	}
+
+	if user == nil || repo == nil || !gitAllowed(repo, user, AccessAdmin, service) {
+		fmt.Fprintln(ch.Stderr(), "Repository \""+reponame+"\" not found")
+		return 1
+	}
+
+	util.Infoln("[ssh]", user.Name, service, repo.Name, "from", ip)
+
+	/* Pass stdin through a pipe so that the process does not wait on the channel after exiting */
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		util.Errorln("[ssh]", err.Error())
+		fmt.Fprintln(ch.Stderr(), "Internal server error")
+		return 1
+	}
+	defer pr.Close()
+
+	gc := newGitCounter(service, repo.Name)
+
+	go func() {
+		io.Copy(pw, gc.Reader(ch))
+		pw.Close()
+	}()
+
+	c, err := gitServiceCommand(service, repo, user, ".")
+	if err != nil {
+		util.Errorln("[ssh]", err.Error())
+		fmt.Fprintln(ch.Stderr(), "Internal server error")
+		return 1
+	}
+
+	c.AddEnv(env...)
+	c.Stderr = ch.Stderr()
+
+	/* Record the refs updated by a push for webhooks */
+	var pushLog string
+	if service == "git-receive-pack" {
+		if pushLog, err = newPushLog(); err != nil {
+			util.Errorln("[ssh]", err.Error())
+			fmt.Fprintln(ch.Stderr(), "Internal server error")
+			return 1
+		}
+
+		defer os.Remove(pushLog)
+		c.AddEnv("GOIT_PUSH_LOG=" + pushLog)
+	}
+
+	if _, _, err := c.Run(pr, gc.Writer(ch)); err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) {
+			return uint32(ee.ExitCode())
+		}
+
+		util.Errorln("[ssh]", err.Error())
+		return 1
+	}
+
+	gc.Count()
+
+	if service == "git-receive-pack" {
+		pushWebhooks(repo, user, pushLog)
+	}
+
+	return 0
+}
@@ -0,0 +1,84 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package goit_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/Jamozed/Goit/src/goit"
+)
+
+/* An SSH channel that reads from a fixed input and records what is written to it. */
+type testChannel struct {
+	in             io.Reader
+	out, errOutput bytes.Buffer
+}
+
+func (c *testChannel) Read(p []byte) (int, error)  { return c.in.Read(p) }
+func (c *testChannel) Write(p []byte) (int, error) { return c.out.Write(p) }
+func (c *testChannel) Close() error                { return nil }
+func (c *testChannel) CloseWrite() error           { return nil }
+func (c *testChannel) Stderr() io.ReadWriter       { return &c.errOutput }
+
+func (c *testChannel) SendRequest(name string, wantReply bool, payload []byte) (bool, error) {
+	return true, nil
+}
+
+func TestSshExec(t *testing.T) {
+	newTestInstance(t)
+
+	for _, name := range []string{"alice", "bob", "carol"} {
+		if err := goit.CreateUser(goit.User{Name: name, Pass: []byte{}, Salt: []byte{}}); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	rid, err := goit.CreateRepo(goit.Repo{OwnerId: 1, Name: "proj", DefaultBranch: "master", Visibility: goit.Private})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := goit.SetCollaborator(rid, 2, goit.AccessRead); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tests := []struct {
+		name    string
+		uid     int64
+		command string
+		status  uint32
+		stderr  string
+	}{
+		{"unsupported command", 1, "git-upload-archive 'proj.git'", 1, "Unsupported command: git-upload-archive"},
+		{"unknown repo", 1, "git-upload-pack 'none.git'", 1, `Repository "none" not found`},
+		{"upload-pack without access", 3, "git-upload-pack 'proj.git'", 1, `Repository "proj" not found`},
+		{"receive-pack without access", 3, "git-receive-pack 'proj.git'", 1, `Repository "proj" not found`},
+		{"receive-pack with read access", 2, "git-receive-pack '/proj.git'", 1, `Repository "proj" not found`},
+		{"upload-pack with read access", 2, "git-upload-pack '/proj.git'", 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			/* A flush packet ends the negotiation after the refs are advertised */
+			ch := &testChannel{in: strings.NewReader("0000")}
+
+			if status := goit.SshExec(ch, tt.uid, "127.0.0.1", tt.command, nil); status != tt.status {
+				t.Error("Expected status", tt.status, "got", status, ch.errOutput.String())
+			}
+
+			if got := strings.TrimSpace(ch.errOutput.String()); got != tt.stderr {
+				t.Errorf("Expected %q got %q", tt.stderr, got)
+			}
+
+			if tt.status == 0 && ch.out.Len() == 0 {
+				t.Error("Expected refs to be advertised")
+			} else if tt.status != 0 && ch.out.Len() != 0 {
+				t.Errorf("Expected no output, got %q", ch.out.String())
+			}
+		})
+	}
+}
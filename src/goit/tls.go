@@ -0,0 +1,95 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package goit
+
+import (
+	"crypto/tls"
+	"errors"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/Jamozed/Goit/src/util"
+)
+
+/* A certificate served over HTTPS, with the modification times of its files when it was loaded. */
+type certificate struct {
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+}
+
+var cert atomic.Pointer[certificate]
+
+/* Report whether Goit terminates TLS itself, which is when a certificate and key are configured. */
+func TlsEnabled() bool {
+	return Conf.TlsCert != "" && Conf.TlsKey != ""
+}
+
+/* A TLS config serving the most recently loaded certificate, so that reloading it applies to new connections. */
+func TlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if c := cert.Load(); c != nil {
+				return c.cert, nil
+			}
+
+			return nil, errors.New("no certificate loaded")
+		},
+	}
+}
+
+/*
+Load the configured certificate and key, replacing the certificate being served. The previous certificate continues to
+be served if they fail to load, such as when only one of them has been replaced so far.
+*/
+func LoadCertificate() error {
+	certInfo, err := os.Stat(Conf.TlsCert)
+	if err != nil {
+		return err
+	}
+
+	keyInfo, err := os.Stat(Conf.TlsKey)
+	if err != nil {
+		return err
+	}
+
+	c, err := tls.LoadX509KeyPair(Conf.TlsCert, Conf.TlsKey)
+	if err != nil {
+		return err
+	}
+
+	cert.Store(&certificate{&c, certInfo.ModTime(), keyInfo.ModTime()})
+	return nil
+}
+
+/*
+Reload the certificate if its certificate or key file has been modified since it was loaded, or regardless of that if
+forced, such as on SIGHUP.
+*/
+func ReloadCertificate(force bool) error {
+	if !force {
+		certInfo, err := os.Stat(Conf.TlsCert)
+		if err != nil {
+			return err
+		}
+
+		keyInfo, err := os.Stat(Conf.TlsKey)
+		if err != nil {
+			return err
+		}
+
+		if c := cert.Load(); c != nil && c.certMod.Equal(certInfo.ModTime()) && c.keyMod.Equal(keyInfo.ModTime()) {
+			return nil
+		}
+	}
+
+	if err := LoadCertificate(); err != nil {
+		return err
+	}
+
+	util.Infoln("[tls] reloaded certificate", Conf.TlsCert)
+	return nil
+}
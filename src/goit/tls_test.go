@@ -0,0 +1,145 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package goit_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Jamozed/Goit/src/goit"
+)
+
+/* Generate a self-signed certificate and its key, PEM encoded. */
+func testCertificate(t *testing.T, name string) (cert, key []byte) {
+	t.Helper()
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1), DNSNames: []string{name},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})
+}
+
+/* Write a certificate or key file, with a modification time. */
+func writeTestPem(t *testing.T, path string, data []byte, mod time.Time) {
+	t.Helper()
+
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := os.Chtimes(path, mod, mod); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func TestCertificate(t *testing.T) {
+	conf := goit.Conf
+	t.Cleanup(func() { goit.Conf = conf })
+
+	dir := t.TempDir()
+	goit.Conf.TlsCert, goit.Conf.TlsKey = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	config := goit.TlsConfig()
+
+	/* Check that the certificate served is the one that was generated */
+	served := func(t *testing.T, want []byte) {
+		t.Helper()
+
+		c, err := config.GetCertificate(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		block, _ := pem.Decode(want)
+		if !bytes.Equal(c.Certificate[0], block.Bytes) {
+			t.Error("Expected a different certificate to be served")
+		}
+	}
+
+	if err := goit.LoadCertificate(); err == nil {
+		t.Error("Expected missing files to fail to load")
+	}
+
+	certA, keyA := testCertificate(t, "a.example.com")
+	certB, keyB := testCertificate(t, "b.example.com")
+	certC, keyC := testCertificate(t, "c.example.com")
+
+	mod := time.Now().Add(-time.Hour).Truncate(time.Second)
+	writeTestPem(t, goit.Conf.TlsCert, certA, mod)
+	writeTestPem(t, goit.Conf.TlsKey, keyA, mod)
+
+	t.Run("load", func(t *testing.T) {
+		if err := goit.LoadCertificate(); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		served(t, certA)
+	})
+
+	t.Run("unmodified", func(t *testing.T) {
+		/* Files replaced without changing their modification times are not reloaded unless forced */
+		writeTestPem(t, goit.Conf.TlsCert, certB, mod)
+		writeTestPem(t, goit.Conf.TlsKey, keyB, mod)
+
+		if err := goit.ReloadCertificate(false); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		served(t, certA)
+
+		if err := goit.ReloadCertificate(true); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		served(t, certB)
+	})
+
+	t.Run("mismatched", func(t *testing.T) {
+		/* A certificate replaced before its key fails to load, and the previous certificate continues to be served */
+		writeTestPem(t, goit.Conf.TlsCert, certC, mod.Add(time.Minute))
+
+		if err := goit.ReloadCertificate(false); err == nil {
+			t.Error("Expected a mismatched certificate and key to fail to load")
+		}
+
+		served(t, certB)
+	})
+
+	t.Run("modified", func(t *testing.T) {
+		writeTestPem(t, goit.Conf.TlsKey, keyC, mod.Add(time.Minute))
+
+		if err := goit.ReloadCertificate(false); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		served(t, certC)
+	})
+}
@@ -0,0 +1,173 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package goit
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+)
+
+type Token struct {
+	Id       int64     `json:"id"`
+	OwnerId  int64     `json:"owner_id"`
+	Name     string    `json:"name"`
+	Hash     []byte    `json:"-"`
+	Scope    Access    `json:"scope"`
+	Created  time.Time `json:"created"`
+	Expiry   time.Time `json:"expiry"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+/* Generate a new personal access token for a user, returning the token and its plaintext value. */
+func NewToken(uid int64, name string, scope Access, expiry time.Time) (Token, string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return Token{}, "", err
+	}
+
+	value := "goit_" + hex.EncodeToString(b)
+	t := Token{OwnerId: uid, Name: name, Hash: hashToken(value), Scope: scope, Created: time.Now(), Expiry: expiry}
+
+	res, err := db.Exec(
+		"INSERT INTO tokens (owner_id, name, hash, scope, created, expiry, last_used) VALUES (?, ?, ?, ?, ?, ?, 0)",
+		t.OwnerId, t.Name, t.Hash, t.Scope, t.Created.Unix(), unixOrZero(t.Expiry),
+	)
+	if err != nil {
+		return Token{}, "", err
+	}
+
+	t.Id, _ = res.LastInsertId()
+	return t, value, nil
+}
+
+func GetTokens(uid int64) ([]Token, error) {
+	tokens := []Token{}
+
+	rows, err := db.Query(
+		"SELECT id, owner_id, name, hash, scope, created, expiry, last_used FROM tokens WHERE owner_id = ? ORDER BY id",
+		uid,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+/* Delete a token owned by a user. */
+func DelToken(uid, tid int64) error {
+	if _, err := db.Exec("DELETE FROM tokens WHERE id = ? AND owner_id = ?", tid, uid); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+/* Check a token value for a user, returning the token if it is valid and unexpired, and marking it as used. */
+func CheckToken(uid int64, value string) (*Token, error) {
+	t, err := scanToken(db.QueryRow(
+		"SELECT id, owner_id, name, hash, scope, created, expiry, last_used FROM tokens WHERE hash = ?",
+		hashToken(value),
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	if t.OwnerId != uid || (!t.Expiry.IsZero() && t.Expiry.Before(time.Now())) {
+		return nil, nil
+	}
+
+	t.LastUsed = time.Now()
+	if _, err := db.Exec("UPDATE tokens SET last_used = ? WHERE id = ?", t.LastUsed.Unix(), t.Id); err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+/*
+Authenticate HTTP Basic credentials using either a password or a token, returning the user and the highest access
+level the credentials allow. A nil user is returned if credentials are absent or invalid.
+*/
+func BasicAuth(r *http.Request) (*User, Access, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, AccessNone, nil
+	}
+
+	user, err := GetUserByName(username)
+	if err != nil || user == nil {
+		return nil, AccessNone, err
+	}
+
+	if bytes.Equal(Hash(password, user.Salt), user.Pass) {
+		return user, AccessAdmin, nil
+	}
+
+	if t, err := CheckToken(user.Id, password); err != nil || t == nil {
+		return nil, AccessNone, err
+	} else {
+		return user, t.Scope, nil
+	}
+}
+
+func hashToken(value string) []byte {
+	h := sha256.Sum256([]byte(value))
+	return h[:]
+}
+
+func scanToken(row interface{ Scan(...any) error }) (Token, error) {
+	t := Token{}
+	var created, expiry, lastUsed int64
+
+	if err := row.Scan(&t.Id, &t.OwnerId, &t.Name, &t.Hash, &t.Scope, &created, &expiry, &lastUsed); err != nil {
+		return Token{}, err
+	}
+
+	t.Created = time.Unix(created, 0)
+	t.Expiry = timeOrZero(expiry)
+	t.LastUsed = timeOrZero(lastUsed)
+	return t, nil
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.Unix()
+}
+
+func timeOrZero(unix int64) time.Time {
+	if unix == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(unix, 0)
+}
@@ -134,3 +134,32 @@ func UpdatePassword(uid int64, password string) error {
 
 	return nil
 }
+
+/*
+Delete a user, along with their SSH keys, tokens, sessions, webhooks, and access to repositories. Repositories owned by
+the user are not deleted, so they must be transferred or deleted first.
+*/
+func DelUser(uid int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, query := range []string{
+		"DELETE FROM ssh_keys WHERE owner_id = ?",
+		"DELETE FROM tokens WHERE owner_id = ?",
+		"DELETE FROM sessions WHERE owner_id = ?",
+		"DELETE FROM deliveries WHERE hook_id IN (SELECT id FROM webhooks WHERE owner_id = ?)",
+		"DELETE FROM webhooks WHERE owner_id = ?",
+		"DELETE FROM collaborators WHERE user_id = ?",
+		"DELETE FROM protected_users WHERE user_id = ?",
+		"DELETE FROM users WHERE id = ?",
+	} {
+		if _, err := tx.Exec(query, uid); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,657 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package goit
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Jamozed/Goit/src/util"
+)
+
+/* The repository ID of webhooks that apply to every repository of their owner. */
+const AllRepos int64 = -1
+
+type Webhook struct {
+	Id      int64
+	OwnerId int64
+	RepoId  int64
+	Url     string
+	Secret  string
+	Created time.Time
+}
+
+type Delivery struct {
+	Id       int64
+	HookId   int64
+	Event    string
+	Payload  []byte
+	Status   int
+	Response string
+	Error    string
+	Created  time.Time
+	Duration time.Duration
+}
+
+type WebhookRepo struct {
+	Id            int64  `json:"id"`
+	Name          string `json:"name"`
+	Owner         string `json:"owner"`
+	Description   string `json:"description"`
+	DefaultBranch string `json:"default_branch"`
+	Visibility    string `json:"visibility"`
+}
+
+type WebhookUser struct {
+	Id   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+type WebhookRef struct {
+	Ref    string `json:"ref"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+type WebhookPayload struct {
+	Event         string       `json:"event"`
+	Repository    WebhookRepo  `json:"repository"`
+	Sender        *WebhookUser `json:"sender"`
+	Refs          []WebhookRef `json:"refs,omitempty"`
+	PreviousOwner *WebhookUser `json:"previous_owner,omitempty"`
+}
+
+const (
+	deliveryLimit    = 50
+	deliveryResponse = 4096
+)
+
+/* Deliveries in progress, which are waited for on shutdown. */
+var delivering sync.WaitGroup
+
+/*
+The client that delivers webhooks, which refuses to connect to internal addresses. Addresses are checked when dialing
+rather than when a webhook is created, so that a hostname cannot be rebound to an internal address afterwards.
+Proxies are not used, as the proxy would make the connection instead.
+*/
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(network, address string, c syscall.RawConn) error { return checkWebhookAddr(address) },
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+}
+
+/* Check a webhook URL, returning a message if it is invalid. */
+func ValidateWebhookUrl(s string) string {
+	if s == "" {
+		return "URL cannot be empty"
+	}
+
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "URL \"" + s + "\" is invalid"
+	}
+
+	host := u.Hostname()
+	if ip, err := netip.ParseAddr(host); (err == nil && internalAddr(ip)) || strings.EqualFold(host, "localhost") {
+		return "URL \"" + s + "\" is an internal address"
+	}
+
+	return ""
+}
+
+/* Check that a webhook is not connecting to an internal address, given as an IP and port. */
+func checkWebhookAddr(address string) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+
+	if internalAddr(ap.Addr()) {
+		return fmt.Errorf("connecting to internal address %s is not allowed", ap.Addr())
+	}
+
+	return nil
+}
+
+/*
+Ranges that are not publicly routable, beyond those reported by the methods of netip.Addr: this network, shared address
+space, protocol assignments, benchmarking, and local-use NAT64.
+*/
+var internalPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+}
+
+/* IPv6 ranges that embed an IPv4 address, with the offset of that address. */
+var embeddingPrefixes = []struct {
+	prefix netip.Prefix
+	offset int
+}{
+	{netip.MustParsePrefix("::/96"), 12},        /* IPv4-compatible */
+	{netip.MustParsePrefix("64:ff9b::/96"), 12}, /* NAT64 */
+	{netip.MustParsePrefix("2002::/16"), 2},     /* 6to4 */
+}
+
+/*
+Report whether an address is loopback, private, link-local, unspecified, or in another range that is not publicly
+routable. IPv4 addresses that are mapped to or embedded in IPv6 addresses are checked as IPv4 addresses.
+*/
+func internalAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+
+	if ip.Is6() {
+		for _, e := range embeddingPrefixes {
+			if e.prefix.Contains(ip) {
+				b := ip.As16()
+				return internalAddr(netip.AddrFrom4([4]byte(b[e.offset : e.offset+4])))
+			}
+		}
+	}
+
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() {
+		return true
+	}
+
+	for _, p := range internalPrefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func CreateWebhook(hook Webhook) (int64, error) {
+	res, err := db.Exec(
+		"INSERT INTO webhooks (owner_id, repo_id, url, secret, created) VALUES (?, ?, ?, ?, ?)",
+		hook.OwnerId, hook.RepoId, hook.Url, hook.Secret, time.Now().Unix(),
+	)
+	if err != nil {
+		return -1, err
+	}
+
+	return res.LastInsertId()
+}
+
+/* Get the webhooks of a repository, or of a user for all of their repositories if rid is AllRepos. */
+func GetWebhooks(uid, rid int64) ([]Webhook, error) {
+	hooks := []Webhook{}
+
+	query := "SELECT id, owner_id, repo_id, url, secret, created FROM webhooks WHERE repo_id = ?"
+	args := []any{rid}
+	if rid == AllRepos {
+		query, args = query+" AND owner_id = ?", append(args, uid)
+	}
+
+	rows, err := db.Query(query+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var h Webhook
+		var created int64
+
+		if err := rows.Scan(&h.Id, &h.OwnerId, &h.RepoId, &h.Url, &h.Secret, &created); err != nil {
+			return nil, err
+		}
+
+		h.Created = time.Unix(created, 0)
+		hooks = append(hooks, h)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return hooks, nil
+}
+
+/* Delete a webhook of a repository, or of a user if rid is AllRepos, along with its deliveries. */
+func DelWebhook(uid, rid, hid int64) error {
+	query := "DELETE FROM webhooks WHERE id = ? AND repo_id = ?"
+	args := []any{hid, rid}
+	if rid == AllRepos {
+		query, args = query+" AND owner_id = ?", append(args, uid)
+	}
+
+	if res, err := db.Exec(query, args...); err != nil {
+		return err
+	} else if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+
+	if _, err := db.Exec("DELETE FROM deliveries WHERE hook_id = ?", hid); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+/* Delete the webhooks of a repository and their deliveries. */
+func delRepoWebhooks(tx *sql.Tx, rid int64) error {
+	if _, err := tx.Exec(
+		"DELETE FROM deliveries WHERE hook_id IN (SELECT id FROM webhooks WHERE repo_id = ?)", rid,
+	); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM webhooks WHERE repo_id = ?", rid); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+/* Get the most recent deliveries of a set of webhooks, newest first. */
+func GetDeliveries(hooks []Webhook) ([]Delivery, error) {
+	deliveries := []Delivery{}
+
+	for _, h := range hooks {
+		rows, err := db.Query(
+			`SELECT id, hook_id, event, payload, status, response, error, created, duration FROM deliveries
+			WHERE hook_id = ? ORDER BY id DESC LIMIT ?`, h.Id, deliveryLimit,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			d, err := scanDelivery(rows)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+
+			deliveries = append(deliveries, d)
+		}
+
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	slices.SortFunc(deliveries, func(a, b Delivery) int { return cmp.Compare(b.Id, a.Id) })
+
+	return deliveries, nil
+}
+
+/* Resend the payload of a previous delivery of one of a set of webhooks. */
+func Redeliver(hooks []Webhook, did int64) error {
+	d, err := scanDelivery(db.QueryRow(
+		`SELECT id, hook_id, event, payload, status, response, error, created, duration FROM deliveries WHERE id = ?`,
+		did,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("delivery %d not found", did)
+	} else if err != nil {
+		return err
+	}
+
+	for _, h := range hooks {
+		if h.Id == d.HookId {
+			deliverAsync(h, d.Event, d.Payload)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("delivery %d not found", did)
+}
+
+func scanDelivery(row interface{ Scan(...any) error }) (Delivery, error) {
+	var d Delivery
+	var created, duration int64
+
+	if err := row.Scan(
+		&d.Id, &d.HookId, &d.Event, &d.Payload, &d.Status, &d.Response, &d.Error, &created, &duration,
+	); err != nil {
+		return Delivery{}, err
+	}
+
+	d.Created, d.Duration = time.Unix(created, 0), time.Duration(duration)*time.Millisecond
+	return d, nil
+}
+
+/*
+Send an event to the webhooks of a repository and of its owners, in the background. The owners are the current owner
+and, for a transfer, the previous owner.
+*/
+func DispatchWebhooks(event string, repo *Repo, sender *User, refs []WebhookRef, prev *User) {
+	owner, err := GetUser(repo.OwnerId)
+	if err != nil {
+		util.Errorln("[webhook]", err.Error())
+		return
+	}
+
+	payload := WebhookPayload{
+		Event: event, Refs: refs,
+		Repository: WebhookRepo{
+			Id: repo.Id, Name: repo.Name, Description: repo.Description, DefaultBranch: repo.DefaultBranch,
+			Visibility: repo.Visibility.String(),
+		},
+	}
+
+	if owner != nil {
+		payload.Repository.Owner = owner.Name
+	}
+	if sender != nil {
+		payload.Sender = &WebhookUser{Id: sender.Id, Name: sender.Name}
+	}
+	if prev != nil {
+		payload.PreviousOwner = &WebhookUser{Id: prev.Id, Name: prev.Name}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		util.Errorln("[webhook]", err.Error())
+		return
+	}
+
+	hooks, err := GetWebhooks(repo.OwnerId, repo.Id)
+	if err != nil {
+		util.Errorln("[webhook]", err.Error())
+		return
+	}
+
+	if userHooks, err := GetWebhooks(repo.OwnerId, AllRepos); err != nil {
+		util.Errorln("[webhook]", err.Error())
+		return
+	} else {
+		hooks = append(hooks, userHooks...)
+	}
+
+	if prev != nil && prev.Id != repo.OwnerId {
+		if prevHooks, err := GetWebhooks(prev.Id, AllRepos); err != nil {
+			util.Errorln("[webhook]", err.Error())
+			return
+		} else {
+			hooks = append(hooks, prevHooks...)
+		}
+	}
+
+	for _, h := range hooks {
+		deliverAsync(h, event, body)
+	}
+}
+
+/* Dispatch transfer webhooks for a repository that has been transferred from its current owner to another user. */
+func TransferWebhooks(repo Repo, uid int64, sender *User) {
+	prev, err := GetUser(repo.OwnerId)
+	if err != nil {
+		util.Errorln("[webhook]", err.Error())
+		return
+	}
+
+	repo.OwnerId = uid
+	DispatchWebhooks("transfer", &repo, sender, nil, prev)
+}
+
+/* Sign a payload with the secret of a webhook, as sent in the X-Goit-Signature-256 header. */
+func signPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+/* Deliver a payload to a webhook in the background. */
+func deliverAsync(hook Webhook, event string, payload []byte) {
+	delivering.Add(1)
+	go func() {
+		defer delivering.Done()
+		deliver(hook, event, payload)
+	}()
+}
+
+/* Wait for webhook deliveries in progress to finish, or for a context to be done. */
+func WaitWebhooks(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		delivering.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("webhook deliveries still running: %w", ctx.Err())
+	}
+}
+
+/* Send a payload to a webhook, signed with its secret, and record the delivery. */
+func deliver(hook Webhook, event string, payload []byte) {
+	d := Delivery{HookId: hook.Id, Event: event, Payload: payload, Created: time.Now()}
+
+	req, err := http.NewRequest(http.MethodPost, hook.Url, bytes.NewReader(payload))
+	if err != nil {
+		d.Error = err.Error()
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "Goit-Webhook")
+		req.Header.Set("X-Goit-Event", event)
+
+		if hook.Secret != "" {
+			req.Header.Set("X-Goit-Signature-256", signPayload(hook.Secret, payload))
+		}
+
+		if res, err := webhookClient.Do(req); err != nil {
+			d.Error = err.Error()
+		} else {
+			/* Only record response bodies for administrators, as they may reveal services that users cannot reach */
+			if owner, err := GetUser(hook.OwnerId); err != nil {
+				util.Errorln("[webhook]", err.Error())
+			} else if owner != nil && owner.IsAdmin {
+				b, _ := io.ReadAll(io.LimitReader(res.Body, deliveryResponse))
+				d.Response = string(b)
+			}
+
+			res.Body.Close()
+			d.Status = res.StatusCode
+		}
+	}
+
+	d.Duration = time.Since(d.Created)
+
+	if d.Error != "" {
+		util.Errorln("[webhook]", hook.Id, event, d.Error)
+	}
+
+	/* Only record the delivery if the webhook still exists */
+	if _, err := db.Exec(
+		`INSERT INTO deliveries (hook_id, event, payload, status, response, error, created, duration)
+		SELECT ?, ?, ?, ?, ?, ?, ?, ? WHERE EXISTS (SELECT 1 FROM webhooks WHERE id = ?)`,
+		d.HookId, d.Event, d.Payload, d.Status, d.Response, d.Error, d.Created.Unix(), d.Duration.Milliseconds(),
+		hook.Id,
+	); err != nil {
+		util.Errorln("[webhook]", err.Error())
+		return
+	}
+
+	/* Prune old deliveries of the webhook */
+	if _, err := db.Exec(
+		`DELETE FROM deliveries WHERE hook_id = ? AND id NOT IN
+		(SELECT id FROM deliveries WHERE hook_id = ? ORDER BY id DESC LIMIT ?)`, hook.Id, hook.Id, deliveryLimit,
+	); err != nil {
+		util.Errorln("[webhook]", err.Error())
+	}
+}
+
+type hookRow struct{ Id, Url, Created string }
+
+type deliveryRow struct {
+	Id, Url, Event, Status, Created, Duration string
+	Payload, Response, Error                  string
+	Ok                                        bool
+}
+
+/* Template fields for a list of webhooks, their recent deliveries, and a form to add a webhook. */
+type WebhookFields struct {
+	Action     string
+	Hooks      []hookRow
+	Deliveries []deliveryRow
+
+	Url, Secret, Message string
+	CsrfField            template.HTML
+}
+
+/*
+Handle a webhook form action posted to a settings page, for the webhooks of a repository or of a user if rid is
+AllRepos. Returns true if the action was completed and the page should be reloaded.
+*/
+func WebhookAction(r *http.Request, uid, rid int64, f *WebhookFields) (bool, error) {
+	hooks, err := GetWebhooks(uid, rid)
+	if err != nil {
+		return false, err
+	}
+
+	switch r.FormValue("action") {
+	case "webhook":
+		f.Url, f.Secret = strings.TrimSpace(r.FormValue("url")), r.FormValue("secret")
+
+		if msg := ValidateWebhookUrl(f.Url); msg != "" {
+			f.Message = msg
+		} else if len(f.Secret) > 256 {
+			f.Message = "Secret cannot exceed 256 characters"
+		} else if hid, err := CreateWebhook(Webhook{OwnerId: uid, RepoId: rid, Url: f.Url, Secret: f.Secret}); err != nil {
+			return false, err
+		} else {
+			util.Infoln("[webhook] User", uid, "created webhook", hid, "for", rid)
+			return true, nil
+		}
+
+	case "unhook":
+		if hid, err := strconv.ParseInt(r.FormValue("hook"), 10, 64); err != nil {
+			f.Message = "Webhook is invalid"
+		} else if err := DelWebhook(uid, rid, hid); err != nil {
+			return false, err
+		} else {
+			util.Infoln("[webhook] User", uid, "deleted webhook", hid, "for", rid)
+			return true, nil
+		}
+
+	case "redeliver":
+		if did, err := strconv.ParseInt(r.FormValue("delivery"), 10, 64); err != nil {
+			f.Message = "Delivery is invalid"
+		} else if err := Redeliver(hooks, did); err != nil {
+			f.Message = "Delivery is invalid"
+		} else {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+/*
+Populate template fields with the webhooks of a repository, or of a user if rid is AllRepos. Response bodies are only
+shown to administrators.
+*/
+func (f *WebhookFields) Load(uid, rid int64, admin bool) error {
+	hooks, err := GetWebhooks(uid, rid)
+	if err != nil {
+		return err
+	}
+
+	deliveries, err := GetDeliveries(hooks)
+	if err != nil {
+		return err
+	}
+
+	urls := map[int64]string{}
+	for _, h := range hooks {
+		urls[h.Id] = h.Url
+		f.Hooks = append(f.Hooks, hookRow{Id: fmt.Sprint(h.Id), Url: h.Url, Created: h.Created.Format(time.DateTime)})
+	}
+
+	for _, d := range deliveries {
+		payload := &bytes.Buffer{}
+		if err := json.Indent(payload, d.Payload, "", "  "); err != nil {
+			payload = bytes.NewBuffer(d.Payload)
+		}
+
+		f.Deliveries = append(f.Deliveries, deliveryRow{
+			Id: fmt.Sprint(d.Id), Url: urls[d.HookId], Event: d.Event, Status: fmt.Sprint(d.Status),
+			Created: d.Created.Format(time.DateTime), Duration: d.Duration.String(),
+			Payload: payload.String(), Response: util.If(admin, d.Response, ""), Error: d.Error,
+			Ok: d.Error == "" && d.Status >= 200 && d.Status < 300,
+		})
+	}
+
+	return nil
+}
+
+/*
+Create a file for the post-receive hook to write the ref updates of a push to, which is passed to the hook in
+GOIT_PUSH_LOG. Push webhooks then report exactly the refs that the push updated, rather than any other changes made to
+the repository at the same time.
+*/
+func newPushLog() (string, error) {
+	f, err := os.CreateTemp("", "goit-push-*")
+	if err != nil {
+		return "", err
+	}
+
+	return f.Name(), f.Close()
+}
+
+/* Dispatch push webhooks for the ref updates written to a push log by the post-receive hook. */
+func pushWebhooks(repo *Repo, user *User, path string) {
+	refs, err := readPushLog(path)
+	if err != nil {
+		util.Errorln("[webhook]", err.Error())
+		return
+	}
+
+	if len(refs) != 0 {
+		DispatchWebhooks("push", repo, user, refs, nil)
+	}
+}
+
+/* Read the ref updates written to a push log. */
+func readPushLog(path string) ([]WebhookRef, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := []WebhookRef{}
+	for _, u := range parseRefUpdates(string(b)) {
+		refs = append(refs, WebhookRef{Ref: u.Ref, Before: u.Old, After: u.New})
+	}
+
+	return refs, nil
+}
@@ -0,0 +1,131 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package goit_test
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Jamozed/Goit/src/goit"
+)
+
+func TestValidateWebhookUrl(t *testing.T) {
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://example.com/hook", true},
+		{"http://203.0.113.7:8080/hook", true},
+		{"", false},
+		{"ftp://example.com/hook", false},
+		{"https:///hook", false},
+		{"http://localhost/hook", false},
+		{"http://127.0.0.1/hook", false},
+		{"http://10.0.0.1/hook", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+		{"http://[::1]/hook", false},
+		{"http://[::ffff:192.168.1.1]/hook", false},
+		{"http://[::ffff:c0a8:101]/hook", false},
+		{"http://100.64.0.1/hook", false},
+		{"http://[64:ff9b::a9fe:a9fe]/hook", false},
+		{"http://[64:ff9b::cb00:7107]/hook", true},
+	}
+
+	for _, tt := range tests {
+		if msg := goit.ValidateWebhookUrl(tt.url); (msg == "") != tt.ok {
+			t.Error("URL", tt.url, "expected ok", tt.ok, "got", msg)
+		}
+	}
+}
+
+func TestCheckWebhookAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		ok   bool
+	}{
+		{"93.184.216.34:443", true},
+		{"[2606:2800:220:1::1]:443", true},
+		{"127.0.0.1:80", false},
+		{"10.1.2.3:80", false},
+		{"172.16.0.1:80", false},
+		{"192.168.0.1:80", false},
+		{"169.254.169.254:80", false},
+		{"0.0.0.0:80", false},
+		{"[::1]:80", false},
+		{"[::]:80", false},
+		{"[fe80::1]:80", false},
+		{"[fd00::1]:80", false},
+		{"[::ffff:127.0.0.1]:80", false},
+		{"[::ffff:7f00:1]:80", false},
+		{"[::ffff:5db8:d822]:443", true},
+		{"0.1.2.3:80", false},
+		{"100.64.0.1:80", false},
+		{"100.127.255.254:80", false},
+		{"100.128.0.1:443", true},
+		{"192.0.0.8:80", false},
+		{"198.18.0.1:80", false},
+		{"198.19.255.254:80", false},
+		{"198.20.0.1:443", true},
+		{"[::10.0.0.1]:80", false},
+		{"[64:ff9b::7f00:1]:80", false},
+		{"[64:ff9b::5db8:d822]:443", true},
+		{"[64:ff9b:1::1]:80", false},
+		{"[2002:a9fe:a9fe::1]:80", false},
+		{"[2002:5db8:d822::1]:443", true},
+	}
+
+	for _, tt := range tests {
+		if err := goit.CheckWebhookAddr(tt.addr); (err == nil) != tt.ok {
+			t.Error("Address", tt.addr, "expected ok", tt.ok, "got", err)
+		}
+	}
+}
+
+func TestSignPayload(t *testing.T) {
+	/* Expected signature computed with "printf '{"event":"push"}' | openssl dgst -sha256 -hmac secret" */
+	want := "sha256=4a73af2e548d77ce1b343cd10dbbba9bb8f7995a28a583fd8600ec28d4ae2e0b"
+	if got := goit.SignPayload("secret", []byte(`{"event":"push"}`)); got != want {
+		t.Error("Expected", want, "got", got)
+	}
+}
+
+func TestReadPushLog(t *testing.T) {
+	zero, a, b := strings.Repeat("0", 40), strings.Repeat("a", 40), strings.Repeat("b", 40)
+	path := filepath.Join(t.TempDir(), "push")
+
+	input := a + " " + b + " refs/heads/master\n" + zero + " " + a + " refs/tags/v1\n" + b + " " + zero +
+		" refs/heads/old\n"
+	if err := os.WriteFile(path, []byte(input), 0o600); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	refs, err := goit.ReadPushLog(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	want := []goit.WebhookRef{
+		{Ref: "refs/heads/master", Before: a, After: b},
+		{Ref: "refs/tags/v1", Before: zero, After: a},
+		{Ref: "refs/heads/old", Before: b, After: zero},
+	}
+
+	if !slices.Equal(refs, want) {
+		t.Error("Expected", want, "got", refs)
+	}
+
+	/* A push that updates no refs leaves the log empty */
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if refs, err := goit.ReadPushLog(path); err != nil {
+		t.Fatal(err.Error())
+	} else if len(refs) != 0 {
+		t.Error("Expected no refs, got", refs)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package ipc
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/Jamozed/Goit/src/goit"
+	"github.com/Jamozed/Goit/src/util"
+	"golang.org/x/term"
+)
+
+/* The administration subcommands, with their positional parameters, of which those ending in "?" are optional. */
+var subcommands = map[string]struct {
+	params []string
+	usage  string
+}{
+	"user.list":      {nil, "user list"},
+	"user.create":    {[]string{"name"}, "user create [-admin] [-full-name <full name>] <name>"},
+	"user.delete":    {[]string{"name"}, "user delete <name>"},
+	"user.passwd":    {[]string{"name"}, "user passwd <name>"},
+	"repo.list":      {nil, "repo list"},
+	"repo.transfer":  {[]string{"repo", "owner"}, "repo transfer <repo> <owner>"},
+	"repo.pull":      {[]string{"repo"}, "repo pull <repo>"},
+	"cron.list":      {nil, "cron list"},
+	"session.list":   {[]string{"user"}, "session list <user>"},
+	"session.revoke": {[]string{"user", "id?"}, "session revoke <user> [<session id>]"},
+}
+
+/*
+Run an administration subcommand against a running instance, returning an exit status. Passwords are read from the
+terminal, or from the first line of standard input if it is not a terminal.
+*/
+func Main(args []string) int {
+	if len(args) < 2 {
+		Usage(os.Stderr)
+		return 2
+	}
+
+	req := Request{Command: args[0] + "." + args[1], Args: map[string]string{}}
+
+	sub, ok := subcommands[req.Command]
+	if !ok {
+		fmt.Fprintln(os.Stderr, "goit: unknown command \""+args[0]+" "+args[1]+"\"")
+		Usage(os.Stderr)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("goit "+args[0]+" "+args[1], flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: goit", sub.usage)
+		fs.PrintDefaults()
+	}
+
+	var admin bool
+	var fullName string
+	if req.Command == "user.create" {
+		fs.BoolVar(&admin, "admin", false, "Make the user an administrator")
+		fs.StringVar(&fullName, "full-name", "", "The full name of the user")
+	}
+
+	fs.Parse(args[2:])
+
+	required := len(slices.DeleteFunc(slices.Clone(sub.params), func(p string) bool { return strings.HasSuffix(p, "?") }))
+	if fs.NArg() < required || fs.NArg() > len(sub.params) {
+		fs.Usage()
+		return 2
+	}
+
+	for i, arg := range fs.Args() {
+		req.Args[strings.TrimSuffix(sub.params[i], "?")] = arg
+	}
+
+	if req.Command == "user.create" {
+		req.Args["admin"] = util.If(admin, "true", "false")
+		req.Args["full_name"] = fullName
+	}
+
+	if req.Command == "user.create" || req.Command == "user.passwd" {
+		password, err := readPassword()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "goit:", err.Error())
+			return 1
+		}
+
+		req.Args["password"] = password
+	}
+
+	return Run(req, nil)
+}
+
+/* Print the usage of the administration subcommands. */
+func Usage(w io.Writer) {
+	var usages []string
+	for _, sub := range subcommands {
+		usages = append(usages, sub.usage)
+	}
+
+	slices.Sort(usages)
+
+	fmt.Fprintln(w, "commands:")
+	for _, usage := range usages {
+		fmt.Fprintln(w, "  goit", usage)
+	}
+}
+
+/*
+Send a request to a running instance and print its output, returning an exit status. Data sent before the response is
+written to w, in which case the output is printed to standard error.
+*/
+func Run(req Request, w io.Writer) int {
+	if err := goit.LoadConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, "goit:", err.Error())
+		return 1
+	}
+
+	res, err := Send(req, w)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "goit:", err.Error())
+		return 1
+	}
+
+	out := util.If[io.Writer](w == nil, os.Stdout, os.Stderr)
+	for _, line := range res.Output {
+		fmt.Fprintln(out, line)
+	}
+
+	if !res.Ok {
+		fmt.Fprintln(os.Stderr, "ERROR:", res.Error)
+		return 1
+	}
+
+	return 0
+}
+
+/* Read a password, prompting for it twice if standard input is a terminal, otherwise reading its first line. */
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Fprint(os.Stderr, "Confirm password: ")
+	confirm, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+
+	if string(password) != string(confirm) {
+		return "", errors.New("passwords do not match")
+	}
+
+	return string(password), nil
+}
@@ -0,0 +1,368 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package ipc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Jamozed/Goit/src/goit"
+	"github.com/Jamozed/Goit/src/util"
+)
+
+func cmdBackup(w io.Writer, args map[string]string) ([]string, error) {
+	opts := goit.BackupOptions{
+		Mode:   goit.BackupModeFromString(args["mode"]),
+		Format: goit.BackupFormatFromString(util.If(args["format"] == "", goit.Conf.BackupFormat, args["format"])),
+	}
+
+	if opts.Mode == -1 {
+		return nil, errors.New("invalid backup mode \"" + args["mode"] + "\"")
+	} else if opts.Format == -1 {
+		return nil, errors.New("invalid backup format \"" + args["format"] + "\"")
+	}
+
+	if args["stdout"] == "true" {
+		if opts.Mode == goit.BackupIncremental {
+			return nil, errors.New("an incremental backup cannot be streamed")
+		}
+
+		util.Infoln("[backup] streaming a", opts.Format, "archive")
+		return nil, goit.BackupTo(w, opts.Format)
+	}
+
+	path, err := goit.RunBackup(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{"Wrote " + path}, nil
+}
+
+func cmdRestore(w io.Writer, args map[string]string) ([]string, error) {
+	opts := goit.RestoreOptions{DryRun: args["dry_run"] == "true", Conflict: goit.ConflictFromString(args["conflict"])}
+	if args["conflict"] == "" {
+		opts.Conflict = goit.ConflictFail
+	} else if opts.Conflict == -1 {
+		return nil, errors.New("invalid conflict option \"" + args["conflict"] + "\"")
+	}
+
+	util.Infoln("[restore] Starting from", args["archive"]+util.If(opts.DryRun, " (dry run)", ""))
+
+	msgs, err := goit.Restore(args["archive"], opts)
+	if opts.DryRun {
+		msgs = append([]string{"Dry run, nothing has been changed"}, msgs...)
+	}
+
+	if err == nil {
+		util.Infoln("[restore] Success")
+	}
+
+	return msgs, err
+}
+
+func cmdUserList(w io.Writer, args map[string]string) ([]string, error) {
+	users, err := goit.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	rows := [][]string{{"ID", "NAME", "FULL NAME", "ADMIN"}}
+	for _, u := range users {
+		rows = append(rows, []string{fmt.Sprint(u.Id), u.Name, u.FullName, util.If(u.IsAdmin, "yes", "no")})
+	}
+
+	return table(rows), nil
+}
+
+func cmdUserCreate(w io.Writer, args map[string]string) ([]string, error) {
+	name := strings.ToLower(args["name"])
+
+	if name == "" {
+		return nil, errors.New("username cannot be empty")
+	} else if slices.Contains(goit.Reserved, name) || !goit.IsLegal(name) {
+		return nil, errors.New("username \"" + name + "\" is illegal")
+	} else if exists, err := goit.UserExists(name); err != nil {
+		return nil, err
+	} else if exists {
+		return nil, errors.New("username \"" + name + "\" is taken")
+	} else if args["password"] == "" {
+		return nil, errors.New("password cannot be empty")
+	}
+
+	salt, err := goit.Salt()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := goit.CreateUser(goit.User{
+		Name: name, FullName: args["full_name"], Pass: goit.Hash(args["password"], salt), PassAlgo: "argon2",
+		Salt: salt, IsAdmin: args["admin"] == "true",
+	}); err != nil {
+		return nil, err
+	}
+
+	util.Infoln("[ipc] created user", name)
+	return []string{"Created user \"" + name + "\""}, nil
+}
+
+func cmdUserDelete(w io.Writer, args map[string]string) ([]string, error) {
+	u, err := getUser(args["name"])
+	if err != nil {
+		return nil, err
+	}
+
+	repos, err := goit.GetRepos()
+	if err != nil {
+		return nil, err
+	}
+
+	if n := len(slices.DeleteFunc(repos, func(r goit.Repo) bool { return r.OwnerId != u.Id })); n != 0 {
+		return nil, fmt.Errorf("user \"%s\" owns %d repositories, transfer or delete them first", u.Name, n)
+	}
+
+	if u.IsAdmin {
+		users, err := goit.GetUsers()
+		if err != nil {
+			return nil, err
+		}
+
+		if !slices.ContainsFunc(users, func(o goit.User) bool { return o.IsAdmin && o.Id != u.Id }) {
+			return nil, errors.New("user \"" + u.Name + "\" is the last administrator")
+		}
+	}
+
+	if err := goit.DelUser(u.Id); err != nil {
+		return nil, err
+	}
+
+	util.Infoln("[ipc] deleted user", u.Id, u.Name)
+	return []string{"Deleted user \"" + u.Name + "\""}, nil
+}
+
+func cmdUserPasswd(w io.Writer, args map[string]string) ([]string, error) {
+	u, err := getUser(args["name"])
+	if err != nil {
+		return nil, err
+	}
+
+	if args["password"] == "" {
+		return nil, errors.New("password cannot be empty")
+	}
+
+	if err := goit.UpdatePassword(u.Id, args["password"]); err != nil {
+		return nil, err
+	}
+
+	util.Infoln("[ipc] reset password of user", u.Id, u.Name)
+	return []string{"Reset the password of user \"" + u.Name + "\""}, nil
+}
+
+func cmdRepoList(w io.Writer, args map[string]string) ([]string, error) {
+	repos, err := goit.GetRepos()
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := goit.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	owners := map[int64]string{}
+	for _, u := range users {
+		owners[u.Id] = u.Name
+	}
+
+	rows := [][]string{{"ID", "NAME", "OWNER", "VISIBILITY", "UPSTREAM"}}
+	for _, r := range repos {
+		upstream := util.If(r.Upstream == "", "-", r.Upstream+util.If(r.IsMirror, " (mirror)", ""))
+		rows = append(rows, []string{fmt.Sprint(r.Id), r.Name, owners[r.OwnerId], r.Visibility.String(), upstream})
+	}
+
+	return table(rows), nil
+}
+
+func cmdRepoTransfer(w io.Writer, args map[string]string) ([]string, error) {
+	repo, err := getRepo(args["repo"])
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := getUser(args["owner"])
+	if err != nil {
+		return nil, err
+	}
+
+	if repo.OwnerId == u.Id {
+		return nil, errors.New("repository \"" + repo.Name + "\" is already owned by \"" + u.Name + "\"")
+	}
+
+	if err := goit.ChownRepo(repo.Id, u.Id); err != nil {
+		return nil, err
+	}
+
+	util.Infoln("[ipc] transferred repo", repo.Id, "ownership to", u.Id)
+	goit.TransferWebhooks(*repo, u.Id, nil)
+
+	return []string{"Transferred repository \"" + repo.Name + "\" to \"" + u.Name + "\""}, nil
+}
+
+func cmdRepoPull(w io.Writer, args map[string]string) ([]string, error) {
+	repo, err := getRepo(args["repo"])
+	if err != nil {
+		return nil, err
+	}
+
+	if repo.Upstream == "" {
+		return nil, errors.New("repository \"" + repo.Name + "\" has no upstream")
+	}
+
+	if err := goit.Pull(repo.Id); err != nil {
+		return nil, err
+	}
+
+	util.Infoln("[ipc] pulled repo", repo.Id, repo.Name)
+	return []string{"Pulled repository \"" + repo.Name + "\" from " + repo.Upstream}, nil
+}
+
+func cmdCronList(w io.Writer, args map[string]string) ([]string, error) {
+	rows := [][]string{{"ID", "TASK", "REPOSITORY", "SCHEDULE", "NEXT", "LAST", "RESULT"}}
+
+	for _, job := range goit.Cron.Jobs() {
+		repo := "-"
+		if job.Rid != -1 {
+			if r, err := goit.GetRepo(job.Rid); err != nil {
+				return nil, err
+			} else if r != nil {
+				repo = r.Name
+			}
+		}
+
+		result := "-"
+		if job.Done {
+			result = util.If(job.Err != nil, "failed", "succeeded") + " in " + job.Duration.Round(time.Millisecond).String()
+			if job.Err != nil {
+				result += ": " + job.Err.Error()
+			}
+		}
+
+		rows = append(rows, []string{
+			fmt.Sprint(job.Id), job.Name, repo, job.Schedule.String(), formatTime(job.Next), formatTime(job.Last), result,
+		})
+	}
+
+	return table(rows), nil
+}
+
+func cmdSessionList(w io.Writer, args map[string]string) ([]string, error) {
+	u, err := getUser(args["user"])
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := goit.GetSessions(u.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := [][]string{{"ID", "IP", "SEEN", "EXPIRES"}}
+	for _, s := range sessions {
+		rows = append(rows, []string{
+			fmt.Sprint(s.Id), util.If(s.Ip == "", "-", s.Ip), formatTime(s.Seen), formatTime(s.Expiry),
+		})
+	}
+
+	return table(rows), nil
+}
+
+/* Revoke a session of a user, or all of their sessions if no session ID is given. */
+func cmdSessionRevoke(w io.Writer, args map[string]string) ([]string, error) {
+	u, err := getUser(args["user"])
+	if err != nil {
+		return nil, err
+	}
+
+	if args["id"] == "" {
+		n, err := goit.EndSessions(u.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		util.Infoln("[ipc] revoked", n, "sessions of user", u.Id, u.Name)
+		return []string{fmt.Sprint("Revoked ", n, " sessions of user \"", u.Name, "\"")}, nil
+	}
+
+	sid, err := strconv.ParseInt(args["id"], 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid session ID \"" + args["id"] + "\"")
+	}
+
+	sessions, err := goit.GetSessions(u.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !slices.ContainsFunc(sessions, func(s goit.Session) bool { return s.Id == sid }) {
+		return nil, fmt.Errorf("user \"%s\" has no session %d", u.Name, sid)
+	}
+
+	goit.EndSessionById(u.Id, sid)
+
+	util.Infoln("[ipc] revoked session", sid, "of user", u.Id, u.Name)
+	return []string{fmt.Sprint("Revoked session ", sid, " of user \"", u.Name, "\"")}, nil
+}
+
+func getUser(name string) (*goit.User, error) {
+	if name == "" {
+		return nil, errors.New("username cannot be empty")
+	}
+
+	u, err := goit.GetUserByName(name)
+	if err != nil {
+		return nil, err
+	} else if u == nil {
+		return nil, errors.New("user \"" + name + "\" does not exist")
+	}
+
+	return u, nil
+}
+
+func getRepo(name string) (*goit.Repo, error) {
+	if name == "" {
+		return nil, errors.New("repository name cannot be empty")
+	}
+
+	r, err := goit.GetRepoByName(name)
+	if err != nil {
+		return nil, err
+	} else if r == nil {
+		return nil, errors.New("repository \"" + name + "\" does not exist")
+	}
+
+	return r, nil
+}
+
+/* Format rows of cells as lines of aligned columns. */
+func table(rows [][]string) []string {
+	b := &strings.Builder{}
+	tw := tabwriter.NewWriter(b, 0, 0, 2, ' ', 0)
+
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+
+	tw.Flush()
+	return strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+}
+
+func formatTime(t time.Time) string {
+	return util.If(t.IsZero(), "never", t.Format(time.DateTime))
+}
@@ -0,0 +1,21 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package ipc
+
+import "io"
+
+const (
+	FrameRequest = frameRequest
+	FrameData    = frameData
+	MaxFrame     = maxFrame
+)
+
+var (
+	WriteFrame = writeFrame
+	ReadFrame  = readFrame
+)
+
+func NewDataWriter(w io.Writer) io.Writer {
+	return dataWriter{w}
+}
@@ -0,0 +1,144 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+/*
+Package ipc implements the protocol used by the goit command to administer a running instance over a Unix socket.
+
+Each message is a frame of a type byte, a big-endian 32-bit length, and a payload. A client sends a request frame
+containing a JSON Request, and the server replies with any number of data frames, such as a streamed backup archive,
+followed by a response frame containing a JSON Response.
+*/
+package ipc
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+
+	"github.com/Jamozed/Goit/src/goit"
+)
+
+const (
+	frameRequest  byte = 'Q'
+	frameData     byte = 'D'
+	frameResponse byte = 'R'
+)
+
+const maxFrame = 1 << 24
+
+/* A request naming a command and its arguments. */
+type Request struct {
+	Command string            `json:"command"`
+	Args    map[string]string `json:"args,omitempty"`
+}
+
+/* A response to a request, with lines of output for the client to print. */
+type Response struct {
+	Ok     bool     `json:"ok"`
+	Output []string `json:"output,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+/* The path of the IPC socket of the instance using the loaded configuration. */
+func SocketPath() string {
+	return filepath.Join(goit.Conf.RuntimePath, "goit-"+goit.Conf.HttpPort+".sock")
+}
+
+/*
+Send a request to a running instance and return its response. Data sent before the response is written to w, and is
+an error if w is nil.
+*/
+func Send(req Request, w io.Writer) (Response, error) {
+	var res Response
+
+	c, err := net.Dial("unix", SocketPath())
+	if err != nil {
+		return res, err
+	}
+	defer c.Close()
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		return res, err
+	}
+
+	if err := writeFrame(c, frameRequest, b); err != nil {
+		return res, err
+	}
+
+	for {
+		typ, b, err := readFrame(c)
+		if errors.Is(err, io.EOF) {
+			return res, errors.New("connection closed without a response")
+		} else if err != nil {
+			return res, err
+		}
+
+		switch typ {
+		case frameData:
+			if w == nil {
+				return res, errors.New("unexpected data in response")
+			}
+
+			if _, err := w.Write(b); err != nil {
+				return res, err
+			}
+		case frameResponse:
+			return res, json.Unmarshal(b, &res)
+		default:
+			return res, fmt.Errorf("unexpected frame type 0x%02x", typ)
+		}
+	}
+}
+
+func writeFrame(w io.Writer, typ byte, b []byte) error {
+	head := make([]byte, 5)
+	head[0] = typ
+	binary.BigEndian.PutUint32(head[1:], uint32(len(b)))
+
+	if _, err := w.Write(head); err != nil {
+		return err
+	}
+
+	_, err := w.Write(b)
+	return err
+}
+
+func readFrame(r io.Reader) (byte, []byte, error) {
+	head := make([]byte, 5)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return 0, nil, err
+	}
+
+	n := binary.BigEndian.Uint32(head[1:])
+	if n > maxFrame {
+		return 0, nil, fmt.Errorf("frame of %d bytes exceeds %d bytes", n, maxFrame)
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, nil, err
+	}
+
+	return head[0], b, nil
+}
+
+/* A writer that sends each write as data frames. */
+type dataWriter struct{ w io.Writer }
+
+func (dw dataWriter) Write(p []byte) (int, error) {
+	for n := 0; n < len(p); {
+		m := min(len(p)-n, maxFrame)
+		if err := writeFrame(dw.w, frameData, p[n:n+m]); err != nil {
+			return n, err
+		}
+
+		n += m
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,102 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package ipc_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/Jamozed/Goit/src/ipc"
+)
+
+func TestFrames(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	frames := []struct {
+		typ byte
+		b   []byte
+	}{
+		{ipc.FrameRequest, []byte(`{"command":"backup"}`)},
+		{ipc.FrameData, []byte{}},
+		{ipc.FrameData, bytes.Repeat([]byte{0xff}, 1000)},
+	}
+
+	for _, f := range frames {
+		if err := ipc.WriteFrame(buf, f.typ, f.b); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	for _, f := range frames {
+		typ, b, err := ipc.ReadFrame(buf)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if typ != f.typ || !bytes.Equal(b, f.b) {
+			t.Error("Expected frame", f.typ, len(f.b), "got", typ, len(b))
+		}
+	}
+
+	if _, _, err := ipc.ReadFrame(buf); !errors.Is(err, io.EOF) {
+		t.Error("Expected EOF got", err)
+	}
+}
+
+func TestReadFrameInvalid(t *testing.T) {
+	head := func(n uint32) []byte {
+		b := []byte{ipc.FrameData, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], n)
+		return b
+	}
+
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"short header", []byte{ipc.FrameData, 0, 0}},
+		{"short payload", append(head(10), "short"...)},
+		{"too large", head(ipc.MaxFrame + 1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := ipc.ReadFrame(bytes.NewReader(tt.b)); err == nil || errors.Is(err, io.EOF) {
+				t.Error("Expected an error other than EOF got", err)
+			}
+		})
+	}
+}
+
+func TestDataWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	data := bytes.Repeat([]byte("goit"), ipc.MaxFrame/4+1)
+
+	if n, err := ipc.NewDataWriter(buf).Write(data); err != nil {
+		t.Fatal(err.Error())
+	} else if n != len(data) {
+		t.Error("Expected", len(data), "bytes written got", n)
+	}
+
+	var got []byte
+	for i := 0; buf.Len() != 0; i += 1 {
+		typ, b, err := ipc.ReadFrame(buf)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if typ != ipc.FrameData || len(b) > ipc.MaxFrame {
+			t.Error("Frame", i, "has type", typ, "and", len(b), "bytes")
+		}
+
+		got = append(got, b...)
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Error("Expected the data to be split into frames unchanged")
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package ipc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Jamozed/Goit/src/util"
+)
+
+/* A command, given the arguments of a request and a writer for data to send before the response. */
+type command func(w io.Writer, args map[string]string) ([]string, error)
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"backup":         cmdBackup,
+		"restore":        cmdRestore,
+		"user.list":      cmdUserList,
+		"user.create":    cmdUserCreate,
+		"user.delete":    cmdUserDelete,
+		"user.passwd":    cmdUserPasswd,
+		"repo.list":      cmdRepoList,
+		"repo.transfer":  cmdRepoTransfer,
+		"repo.pull":      cmdRepoPull,
+		"cron.list":      cmdCronList,
+		"session.list":   cmdSessionList,
+		"session.revoke": cmdSessionRevoke,
+	}
+}
+
+/* Listen on the IPC socket, which only the user running Goit may connect to. */
+func Listen() (net.Listener, error) {
+	l, err := net.Listen("unix", SocketPath())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(SocketPath(), 0o600); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+/*
+Serve IPC requests until the listener is closed, handling each connection concurrently, then wait for the requests being
+handled to finish.
+*/
+func Serve(l net.Listener) {
+	wait := &sync.WaitGroup{}
+	defer wait.Wait()
+
+	for {
+		c, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			util.Errorln("[ipc]", err.Error())
+			continue
+		}
+
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			handle(c)
+		}()
+	}
+}
+
+func handle(c net.Conn) {
+	defer c.Close()
+
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	typ, b, err := readFrame(c)
+	if err != nil {
+		util.Errorln("[ipc]", err.Error())
+		return
+	}
+
+	c.SetReadDeadline(time.Time{})
+
+	var req Request
+	if typ != frameRequest {
+		respond(c, req, nil, fmt.Errorf("unexpected frame type 0x%02x", typ))
+		return
+	} else if err := json.Unmarshal(b, &req); err != nil {
+		respond(c, req, nil, err)
+		return
+	}
+
+	cmd, ok := commands[req.Command]
+	if !ok {
+		respond(c, req, nil, errors.New("unknown command \""+req.Command+"\""))
+		return
+	}
+
+	bw := bufio.NewWriterSize(dataWriter{c}, 1<<16)
+
+	out, err := cmd(bw, req.Args)
+	if ferr := bw.Flush(); err == nil {
+		err = ferr
+	}
+
+	respond(c, req, out, err)
+}
+
+func respond(c net.Conn, req Request, out []string, err error) {
+	res := Response{Ok: err == nil, Output: out}
+	if err != nil {
+		res.Error = err.Error()
+		util.Errorln("[ipc]", req.Command+":", err.Error())
+	}
+
+	b, err := json.Marshal(res)
+	if err != nil {
+		util.Errorln("[ipc]", err.Error())
+		return
+	}
+
+	if err := writeFrame(c, frameResponse, b); err != nil {
+		util.Errorln("[ipc]", err.Error())
+	}
+}
@@ -4,23 +4,31 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
 	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"io"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/Jamozed/Goit/res"
 	"github.com/Jamozed/Goit/src/admin"
+	"github.com/Jamozed/Goit/src/api"
 	"github.com/Jamozed/Goit/src/goit"
+	"github.com/Jamozed/Goit/src/ipc"
+	"github.com/Jamozed/Goit/src/metrics"
 	"github.com/Jamozed/Goit/src/repo"
 	"github.com/Jamozed/Goit/src/user"
 	"github.com/Jamozed/Goit/src/util"
@@ -32,52 +40,73 @@ import (
 var protect func(http.Handler) http.Handler
 
 func main() {
-	var backup bool
+	var backup, full, incremental, stdout, dryRun bool
+	var hook, format, restore, conflict string
 
 	flag.BoolVar(&backup, "backup", false, "Perform a backup")
+	flag.BoolVar(&full, "full", false, "Perform a full backup, regardless of the config")
+	flag.BoolVar(&incremental, "incremental", false, "Perform an incremental backup, regardless of the config")
+	flag.StringVar(&format, "format", "", "Backup archive format: zip, zip-deflate, tar.gz, or tar.zst")
+	flag.BoolVar(&stdout, "stdout", false, "Write a full backup archive to standard output instead of storing it")
+	flag.StringVar(&restore, "restore", "", "Restore users and repositories from a backup archive")
+	flag.BoolVar(&dryRun, "dry-run", false, "Check a restore without changing anything")
+	flag.StringVar(&conflict, "conflict", "fail", "Restore conflict handling: fail, skip, or overwrite")
 	flag.BoolVar(&util.Debug, "debug", false, "Enable debug logging")
+	flag.StringVar(&hook, "hook", "", "Run a Git hook, used by the scripts that Goit installs")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: goit [<flags>] [<command>]")
+		flag.PrintDefaults()
+		ipc.Usage(flag.CommandLine.Output())
+	}
+
 	flag.Parse()
 
+	if hook != "" /* Git hook */ {
+		os.Exit(goit.RunHook(hook))
+	}
+
+	if flag.NArg() != 0 /* IPC client */ {
+		os.Exit(ipc.Main(flag.Args()))
+	}
+
 	if backup /* IPC client */ {
-		c, err := net.Dial("unix", filepath.Join(goit.Conf.RuntimePath, "goit-"+goit.Conf.HttpPort+".sock"))
-		if err != nil {
-			log.Fatalln(err.Error())
+		if stdout && incremental {
+			util.Fatalln("[backup] an incremental backup cannot be written to standard output")
 		}
 
-		_, err = c.Write([]byte{0xBA})
-		if err != nil {
-			log.Fatalln(err.Error())
-		}
+		os.Exit(ipc.Run(ipc.Request{Command: "backup", Args: map[string]string{
+			"mode":   util.If(full, "full", util.If(incremental, "incremental", "auto")),
+			"format": format,
+			"stdout": util.If(stdout, "true", "false"),
+		}}, util.If[io.Writer](stdout, os.Stdout, nil)))
+	}
 
-		buf := make([]byte, 512)
-		n, err := c.Read(buf)
+	if restore != "" /* IPC client */ {
+		archive, err := filepath.Abs(restore)
 		if err != nil {
-			log.Fatalln(err.Error())
+			util.Fatalln("[restore]", err.Error())
 		}
 
-		fmt.Println(string(buf[1:n]))
-		c.Close()
-
-		os.Exit(util.If(buf[0] == 0x01, -1, 0))
+		os.Exit(ipc.Run(ipc.Request{Command: "restore", Args: map[string]string{
+			"archive":  archive,
+			"dry_run":  util.If(dryRun, "true", "false"),
+			"conflict": conflict,
+		}}, nil))
 	}
 
-	/* Listen for and handle SIGINT */
+	/* Listen for SIGINT and SIGTERM, which are handled once Goit has started */
 	stop := make(chan struct{})
 	wait := &sync.WaitGroup{}
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
 
-	go func() {
-		<-c
-		close(stop)
-		goit.Cron.Stop()
-		wait.Wait()
-		os.Exit(0)
-	}()
+	/* Listen for SIGHUP, which reloads the TLS certificate */
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 
 	/* Initialise Goit */
 	if err := goit.Goit(); err != nil {
-		log.Fatalln(err.Error())
+		util.Fatalln(err.Error())
 	}
 
 	h := chi.NewRouter()
@@ -86,13 +115,22 @@ func main() {
 	h.Use(logHttp)
 
 	h.Use(func(h http.Handler) http.Handler {
-		return http.TimeoutHandler(h, 90*time.Second,
+		th := http.TimeoutHandler(h, 90*time.Second,
 			`<!DOCTYPE html><html lang="en"><head>
 		<meta charset="UTF-8"><title>503 Service Unavailable</title>
 		<meta name="viewport" content="width=device-width, initial-scale=1.0">
 		<link rel="stylesheet" type="text/css" href="/static/style.css">
 		<link rel="icon" type="image/png" href="/static/favicon.png">
 		</head><body><b>503 Service Unavailable</b></body></html>`)
+
+		/* Transfers are streamed and may take longer than the timeout, so they are not wrapped in it */
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isTransfer(r.URL.Path) {
+				h.ServeHTTP(w, r)
+			} else {
+				th.ServeHTTP(w, r)
+			}
+		})
 	})
 
 	protect = csrf.Protect(
@@ -112,6 +150,13 @@ func main() {
 		r.Post("/user/sessions", user.HandleSessions)
 		r.Get("/user/edit", user.HandleEdit)
 		r.Post("/user/edit", user.HandleEdit)
+		r.Get("/user/keys", user.HandleKeys)
+		r.Post("/user/keys", user.HandleKeys)
+		r.Get("/user/tokens", user.HandleTokens)
+		r.Post("/user/tokens", user.HandleTokens)
+		r.Get("/user/webhooks", user.HandleWebhooks)
+		r.Post("/user/webhooks", user.HandleWebhooks)
+		r.Get("/user/{name}/activity.atom", repo.HandleUserAtom)
 		r.Get("/repo/create", repo.HandleCreate)
 		r.Post("/repo/create", repo.HandleCreate)
 		r.Get("/admin", admin.HandleStatus)
@@ -131,6 +176,12 @@ func main() {
 		r.Get("/favicon.ico", goit.HttpNotFound)
 	})
 
+	h.Mount("/api/v1", api.Router())
+
+	if goit.Conf.MetricsAddr == "" && goit.Conf.MetricsToken != "" {
+		h.Get("/metrics", handleMetrics)
+	}
+
 	/* TODO figure out how to use a subrouter after manually parsing the repo path */
 	h.HandleFunc("/*", HandleRepo)
 
@@ -155,43 +206,244 @@ func main() {
 	// h.Post("/{repo}/git-receive-pack", goit.HandleReceivePack)
 
 	/* Listen for IPC */
-	ipc, err := net.Listen("unix", filepath.Join(goit.Conf.RuntimePath, "goit-"+goit.Conf.HttpPort+".sock"))
+	sock, err := ipc.Listen()
 	if err != nil {
-		log.Fatalln("[sock]", err.Error())
+		util.Fatalln("[sock]", err.Error())
 	}
 
 	go func() {
-		defer ipc.Close()
+		defer sock.Close()
 		<-stop
 	}()
 
 	wait.Add(1)
-	go handleIpc(stop, wait, ipc)
+	go func() {
+		defer wait.Done()
+		ipc.Serve(sock)
+	}()
+
+	/* Listen for SSH on the specified port */
+	if goit.Conf.SshPort != "" {
+		conf, err := goit.SshConfig()
+		if err != nil {
+			util.Fatalln("[ssh]", err.Error())
+		}
+
+		sl, err := net.Listen("tcp", goit.Conf.SshAddr+":"+goit.Conf.SshPort)
+		if err != nil {
+			util.Fatalln("[ssh]", err.Error())
+		}
+
+		go func() {
+			defer sl.Close()
+			<-stop
+		}()
+
+		go goit.ServeSsh(sl, conf)
+	}
+
+	/* Listen for metrics on the specified address */
+	if goit.Conf.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", handleMetrics)
+		ms := &http.Server{Addr: goit.Conf.MetricsAddr, Handler: mux}
+
+		go func() {
+			defer ms.Close()
+			<-stop
+		}()
+
+		go func() {
+			if err := ms.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				util.Fatalln("[metrics]", err.Error())
+			}
+		}()
+	}
+
+	/* Listen for HTTP, or HTTPS if a certificate is configured, on the specified port */
+	srv := &http.Server{Addr: goit.Conf.HttpAddr + ":" + goit.Conf.HttpPort, Handler: h}
+
+	if goit.TlsEnabled() {
+		srv.TLSConfig = goit.TlsConfig()
+	}
+
+	go func() {
+		var err error
+		if goit.TlsEnabled() {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+
+		if !errors.Is(err, http.ErrServerClosed) {
+			util.Fatalln("[http]", err.Error())
+		}
+	}()
+
+	go func() {
+		for range hup {
+			if !goit.TlsEnabled() {
+				continue
+			}
+
+			if err := goit.ReloadCertificate(true); err != nil {
+				util.Errorln("[tls]", err.Error())
+			}
+		}
+	}()
+
+	/* Redirect plain HTTP to HTTPS on the specified port */
+	if goit.Conf.HttpRedirectPort != "" {
+		rs := &http.Server{
+			Addr: goit.Conf.HttpAddr + ":" + goit.Conf.HttpRedirectPort, Handler: http.HandlerFunc(redirectHttps),
+		}
+
+		go func() {
+			defer rs.Close()
+			<-stop
+		}()
+
+		go func() {
+			if err := rs.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				util.Fatalln("[http]", err.Error())
+			}
+		}()
+	}
+
+	util.Infoln("[shutdown] received", (<-sig).String())
+
+	/* Exit immediately on a second signal */
+	go func() {
+		util.Infoln("[shutdown] received", (<-sig).String()+", exiting immediately")
+		os.Exit(1)
+	}()
+
+	if !shutdown(srv, stop, wait) {
+		os.Exit(1)
+	}
+}
 
-	/* Listen for HTTP on the specified port */
-	if err := http.ListenAndServe(goit.Conf.HttpAddr+":"+goit.Conf.HttpPort, h); err != nil {
-		log.Fatalln("[http]", err.Error())
+/*
+Shut down gracefully, closing listeners and then waiting for HTTP requests, IPC requests, Git commands, and cron jobs to
+finish, up to the configured timeout. Reports whether everything finished in time.
+*/
+func shutdown(srv *http.Server, stop chan struct{}, wait *sync.WaitGroup) bool {
+	ctx := context.Background()
+	if goit.Conf.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(goit.Conf.ShutdownTimeout)*time.Second)
+		defer cancel()
 	}
+
+	ok := true
+	close(stop)
+
+	if err := srv.Shutdown(ctx); err != nil {
+		util.Errorln("[shutdown] HTTP requests:", err.Error())
+		ok = false
+	}
+
+	if err := goit.Cron.Shutdown(ctx); err != nil {
+		util.Errorln("[shutdown] cron jobs:", err.Error())
+		ok = false
+	}
+
+	if err := goit.WaitGitCommands(ctx); err != nil {
+		util.Errorln("[shutdown]", err.Error())
+		ok = false
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wait.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		util.Errorln("[shutdown] IPC requests:", ctx.Err().Error())
+		ok = false
+	}
+
+	if err := goit.WaitWebhooks(ctx); err != nil {
+		util.Errorln("[shutdown]", err.Error())
+		ok = false
+	}
+
+	util.Infoln("[shutdown]", util.If(ok, "complete", "timed out"))
+	return ok
 }
 
+var (
+	httpRequests = metrics.NewCounter("goit_http_requests_total", "HTTP requests by method and status.", "method", "code")
+	httpDuration = metrics.NewHistogram(
+		"goit_http_request_duration_seconds", "Duration of HTTP requests by method.", metrics.DefBuckets, "method",
+	)
+
+	/* Other methods are counted together, so that clients cannot create arbitrary metrics */
+	httpMethods = []string{
+		http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+		http.MethodOptions,
+	}
+)
+
 func logHttp(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		t1 := time.Now()
-		next.ServeHTTP(w, r)
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+		duration := time.Since(t1)
+
+		status := util.If(ww.Status() == 0, http.StatusOK, ww.Status())
+		method := util.If(slices.Contains(httpMethods, r.Method), r.Method, "OTHER")
+		httpRequests.Inc(method, strconv.Itoa(status))
+		httpDuration.Observe(duration.Seconds(), method)
 
 		ip := r.RemoteAddr
 		if fip := r.Header.Get("X-Forwarded-For"); goit.Conf.IpForwarded && fip != "" {
 			ip = fip
 		}
 
-		log.Println("[http]", r.Method, r.URL.String(), "from", ip, "in", time.Since(t1))
+		goit.LogAccess(r, ip, t1, status, ww.BytesWritten())
+		util.Infoln("[http]", r.Method, r.URL.String(), "from", ip, "in", duration)
 	})
 }
 
+/* Redirect a request to the same URL over HTTPS, on the HTTP port. */
+func redirectHttps(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = strings.Trim(r.Host, "[]")
+	}
+
+	if goit.Conf.HttpPort != "443" {
+		host = net.JoinHostPort(host, goit.Conf.HttpPort)
+	} else if strings.Contains(host, ":") /* IPv6 */ {
+		host = "[" + host + "]"
+	}
+
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+}
+
+/* Serve metrics, requiring the metrics token as a bearer token if one is set. */
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if goit.Conf.MetricsToken != "" {
+		auth := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(auth, []byte("Bearer "+goit.Conf.MetricsToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer realm=\"metrics\"")
+			goit.HttpError(w, http.StatusUnauthorized)
+			return
+		}
+	}
+
+	metrics.Handler(w, r)
+}
+
 func handleStyle(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/css")
 	if _, err := w.Write([]byte(res.Style)); err != nil {
-		log.Println("[style]", err.Error())
+		util.Errorln("[style]", err.Error())
 	}
 }
 
@@ -201,52 +453,19 @@ func handleFavicon(w http.ResponseWriter, r *http.Request) {
 	} else {
 		w.Header().Set("Content-Type", "image/png")
 		if _, err := w.Write(goit.Favicon); err != nil {
-			log.Println("[favicon]", err.Error())
+			util.Errorln("[favicon]", err.Error())
 		}
 	}
 }
 
-/* Handle IPC messages. */
-func handleIpc(stop chan struct{}, wait *sync.WaitGroup, ipc net.Listener) {
-	defer wait.Done()
-
-	for {
-		select {
-		case <-stop:
-			return
-		default:
-			c, err := ipc.Accept()
-			if err != nil {
-				if !errors.Is(err, net.ErrClosed) {
-					log.Println("[ipc]", err.Error())
-				}
-				continue
-			}
-
-			c.SetReadDeadline(time.Now().Add(1 * time.Second))
-
-			buf := make([]byte, 1)
-			if _, err := c.Read(buf); err != nil {
-				log.Println("[ipc]", err.Error())
-				continue
-			}
-
-			if buf[0] == 0xBA {
-				log.Println("[backup] Starting")
-				if err := goit.Backup(); err != nil {
-					c.Write(append([]byte{0x01}, []byte(err.Error())...))
-					log.Println("[backup]", err.Error())
-				} else {
-					c.Write(append([]byte{0x00}, []byte("SUCCESS")...))
-					log.Println("[backup] Success")
-				}
-			} else {
-				c.Write(append([]byte{0x01}, []byte("ILLEGAL")...))
-			}
-
-			c.Close()
-		}
-	}
+/*
+Report whether a request path is a Git smart HTTP or Git LFS transfer of a repository, or a raw file or download, which
+are streamed rather than buffered.
+*/
+func isTransfer(p string) bool {
+	return strings.HasSuffix(p, "/info/refs") || strings.HasSuffix(p, "/git-upload-pack") ||
+		strings.HasSuffix(p, "/git-receive-pack") || strings.Contains(p, "/info/lfs/objects/") ||
+		strings.Contains(p, "/raw/") || strings.Contains(p, "/download/") || strings.HasSuffix(p, "/download")
 }
 
 func HandleRepo(w http.ResponseWriter, r *http.Request) {
@@ -277,7 +496,7 @@ found:
 
 	rctx := chi.RouteContext(r.Context())
 	if rctx == nil {
-		log.Println("[route] NULL route context")
+		util.Errorln("[route] NULL route context")
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
@@ -288,6 +507,11 @@ found:
 	switch r.Method {
 	case http.MethodGet:
 		switch {
+		case spath == "/log.atom":
+			repo.HandleLogAtom(w, r)
+		case spath == "/refs.atom":
+			repo.HandleRefsAtom(w, r)
+
 		case strings.HasPrefix(spath, "/log"), len(spath) == 0:
 			rctx.URLParams.Add("*", strings.TrimLeft(strings.TrimPrefix(spath, "/log"), "/"))
 			protect(http.HandlerFunc(repo.HandleLog)).ServeHTTP(w, r)
@@ -309,6 +533,10 @@ found:
 			rctx.URLParams.Add("*", strings.TrimPrefix(spath, "/file/"))
 			protect(http.HandlerFunc(repo.HandleFile)).ServeHTTP(w, r)
 
+		case strings.HasPrefix(spath, "/blame/"):
+			rctx.URLParams.Add("*", strings.TrimPrefix(spath, "/blame/"))
+			protect(http.HandlerFunc(repo.HandleBlame)).ServeHTTP(w, r)
+
 		case strings.HasPrefix(spath, "/raw/"):
 			rctx.URLParams.Add("*", strings.TrimPrefix(spath, "/raw/"))
 			protect(http.HandlerFunc(repo.HandleRaw)).ServeHTTP(w, r)
@@ -317,6 +545,10 @@ found:
 			rctx.URLParams.Add("*", strings.TrimLeft(strings.TrimPrefix(spath, "/download"), "/"))
 			protect(http.HandlerFunc(repo.HandleDownload)).ServeHTTP(w, r)
 
+		case spath == "/compare", strings.HasPrefix(spath, "/compare/"):
+			rctx.URLParams.Add("*", strings.TrimLeft(strings.TrimPrefix(spath, "/compare"), "/"))
+			protect(http.HandlerFunc(repo.HandleCompare)).ServeHTTP(w, r)
+
 		case spath == "/refs":
 			protect(http.HandlerFunc(repo.HandleRefs)).ServeHTTP(w, r)
 		case spath == "/edit":
@@ -329,6 +561,10 @@ found:
 		case spath == "/git-receive-pack":
 			goit.HandleReceivePack(w, r)
 
+		case strings.HasPrefix(spath, "/info/lfs/objects/"):
+			rctx.URLParams.Add("oid", strings.TrimPrefix(spath, "/info/lfs/objects/"))
+			goit.HandleLfsDownload(w, r)
+
 		default:
 			goit.HttpError(w, http.StatusNotFound)
 		}
@@ -342,6 +578,24 @@ found:
 			goit.HandleUploadPack(w, r)
 		case spath == "/git-receive-pack":
 			goit.HandleReceivePack(w, r)
+
+		case spath == "/info/lfs/objects/batch":
+			goit.HandleLfsBatch(w, r)
+		case spath == "/info/lfs/objects/verify":
+			goit.HandleLfsVerify(w, r)
+
+		default:
+			goit.HttpError(w, http.StatusNotFound)
+		}
+
+	case http.MethodPut:
+		switch {
+		case strings.HasPrefix(spath, "/info/lfs/objects/"):
+			rctx.URLParams.Add("oid", strings.TrimPrefix(spath, "/info/lfs/objects/"))
+			goit.HandleLfsUpload(w, r)
+
+		default:
+			goit.HttpError(w, http.StatusNotFound)
 		}
 
 	default:
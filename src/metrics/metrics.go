@@ -0,0 +1,239 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+/*
+Package metrics implements counters, histograms, and gauges, and writes them in the Prometheus text exposition format.
+*/
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Jamozed/Goit/src/util"
+)
+
+/* Default histogram buckets, in seconds, suited to the latency of HTTP requests. */
+var DefBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type family struct {
+	name, help, typ string
+	write           func(w io.Writer)
+}
+
+var (
+	families []*family
+	mutex    sync.Mutex
+)
+
+func register(f *family) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if slices.ContainsFunc(families, func(o *family) bool { return o.name == f.name }) {
+		panic("metrics: " + f.name + " registered twice")
+	}
+
+	families = append(families, f)
+}
+
+/* A counter of events, partitioned by a set of labels. */
+type Counter struct {
+	labels []string
+	values map[string]*counterValue
+	mutex  sync.Mutex
+}
+
+type counterValue struct {
+	labels []string
+	value  float64
+}
+
+/* Register a counter partitioned by labels, whose values are given in the same order when it is incremented. */
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{labels: labels, values: map[string]*counterValue{}}
+	register(&family{name: name, help: help, typ: "counter", write: func(w io.Writer) {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		for _, k := range sortedKeys(c.values) {
+			v := c.values[k]
+			fmt.Fprintln(w, name+formatLabels(c.labels, v.labels), formatValue(v.value))
+		}
+	}})
+
+	return c
+}
+
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *Counter) Add(n float64, values ...string) {
+	if len(values) != len(c.labels) {
+		panic("metrics: wrong number of label values")
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	k := strings.Join(values, "\xff")
+	if v, ok := c.values[k]; ok {
+		v.value += n
+	} else {
+		c.values[k] = &counterValue{slices.Clone(values), n}
+	}
+}
+
+/* A histogram of observations, such as durations, partitioned by a set of labels. */
+type Histogram struct {
+	labels  []string
+	buckets []float64
+	values  map[string]*histogramValue
+	mutex   sync.Mutex
+}
+
+type histogramValue struct {
+	labels []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+/* Register a histogram with ascending upper bounds of buckets, partitioned by labels. */
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{labels: labels, buckets: buckets, values: map[string]*histogramValue{}}
+	register(&family{name: name, help: help, typ: "histogram", write: func(w io.Writer) {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+
+		for _, k := range sortedKeys(h.values) {
+			v := h.values[k]
+			bucket := func(le string, n uint64) {
+				labels := formatLabels(append(slices.Clip(h.labels), "le"), append(slices.Clip(v.labels), le))
+				fmt.Fprintln(w, name+"_bucket"+labels, n)
+			}
+
+			for i, le := range h.buckets {
+				bucket(formatValue(le), v.counts[i])
+			}
+
+			bucket("+Inf", v.count)
+			fmt.Fprintln(w, name+"_sum"+formatLabels(h.labels, v.labels), formatValue(v.sum))
+			fmt.Fprintln(w, name+"_count"+formatLabels(h.labels, v.labels), v.count)
+		}
+	}})
+
+	return h
+}
+
+func (h *Histogram) Observe(x float64, values ...string) {
+	if len(values) != len(h.labels) {
+		panic("metrics: wrong number of label values")
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	k := strings.Join(values, "\xff")
+	v, ok := h.values[k]
+	if !ok {
+		v = &histogramValue{labels: slices.Clone(values), counts: make([]uint64, len(h.buckets))}
+		h.values[k] = v
+	}
+
+	for i, le := range h.buckets {
+		if x <= le {
+			v.counts[i] += 1
+		}
+	}
+
+	v.count += 1
+	v.sum += x
+}
+
+/* Register a gauge whose value is read from a function when metrics are written. */
+func NewGaugeFunc(name, help string, fn func() float64) {
+	register(&family{name: name, help: help, typ: "gauge", write: func(w io.Writer) {
+		fmt.Fprintln(w, name, formatValue(fn()))
+	}})
+}
+
+/* Register a gauge partitioned by a label, whose values are read from a function when metrics are written. */
+func NewGaugeVecFunc(name, help, label string, fn func() map[string]float64) {
+	register(&family{name: name, help: help, typ: "gauge", write: func(w io.Writer) {
+		values := fn()
+		for _, k := range sortedKeys(values) {
+			fmt.Fprintln(w, name+formatLabels([]string{label}, []string{k}), formatValue(values[k]))
+		}
+	}})
+}
+
+/* Write all registered metrics in the Prometheus text exposition format. */
+func Write(w io.Writer) error {
+	mutex.Lock()
+	fams := slices.Clone(families)
+	mutex.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, f := range fams {
+		fmt.Fprintln(bw, "# HELP", f.name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(f.help))
+		fmt.Fprintln(bw, "# TYPE", f.name, f.typ)
+		f.write(bw)
+	}
+
+	return bw.Flush()
+}
+
+/* Serve all registered metrics in the Prometheus text exposition format. */
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := Write(w); err != nil {
+		util.Errorln("[metrics]", err.Error())
+	}
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+	var parts []string
+	for i, name := range names {
+		parts = append(parts, name+`="`+escape.Replace(values[i])+`"`)
+	}
+
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	slices.Sort(keys)
+	return keys
+}
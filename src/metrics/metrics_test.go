@@ -0,0 +1,50 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package metrics_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Jamozed/Goit/src/metrics"
+)
+
+func TestWrite(t *testing.T) {
+	c := metrics.NewCounter("test_events_total", "Events.", "kind")
+	c.Inc("b")
+	c.Add(2, "a\"\n")
+	c.Inc("b")
+
+	h := metrics.NewHistogram("test_duration_seconds", "Durations.", []float64{0.1, 1}, "kind")
+	h.Observe(0.05, "a")
+	h.Observe(0.5, "a")
+	h.Observe(5, "a")
+
+	metrics.NewGaugeFunc("test_value", "A value.", func() float64 { return 1.5 })
+
+	expected := `# HELP test_events_total Events.
+# TYPE test_events_total counter
+test_events_total{kind="a\"\n"} 2
+test_events_total{kind="b"} 2
+# HELP test_duration_seconds Durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{kind="a",le="0.1"} 1
+test_duration_seconds_bucket{kind="a",le="1"} 2
+test_duration_seconds_bucket{kind="a",le="+Inf"} 3
+test_duration_seconds_sum{kind="a"} 5.55
+test_duration_seconds_count{kind="a"} 3
+# HELP test_value A value.
+# TYPE test_value gauge
+test_value 1.5
+`
+
+	b := &strings.Builder{}
+	if err := metrics.Write(b); err != nil {
+		t.Fatal(err)
+	}
+
+	if b.String() != expected {
+		t.Error("Expected", expected, "got", b.String())
+	}
+}
@@ -0,0 +1,255 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jamozed/Goit/src/goit"
+	"github.com/Jamozed/Goit/src/util"
+	"github.com/dustin/go-humanize"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+/* Files larger than this are not blamed, and blaming is stopped if it takes longer than the timeout. */
+const (
+	blameMax     = 10 * 1024 * 1024
+	blameTimeout = 30 * time.Second
+)
+
+type blameLine struct {
+	Hash, Author string
+	Date         time.Time
+}
+
+func HandleBlame(w http.ResponseWriter, r *http.Request) {
+	auth, user, err := goit.Auth(w, r, true)
+	if err != nil {
+		util.Errorln("[/repo/blame]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	tpath := chi.URLParam(r, "*")
+	rev := r.URL.Query().Get("ref")
+
+	repo, err := goit.GetRepoByName(chi.URLParam(r, "repo"))
+	if err != nil {
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	} else if repo == nil || !goit.IsVisible(repo, auth, user) {
+		goit.HttpError(w, http.StatusNotFound)
+		return
+	}
+
+	type row struct{ Hash, Short, Author, Date string }
+	data := struct {
+		HeaderFields
+		Title, Path, LineC, Size, Mode string
+		Lines                          []string
+		Blame                          []row
+		HtmlBody, HtmlPath, BodyCss    template.HTML
+		TooLarge                       bool
+	}{
+		Title:        repo.Name + " - Blame - " + tpath,
+		HeaderFields: GetHeaderFields(auth, user, repo, r.Host),
+	}
+
+	gr, err := git.PlainOpen(goit.RepoPath(repo.Name, true))
+	if err != nil {
+		util.Errorln("[/repo/blame]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	if err := data.setRefs(gr, rev); err != nil {
+		util.Errorln("[/repo/blame]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	ref, err := goit.ResolveRef(gr, rev)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		goit.HttpError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		util.Errorln("[/repo/blame]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	if readme, _ := findPattern(gr, ref, readmePattern); readme != "" {
+		data.Readme = path.Join("/", repo.Name, "file", readme) + refQuery(rev)
+	}
+	if licence, _ := findPattern(gr, ref, licencePattern); licence != "" {
+		data.Licence = path.Join("/", repo.Name, "file", licence) + refQuery(rev)
+	}
+
+	commit, err := gr.CommitObject(ref.Hash())
+	if err != nil {
+		util.Errorln("[/repo/blame]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	file, err := commit.File(tpath)
+	if errors.Is(err, object.ErrFileNotFound) {
+		goit.HttpError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		util.Errorln("[/repo/blame]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	data.Mode = util.ModeString(uint32(file.Mode))
+	data.Path = file.Name
+	data.Size = humanize.IBytes(uint64(file.Size))
+
+	parts := strings.Split(file.Name, "/")
+	htmlPath := "<b style=\"padding-left: 0.4rem;\"><a href=\"/" + html.EscapeString(repo.Name) + "/tree" +
+		html.EscapeString(refQuery(rev)) + "\">" + html.EscapeString(repo.Name) + "</a></b>/"
+	dirPath := ""
+
+	for i := 0; i < len(parts)-1; i += 1 {
+		dirPath = path.Join(dirPath, parts[i])
+		htmlPath += "<a href=\"/" + html.EscapeString(repo.Name+"/tree/"+dirPath+refQuery(rev)) + "\">" +
+			html.EscapeString(parts[i]) + "</a>/"
+	}
+	htmlPath += html.EscapeString(parts[len(parts)-1])
+
+	data.HtmlPath = template.HTML(htmlPath)
+
+	if file.Size > blameMax {
+		data.TooLarge = true
+	} else if rc, err := file.Blob.Reader(); err != nil {
+		util.Errorln("[/repo/blame]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	} else {
+		buf, err := io.ReadAll(rc)
+		rc.Close()
+
+		if err != nil {
+			util.Errorln("[/repo/blame]", err.Error())
+			goit.HttpError(w, http.StatusInternalServerError)
+			return
+		}
+
+		if strings.HasPrefix(http.DetectContentType(buf[:min(len(buf), 512)]), "text") {
+			ctx, cancel := context.WithTimeout(r.Context(), blameTimeout)
+			lines, err := blame(ctx, repo.Name, commit.Hash, file.Name)
+			cancel()
+
+			if err != nil {
+				util.Errorln("[/repo/blame]", err.Error())
+				goit.HttpError(w, http.StatusInternalServerError)
+				return
+			}
+
+			body := string(buf)
+			html, css, err := Highlight(file.Name, body)
+			if err != nil {
+				util.Errorln("[/repo/blame]", err.Error())
+				goit.HttpError(w, http.StatusInternalServerError)
+				return
+			}
+
+			data.HtmlBody = template.HTML(html)
+			data.BodyCss = template.HTML("<style>" + css + "</style>")
+			data.Lines = strings.Split(body, "\n")
+
+			/* Only show the commit on the first of consecutive lines from the same commit */
+			var prev string
+			for _, l := range lines[:min(len(lines), len(data.Lines))] {
+				if l.Hash == prev {
+					data.Blame = append(data.Blame, row{})
+					continue
+				}
+
+				data.Blame = append(data.Blame, row{
+					Hash: l.Hash, Short: l.Hash[:7], Author: l.Author, Date: l.Date.UTC().Format(time.DateOnly),
+				})
+
+				prev = l.Hash
+			}
+		}
+	}
+
+	data.LineC = fmt.Sprint(len(data.Lines), " lines")
+
+	if err := goit.Tmpl.ExecuteTemplate(w, "repo/blame", data); err != nil {
+		util.Errorln("[/repo/blame]", err.Error())
+	}
+}
+
+/* Blame a file at a commit, returning the commit that last modified each line. */
+func blame(ctx context.Context, repo string, hash plumbing.Hash, fpath string) ([]blameLine, error) {
+	c := goit.NewGitCommand("blame", "--porcelain", hash.String(), "--", fpath)
+	c.Dir = goit.RepoPath(repo, true)
+
+	out, _, err := c.RunContext(ctx, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseBlame(string(out)), nil
+}
+
+/* Parse the output of "git blame --porcelain", returning the commit of each line. */
+func parseBlame(out string) []blameLine {
+	var lines []blameLine
+	var commits = map[string]*blameLine{}
+	var cur *blameLine
+
+	for _, l := range strings.Split(out, "\n") {
+		if strings.HasPrefix(l, "\t") {
+			if cur != nil {
+				lines = append(lines, *cur)
+			}
+
+			continue
+		}
+
+		key, val, _ := strings.Cut(l, " ")
+
+		/* Each line group begins with a header of the commit hash and line numbers */
+		if len(key) >= 40 && plumbing.IsHash(key[:40]) {
+			if commits[key] == nil {
+				commits[key] = &blameLine{Hash: key}
+			}
+
+			cur = commits[key]
+			continue
+		}
+
+		if cur == nil {
+			continue
+		}
+
+		switch key {
+		case "author":
+			cur.Author = val
+		case "author-time":
+			if t, err := strconv.ParseInt(val, 10, 64); err == nil {
+				cur.Date = time.Unix(t, 0)
+			}
+		}
+	}
+
+	return lines
+}
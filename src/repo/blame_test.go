@@ -0,0 +1,52 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package repo_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Jamozed/Goit/src/repo"
+)
+
+func TestParseBlame(t *testing.T) {
+	a, b := strings.Repeat("a", 40), strings.Repeat("b", 40)
+
+	/* Commit details are only given for the first line from each commit */
+	out := a + " 1 1 2\n" +
+		"author Alice\nauthor-mail <alice@example.com>\nauthor-time 1700000000\nauthor-tz +0000\n" +
+		"committer Alice\nsummary First\nfilename main.go\n" +
+		"\tpackage main\n" +
+		a + " 2 2\n" +
+		"\t\n" +
+		b + " 3 3 1\n" +
+		"author Bob\nauthor-time 1700086400\nprevious " + a + " main.go\nfilename main.go\n" +
+		"\tfunc main() {}\n" +
+		a + " 4 4 1\n" +
+		"\t// author Mallory\n"
+
+	lines := repo.ParseBlame(out)
+
+	want := []struct {
+		hash, author string
+		time         int64
+	}{
+		{a, "Alice", 1700000000}, {a, "Alice", 1700000000}, {b, "Bob", 1700086400}, {a, "Alice", 1700000000},
+	}
+
+	if len(lines) != len(want) {
+		t.Fatal("Expected", len(want), "lines got", len(lines))
+	}
+
+	for i, w := range want {
+		if lines[i].Hash != w.hash || lines[i].Author != w.author || lines[i].Date.Unix() != w.time {
+			t.Error("Line", i+1, "expected", w.hash, w.author, w.time, "got", lines[i].Hash, lines[i].Author,
+				lines[i].Date.Unix())
+		}
+	}
+
+	if lines := repo.ParseBlame(""); len(lines) != 0 {
+		t.Error("Expected no lines got", len(lines))
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"errors"
 	"fmt"
 	"html/template"
-	"log"
 	"net/http"
 	"path/filepath"
 	"strconv"
@@ -15,6 +14,7 @@ import (
 	"time"
 
 	"github.com/Jamozed/Goit/src/goit"
+	"github.com/Jamozed/Goit/src/util"
 	"github.com/buildkite/terminal-to-html/v3"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-git/go-git/v5"
@@ -24,7 +24,7 @@ import (
 func HandleCommit(w http.ResponseWriter, r *http.Request) {
 	auth, user, err := goit.Auth(w, r, true)
 	if err != nil {
-		log.Println("[/repo/commit]", err.Error())
+		util.Errorln("[/repo/commit]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 	}
 
@@ -37,18 +37,13 @@ func HandleCommit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	type stat struct {
-		Name, Path, Status, Num, Plusses, Minuses string
-		IsBinary                                  bool
-	}
-
 	data := struct {
 		HeaderFields
 		Title                       string
 		Author, Date, Commit        string
 		Parents                     []string
 		MessageSubject, MessageBody string
-		Stats                       []stat
+		Stats                       []fileStat
 		Summary                     string
 		Diff                        template.HTML
 	}{
@@ -58,7 +53,7 @@ func HandleCommit(w http.ResponseWriter, r *http.Request) {
 
 	gr, err := git.PlainOpen(goit.RepoPath(repo.Name, true))
 	if err != nil {
-		log.Println("[/repo/commit]", err.Error())
+		util.Errorln("[/repo/commit]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
@@ -66,7 +61,7 @@ func HandleCommit(w http.ResponseWriter, r *http.Request) {
 	ref, err := gr.Head()
 	if err != nil {
 		if !errors.Is(err, plumbing.ErrReferenceNotFound) {
-			log.Println("[/repo/log]", err.Error())
+			util.Errorln("[/repo/log]", err.Error())
 			goit.HttpError(w, http.StatusInternalServerError)
 			return
 		}
@@ -84,7 +79,7 @@ func HandleCommit(w http.ResponseWriter, r *http.Request) {
 		goit.HttpError(w, http.StatusNotFound)
 		return
 	} else if err != nil {
-		log.Println("[/repo/commit]", err.Error())
+		util.Errorln("[/repo/commit]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
@@ -101,14 +96,48 @@ func HandleCommit(w http.ResponseWriter, r *http.Request) {
 
 	st, err := goit.DiffStats(commit)
 	if err != nil {
-		log.Println("[/repo/commit]", err.Error())
+		util.Errorln("[/repo/commit]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	data.Stats, data.Summary = fileStats(st)
+
+	var phash string
+	if commit.NumParents() > 0 {
+		phash = commit.ParentHashes[0].String()
+	} else {
+		phash = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+	}
+
+	c := goit.NewGitCommand("diff", "--color=always", "-p", phash, commit.Hash.String())
+	c.Dir = goit.RepoPath(repo.Name, true)
+	out, _, err := c.Run(nil, nil)
+	if err != nil {
+		util.Errorln("[/repo/commit]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
 
+	data.Diff = template.HTML(terminal.Render(out))
+
+	if err := goit.Tmpl.ExecuteTemplate(w, "repo/commit", data); err != nil {
+		util.Errorln("[/repo/commit]", err.Error())
+	}
+}
+
+type fileStat struct {
+	Name, Path, Status, Num, Plusses, Minuses string
+	IsBinary                                  bool
+}
+
+/* Format diff stats for display, returning each file with a scaled bar of changes, and a summary line. */
+func fileStats(st []goit.DiffStat) ([]fileStat, string) {
+	var stats []fileStat
+
 	var files, additions, deletions int = len(st), 0, 0
 	for _, s := range st {
-		f := stat{Name: s.Name, Path: s.Name, Status: s.Status}
+		f := fileStat{Name: s.Name, Path: s.Name, Status: s.Status}
 		f.Num = strconv.FormatInt(int64(s.Addition+s.Deletion), 10)
 
 		if s.Addition+s.Deletion > 80 {
@@ -126,33 +155,11 @@ func HandleCommit(w http.ResponseWriter, r *http.Request) {
 			f.IsBinary = true
 		}
 
-		data.Stats = append(data.Stats, f)
+		stats = append(stats, f)
 
 		additions += s.Addition
 		deletions += s.Deletion
 	}
 
-	data.Summary = fmt.Sprintf("%d files changed, %d insertions, %d deletions", files, additions, deletions)
-
-	var phash string
-	if commit.NumParents() > 0 {
-		phash = commit.ParentHashes[0].String()
-	} else {
-		phash = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
-	}
-
-	c := goit.NewGitCommand("diff", "--color=always", "-p", phash, commit.Hash.String())
-	c.Dir = goit.RepoPath(repo.Name, true)
-	out, _, err := c.Run(nil, nil)
-	if err != nil {
-		log.Println("[/repo/commit]", err.Error())
-		goit.HttpError(w, http.StatusInternalServerError)
-		return
-	}
-
-	data.Diff = template.HTML(terminal.Render(out))
-
-	if err := goit.Tmpl.ExecuteTemplate(w, "repo/commit", data); err != nil {
-		log.Println("[/repo/commit]", err.Error())
-	}
+	return stats, fmt.Sprintf("%d files changed, %d insertions, %d deletions", files, additions, deletions)
 }
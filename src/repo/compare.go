@@ -0,0 +1,209 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package repo
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Jamozed/Goit/src/goit"
+	"github.com/Jamozed/Goit/src/util"
+	"github.com/buildkite/terminal-to-html/v3"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func HandleCompare(w http.ResponseWriter, r *http.Request) {
+	auth, user, err := goit.Auth(w, r, true)
+	if err != nil {
+		util.Errorln("[/repo/compare]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	repo, err := goit.GetRepoByName(chi.URLParam(r, "repo"))
+	if err != nil {
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	} else if repo == nil || !goit.IsVisible(repo, auth, user) {
+		goit.HttpError(w, http.StatusNotFound)
+		return
+	}
+
+	/* Redirect submissions of the compare form to the canonical URL, escaping refs that contain "?" or "#" */
+	if base, head := r.URL.Query().Get("base"), r.URL.Query().Get("head"); base != "" && head != "" {
+		u := url.URL{Path: "/" + repo.Name + "/compare/" + base + "..." + head}
+		http.Redirect(w, r, u.EscapedPath(), http.StatusFound)
+		return
+	}
+
+	type row struct{ Hash, Date, Message, Author string }
+	data := struct {
+		HeaderFields
+		Title, Base, Head, BaseHash, HeadHash string
+		Commits                               []row
+		More                                  int
+		Stats                                 []fileStat
+		Summary                               string
+		Diff                                  template.HTML
+	}{
+		Title:        repo.Name + " - Compare",
+		HeaderFields: GetHeaderFields(auth, user, repo, r.Host),
+	}
+
+	gr, err := git.PlainOpen(goit.RepoPath(repo.Name, true))
+	if err != nil {
+		util.Errorln("[/repo/compare]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	if err := data.setRefs(gr, ""); err != nil {
+		util.Errorln("[/repo/compare]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	if ref, err := gr.Head(); err != nil {
+		if !errors.Is(err, plumbing.ErrReferenceNotFound) {
+			util.Errorln("[/repo/compare]", err.Error())
+			goit.HttpError(w, http.StatusInternalServerError)
+			return
+		}
+	} else {
+		if readme, _ := findPattern(gr, ref, readmePattern); readme != "" {
+			data.Readme = path.Join("/", repo.Name, "file", readme)
+		}
+		if licence, _ := findPattern(gr, ref, licencePattern); licence != "" {
+			data.Licence = path.Join("/", repo.Name, "file", licence)
+		}
+	}
+
+	if spec := chi.URLParam(r, "*"); spec != "" {
+		base, head, ok := strings.Cut(spec, "...")
+		if !ok || base == "" || head == "" {
+			goit.HttpError(w, http.StatusNotFound)
+			return
+		}
+
+		data.Base, data.Head = base, head
+
+		baseRef, err := goit.ResolveRef(gr, data.Base)
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			goit.HttpError(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			util.Errorln("[/repo/compare]", err.Error())
+			goit.HttpError(w, http.StatusInternalServerError)
+			return
+		}
+
+		headRef, err := goit.ResolveRef(gr, data.Head)
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			goit.HttpError(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			util.Errorln("[/repo/compare]", err.Error())
+			goit.HttpError(w, http.StatusInternalServerError)
+			return
+		}
+
+		data.BaseHash, data.HeadHash = baseRef.Hash().String(), headRef.Hash().String()
+
+		baseCommit, err := gr.CommitObject(baseRef.Hash())
+		if err != nil {
+			util.Errorln("[/repo/compare]", err.Error())
+			goit.HttpError(w, http.StatusInternalServerError)
+			return
+		}
+
+		headCommit, err := gr.CommitObject(headRef.Hash())
+		if err != nil {
+			util.Errorln("[/repo/compare]", err.Error())
+			goit.HttpError(w, http.StatusInternalServerError)
+			return
+		}
+
+		/* List the commits reachable from head but not from base */
+		c := goit.NewGitCommand("rev-list", data.BaseHash+".."+data.HeadHash)
+		c.Dir = goit.RepoPath(repo.Name, true)
+		out, _, err := c.Run(nil, nil)
+		if err != nil {
+			util.Errorln("[/repo/compare]", err.Error())
+			goit.HttpError(w, http.StatusInternalServerError)
+			return
+		}
+
+		hashes := strings.Fields(string(out))
+		data.More = max(len(hashes)-PAGE, 0)
+
+		for _, h := range hashes[:min(len(hashes), PAGE)] {
+			c, err := gr.CommitObject(plumbing.NewHash(h))
+			if err != nil {
+				util.Errorln("[/repo/compare]", err.Error())
+				goit.HttpError(w, http.StatusInternalServerError)
+				return
+			}
+
+			data.Commits = append(data.Commits, row{
+				Hash: c.Hash.String(), Date: c.Author.When.UTC().Format(time.DateTime),
+				Message: strings.SplitN(c.Message, "\n", 2)[0], Author: c.Author.Name,
+			})
+		}
+
+		/* Diff against the merge base, so that changes made on base since head diverged are not shown */
+		from := baseCommit
+		if bases, err := baseCommit.MergeBase(headCommit); err != nil {
+			util.Errorln("[/repo/compare]", err.Error())
+			goit.HttpError(w, http.StatusInternalServerError)
+			return
+		} else if len(bases) != 0 {
+			from = bases[0]
+		}
+
+		fromTree, err := from.Tree()
+		if err != nil {
+			util.Errorln("[/repo/compare]", err.Error())
+			goit.HttpError(w, http.StatusInternalServerError)
+			return
+		}
+
+		toTree, err := headCommit.Tree()
+		if err != nil {
+			util.Errorln("[/repo/compare]", err.Error())
+			goit.HttpError(w, http.StatusInternalServerError)
+			return
+		}
+
+		st, err := goit.TreeDiffStats(fromTree, toTree)
+		if err != nil {
+			util.Errorln("[/repo/compare]", err.Error())
+			goit.HttpError(w, http.StatusInternalServerError)
+			return
+		}
+
+		data.Stats, data.Summary = fileStats(st)
+
+		c = goit.NewGitCommand("diff", "--color=always", "-p", from.Hash.String(), data.HeadHash)
+		c.Dir = goit.RepoPath(repo.Name, true)
+		out, _, err = c.Run(nil, nil)
+		if err != nil {
+			util.Errorln("[/repo/compare]", err.Error())
+			goit.HttpError(w, http.StatusInternalServerError)
+			return
+		}
+
+		data.Diff = template.HTML(terminal.Render(out))
+	}
+
+	if err := goit.Tmpl.ExecuteTemplate(w, "repo/compare", data); err != nil {
+		util.Errorln("[/repo/compare]", err.Error())
+	}
+}
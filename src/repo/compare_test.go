@@ -0,0 +1,102 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package repo_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Jamozed/Goit/src/goit"
+	"github.com/Jamozed/Goit/src/repo"
+)
+
+func TestHandleCompare(t *testing.T) {
+	_, base := newTestRepo(t, goit.Public, map[string]string{"a.txt": "one\n"})
+
+	/* Diverge feature/x from master, with a change on master after the merge base */
+	f1 := testCommit(t, base, map[string]string{"a.txt": "one\n", "b.txt": "two\n"})
+	f2 := testCommit(t, f1, map[string]string{"a.txt": "one\n", "b.txt": "three\n"})
+	m2 := testCommit(t, base, map[string]string{"a.txt": "changed\n"})
+	testGit(t, "", "update-ref", "refs/heads/feature/x", f2)
+	testGit(t, "", "update-ref", "refs/heads/master", m2)
+
+	compare := func(spec string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		repo.HandleCompare(w, newRequest(http.MethodGet, "/proj/compare/"+spec, map[string]string{
+			"repo": "proj", "*": spec,
+		}))
+		return w
+	}
+
+	t.Run("branches", func(t *testing.T) {
+		w := compare("master...feature/x")
+		if w.Code != http.StatusOK {
+			t.Fatal("Expected status 200 got", w.Code)
+		}
+
+		/* Only the commits on feature/x are listed, and only its changes since the merge base are shown */
+		body := w.Body.String()
+		for _, h := range []string{f1, f2} {
+			if !strings.Contains(body, "/commit/"+h) {
+				t.Error("Expected commit", h, "to be listed")
+			}
+		}
+
+		for _, h := range []string{base, m2} {
+			if strings.Contains(body, "/commit/"+h) {
+				t.Error("Expected commit", h, "to not be listed")
+			}
+		}
+
+		if !strings.Contains(body, "b.txt") || strings.Contains(body, "a.txt") {
+			t.Error("Expected only b.txt to be changed")
+		}
+	})
+
+	t.Run("short hash", func(t *testing.T) {
+		w := compare(base[:7] + "..." + f1)
+		if w.Code != http.StatusOK {
+			t.Fatal("Expected status 200 got", w.Code)
+		}
+
+		if body := w.Body.String(); !strings.Contains(body, "/commit/"+f1) || strings.Contains(body, "/commit/"+f2) {
+			t.Error("Expected only", f1, "to be listed")
+		}
+	})
+
+	t.Run("same", func(t *testing.T) {
+		w := compare("feature/x...feature/x")
+		if w.Code != http.StatusOK {
+			t.Fatal("Expected status 200 got", w.Code)
+		}
+
+		if body := w.Body.String(); strings.Contains(body, "/commit/"+f2) {
+			t.Error("Expected no commits to be listed")
+		}
+	})
+
+	for _, spec := range []string{"master...unknown", "unknown...master", "master", "...master", "master..."} {
+		t.Run("not found "+spec, func(t *testing.T) {
+			if w := compare(spec); w.Code != http.StatusNotFound {
+				t.Error("Expected status 404 got", w.Code)
+			}
+		})
+	}
+
+	t.Run("redirect", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		target := "/proj/compare?base=master&head=feature/%231%3Fa"
+		repo.HandleCompare(w, newRequest(http.MethodGet, target, map[string]string{"repo": "proj"}))
+
+		if w.Code != http.StatusFound {
+			t.Fatal("Expected status 302 got", w.Code)
+		}
+
+		if loc, want := w.Header().Get("Location"), "/proj/compare/master...feature/%231%3Fa"; loc != want {
+			t.Error("Expected", want, "got", loc)
+		}
+	})
+}
@@ -8,7 +8,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"path/filepath"
 	"strings"
@@ -24,7 +23,7 @@ import (
 func HandleDownload(w http.ResponseWriter, r *http.Request) {
 	auth, user, err := goit.Auth(w, r, true)
 	if err != nil {
-		log.Println("[repo/download]", err.Error())
+		util.Errorln("[repo/download]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
@@ -42,24 +41,24 @@ func HandleDownload(w http.ResponseWriter, r *http.Request) {
 
 	gr, err := git.PlainOpen(goit.RepoPath(repo.Name, true))
 	if err != nil {
-		log.Println("[/repo/download]", err.Error())
+		util.Errorln("[/repo/download]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
 
-	ref, err := gr.Head()
+	ref, err := goit.ResolveRef(gr, r.URL.Query().Get("ref"))
 	if errors.Is(err, plumbing.ErrReferenceNotFound) {
 		goit.HttpError(w, http.StatusNotFound)
 		return
 	} else if err != nil {
-		log.Println("[/repo/download]", err.Error())
+		util.Errorln("[/repo/download]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
 
 	commit, err := gr.CommitObject(ref.Hash())
 	if err != nil {
-		log.Println("[/repo/download]", err.Error())
+		util.Errorln("[/repo/download]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
@@ -72,7 +71,7 @@ func HandleDownload(w http.ResponseWriter, r *http.Request) {
 
 		iter, err := commit.Files()
 		if err != nil {
-			log.Println("[/repo/download]", err.Error())
+			util.Errorln("[/repo/download]", err.Error())
 			goit.HttpError(w, http.StatusInternalServerError)
 			return
 		}
@@ -95,6 +94,7 @@ func HandleDownload(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set(
 			"Content-Disposition", "attachment; filename="+util.If(tpath == "", repo.Name, filepath.Base(tpath))+".zip",
 		)
+		w.Header().Set("Content-Type", "application/zip")
 		// w.Header().Set("Content-Length", fmt.Sprint(zSize))
 
 		z := zip.NewWriter(w)
@@ -103,21 +103,22 @@ func HandleDownload(w http.ResponseWriter, r *http.Request) {
 
 			zf, err := z.CreateHeader(&zh)
 			if err != nil {
-				log.Println("[/repo/download]", err.Error())
+				util.Errorln("[/repo/download]", err.Error())
 				goit.HttpError(w, http.StatusInternalServerError)
+				return
 			}
 
 			if file, err := commit.File(f); err != nil {
-				log.Println("[/repo/download]", err.Error())
+				util.Errorln("[/repo/download]", err.Error())
 				goit.HttpError(w, http.StatusInternalServerError)
 				return
-			} else if rc, err := file.Blob.Reader(); err != nil {
-				log.Println("[/repo/download]", err.Error())
+			} else if rc, _, err := goit.OpenFile(repo, file); err != nil {
+				util.Errorln("[/repo/download]", err.Error())
 				goit.HttpError(w, http.StatusInternalServerError)
 				return
 			} else {
 				if _, err := io.Copy(zf, rc); err != nil {
-					log.Println("[/repo/download]", err.Error())
+					util.Errorln("[/repo/download]", err.Error())
 				}
 
 				rc.Close()
@@ -127,21 +128,22 @@ func HandleDownload(w http.ResponseWriter, r *http.Request) {
 		z.Close()
 		return
 	} else if err != nil {
-		log.Println("[/repo/download]", err.Error())
+		util.Errorln("[/repo/download]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
 
-	if rc, err := file.Blob.Reader(); err != nil {
-		log.Println("[/repo/download]", err.Error())
+	if rc, size, err := goit.OpenFile(repo, file); err != nil {
+		util.Errorln("[/repo/download]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	} else {
-		w.Header().Set("Content-Disposition", "attachement; filename="+filepath.Base(tpath))
-		w.Header().Set("Content-Length", fmt.Sprint(file.Size))
+		w.Header().Set("Content-Disposition", "attachment; filename="+filepath.Base(tpath))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", fmt.Sprint(size))
 
 		if _, err := io.Copy(w, rc); err != nil {
-			log.Println("[/repo/download]", err.Error())
+			util.Errorln("[/repo/download]", err.Error())
 		}
 
 		rc.Close()
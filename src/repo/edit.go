@@ -7,15 +7,14 @@ import (
 	"errors"
 	"fmt"
 	"html/template"
-	"log"
 	"net/http"
 	"path/filepath"
-	"slices"
+	"strconv"
 	"strings"
 
-	"github.com/Jamozed/Goit/src/cron"
 	"github.com/Jamozed/Goit/src/goit"
 	"github.com/Jamozed/Goit/src/util"
+	"github.com/dustin/go-humanize"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
@@ -25,7 +24,7 @@ import (
 func HandleEdit(w http.ResponseWriter, r *http.Request) {
 	auth, user, err := goit.Auth(w, r, true)
 	if err != nil {
-		log.Println("[/repo/edit]", err.Error())
+		util.Errorln("[/repo/edit]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
@@ -37,28 +36,30 @@ func HandleEdit(w http.ResponseWriter, r *http.Request) {
 
 	repo, err := goit.GetRepoByName(chi.URLParam(r, "repo"))
 	if err != nil {
-		log.Println("[/repo/edit]", err.Error())
+		util.Errorln("[/repo/edit]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
-	} else if repo == nil || (!auth || repo.OwnerId != user.Id) {
+	} else if repo == nil || goit.RepoAccess(repo, auth, user) < goit.AccessAdmin {
 		goit.HttpError(w, http.StatusNotFound)
 		return
 	}
 
 	owner, err := goit.GetUser(repo.OwnerId)
 	if err != nil {
-		log.Println("[/repo/edit]", err.Error())
+		util.Errorln("[/repo/edit]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	} else if owner == nil {
-		log.Println("[/repo/edit]", repo.Id, "is owned by a nonexistent user")
+		util.Errorln("[/repo/edit]", repo.Id, "is owned by a nonexistent user")
 		/* TODO have admin adopt the orphaned repository */
 		owner = &goit.User{}
 	}
 
+	type collab struct{ Name, FullName, Access string }
 	data := struct {
 		HeaderFields
-		Title string
+		Title   string
+		IsOwner bool
 
 		Edit struct {
 			Id, Owner, Name, Description        string
@@ -67,6 +68,20 @@ func HandleEdit(w http.ResponseWriter, r *http.Request) {
 			Message                             string
 		}
 
+		Collaborators []collab
+		Collaborate   struct{ Name, Access, Message string }
+
+		Webhooks goit.WebhookFields
+
+		Protected []protectedRow
+		Protect   struct{ Pattern, Users, Message string }
+
+		Rules struct {
+			MaxFileSize, BranchPattern string
+			DenyForcePush              bool
+			Message                    string
+		}
+
 		Transfer struct{ Owner, Message string }
 		Delete   struct{ Message string }
 
@@ -74,6 +89,8 @@ func HandleEdit(w http.ResponseWriter, r *http.Request) {
 	}{
 		Title:        "Repository - Edit",
 		HeaderFields: GetHeaderFields(auth, user, repo, r.Host),
+		IsOwner:      repo.OwnerId == user.Id,
+		Webhooks:     goit.WebhookFields{Action: "/" + repo.Name + "/edit", CsrfField: csrf.TemplateField(r)},
 
 		CsrfField: csrf.TemplateField(r),
 	}
@@ -87,16 +104,27 @@ func HandleEdit(w http.ResponseWriter, r *http.Request) {
 	data.Edit.Visibility = repo.Visibility.String()
 	data.Edit.IsMirror = repo.IsMirror
 
+	rules, err := goit.GetPushRules(repo.Id)
+	if err != nil {
+		util.Errorln("[/repo/edit]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	data.Rules.MaxFileSize = util.If(rules.MaxFileSize == 0, "", humanize.IBytes(uint64(rules.MaxFileSize)))
+	data.Rules.DenyForcePush = rules.DenyForcePush
+	data.Rules.BranchPattern = rules.BranchPattern
+
 	gr, err := git.PlainOpen(goit.RepoPath(repo.Name, true))
 	if err != nil {
-		log.Println("[/repo/edit]", err.Error())
+		util.Errorln("[/repo/edit]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
 
 	ref, err := gr.Head()
 	if err != nil && !errors.Is(err, plumbing.ErrReferenceNotFound) {
-		log.Println("[/repo/edit]", err.Error())
+		util.Errorln("[/repo/edit]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
@@ -120,73 +148,164 @@ func HandleEdit(w http.ResponseWriter, r *http.Request) {
 			data.Edit.Visibility = r.FormValue("visibility")
 			data.Edit.IsMirror = r.FormValue("mirror") == "mirror"
 
-			if data.Edit.Name == "" {
-				data.Edit.Message = "Name cannot be empty"
-			} else if slices.Contains(goit.Reserved, data.Edit.Name) || !goit.IsLegal(data.Name) {
-				data.Edit.Message = "Name \"" + data.Edit.Name + "\" is illegal"
-			} else if exists, err := goit.RepoExists(data.Edit.Name); err != nil {
-				log.Println("[/repo/edit]", err.Error())
+			edit := goit.Repo{
+				Name: data.Edit.Name, Description: data.Edit.Description, DefaultBranch: data.Edit.DefaultBranch,
+				Upstream: data.Edit.Upstream, Visibility: goit.VisibilityFromString(data.Edit.Visibility),
+				IsMirror: data.Edit.IsMirror,
+			}
+
+			if msg, err := goit.ValidateRepo(edit, repo); err != nil {
+				util.Errorln("[/repo/edit]", err.Error())
 				goit.HttpError(w, http.StatusInternalServerError)
 				return
-			} else if exists && !strings.EqualFold(data.Edit.Name, repo.Name) {
-				data.Edit.Message = "Name \"" + data.Edit.Name + "\" is taken"
-			} else if len(data.Edit.Description) > 256 {
-				data.Edit.Message = "Description cannot exceed 256 characters"
-			} else if visibility := goit.VisibilityFromString(data.Edit.Visibility); visibility == -1 {
-				data.Edit.Message = "Visibility \"" + data.Edit.Visibility + "\" is invalid"
-			} else if err := goit.UpdateRepo(repo.Id, goit.Repo{
-				Name: data.Edit.Name, Description: data.Edit.Description, DefaultBranch: data.Edit.DefaultBranch,
-				Upstream: data.Edit.Upstream, Visibility: visibility, IsMirror: data.Edit.IsMirror,
-			}); err != nil {
-				log.Println("[/repo/edit]", err.Error())
+			} else if msg != "" {
+				data.Edit.Message = msg
+			} else if err := goit.UpdateRepo(repo.Id, edit); err != nil {
+				util.Errorln("[/repo/edit]", err.Error())
 				goit.HttpError(w, http.StatusInternalServerError)
 				return
 			} else {
-				if (data.Edit.Upstream == "" && repo.Upstream != "") || !data.Edit.IsMirror {
-					goit.Cron.RemoveFor(repo.Id)
-					goit.Cron.Update()
-				} else if data.Edit.Upstream != "" && data.Edit.IsMirror &&
-					(data.Edit.Upstream != repo.Upstream || !repo.IsMirror) {
-					goit.Cron.RemoveFor(repo.Id)
-
-					goit.Cron.Add(repo.Id, cron.Immediate, func() {
-						if err := goit.Pull(repo.Id); err != nil {
-							log.Println("[cron:import]", err.Error())
-						}
-						log.Println("[cron:import] imported", data.Name)
-					})
-
-					goit.Cron.Add(repo.Id, cron.Daily, func() {
-						if err := goit.Pull(repo.Id); err != nil {
-							log.Println("[cron:mirror]", err.Error())
-						}
-						log.Println("[cron:mirror] updated", data.Edit.Name)
-					})
-
-					goit.Cron.Update()
-				}
+				goit.RescheduleMirror(*repo, edit)
 
 				http.Redirect(w, r, "/"+data.Edit.Name+"/edit", http.StatusFound)
 				return
 			}
 
+		case "collaborate":
+			data.Collaborate.Name = r.FormValue("username")
+			data.Collaborate.Access = r.FormValue("access")
+
+			if data.Collaborate.Name == "" {
+				data.Collaborate.Message = "Username cannot be empty"
+			} else if access := goit.AccessFromString(data.Collaborate.Access); access == -1 {
+				data.Collaborate.Message = "Access \"" + data.Collaborate.Access + "\" is invalid"
+			} else if u, err := goit.GetUserByName(data.Collaborate.Name); err != nil {
+				util.Errorln("[/repo/edit]", err.Error())
+				goit.HttpError(w, http.StatusInternalServerError)
+				return
+			} else if u == nil {
+				data.Collaborate.Message = "User \"" + data.Collaborate.Name + "\" does not exist"
+			} else if u.Id == repo.OwnerId {
+				data.Collaborate.Message = "User \"" + data.Collaborate.Name + "\" is the owner"
+			} else if err := goit.SetCollaborator(repo.Id, u.Id, access); err != nil {
+				util.Errorln("[/repo/edit]", err.Error())
+				goit.HttpError(w, http.StatusInternalServerError)
+				return
+			} else {
+				util.Infoln(
+					"[/repo/edit] User", user.Id, "granted", access.String(), "access to repo", repo.Id, "for", u.Id,
+				)
+				http.Redirect(w, r, "/"+repo.Name+"/edit", http.StatusFound)
+				return
+			}
+
+		case "rules":
+			data.Rules.MaxFileSize = strings.TrimSpace(r.FormValue("maxsize"))
+			data.Rules.DenyForcePush = r.FormValue("denyforce") == "denyforce"
+			data.Rules.BranchPattern = r.FormValue("pattern")
+
+			size, serr := humanize.ParseBytes(util.If(data.Rules.MaxFileSize == "", "0", data.Rules.MaxFileSize))
+			rules := goit.PushRules{
+				MaxFileSize: int64(size), DenyForcePush: data.Rules.DenyForcePush, BranchPattern: data.Rules.BranchPattern,
+			}
+
+			if serr != nil {
+				data.Rules.Message = "Maximum file size \"" + data.Rules.MaxFileSize + "\" is invalid"
+			} else if msg := goit.ValidatePushRules(rules); msg != "" {
+				data.Rules.Message = msg
+			} else if err := goit.SetPushRules(repo.Id, rules); err != nil {
+				util.Errorln("[/repo/edit]", err.Error())
+				goit.HttpError(w, http.StatusInternalServerError)
+				return
+			} else {
+				http.Redirect(w, r, "/"+repo.Name+"/edit", http.StatusFound)
+				return
+			}
+
+		case "protect":
+			data.Protect.Pattern = strings.TrimSpace(r.FormValue("pattern"))
+			data.Protect.Users = r.FormValue("users")
+
+			b := goit.ProtectedBranch{RepoId: repo.Id, Pattern: data.Protect.Pattern}
+
+			if !data.IsOwner {
+				data.Protect.Message = "Only the owner can protect branches"
+			} else if msg := goit.ValidateBranchPattern(b.Pattern); msg != "" {
+				data.Protect.Message = msg
+			} else {
+				for _, name := range strings.Fields(strings.ReplaceAll(data.Protect.Users, ",", " ")) {
+					if u, err := goit.GetUserByName(name); err != nil {
+						util.Errorln("[/repo/edit]", err.Error())
+						goit.HttpError(w, http.StatusInternalServerError)
+						return
+					} else if u == nil {
+						data.Protect.Message = "User \"" + name + "\" does not exist"
+						break
+					} else {
+						b.Users = append(b.Users, u.Id)
+					}
+				}
+
+				if data.Protect.Message != "" {
+					break
+				}
+
+				if err := goit.ProtectBranch(b); err != nil {
+					util.Errorln("[/repo/edit]", err.Error())
+					goit.HttpError(w, http.StatusInternalServerError)
+					return
+				}
+
+				util.Infoln("[/repo/edit] User", user.Id, "protected branches", b.Pattern, "of repo", repo.Id)
+				http.Redirect(w, r, "/"+repo.Name+"/edit", http.StatusFound)
+				return
+			}
+
+		case "unprotect":
+			if !data.IsOwner {
+				data.Protect.Message = "Only the owner can unprotect branches"
+			} else if pid, err := strconv.ParseInt(r.FormValue("protected"), 10, 64); err != nil {
+				data.Protect.Message = "Protected branch is invalid"
+			} else if err := goit.UnprotectBranch(repo.Id, pid); err != nil {
+				util.Errorln("[/repo/edit]", err.Error())
+				goit.HttpError(w, http.StatusInternalServerError)
+				return
+			} else {
+				util.Infoln("[/repo/edit] User", user.Id, "unprotected branches", pid, "of repo", repo.Id)
+				http.Redirect(w, r, "/"+repo.Name+"/edit", http.StatusFound)
+				return
+			}
+
+		case "webhook", "unhook", "redeliver":
+			if done, err := goit.WebhookAction(r, user.Id, repo.Id, &data.Webhooks); err != nil {
+				util.Errorln("[/repo/edit]", err.Error())
+				goit.HttpError(w, http.StatusInternalServerError)
+				return
+			} else if done {
+				http.Redirect(w, r, "/"+repo.Name+"/edit", http.StatusFound)
+				return
+			}
+
 		case "transfer":
 			data.Transfer.Owner = r.FormValue("owner")
 
-			if data.Transfer.Owner == "" {
+			if !data.IsOwner {
+				data.Transfer.Message = "Only the owner can transfer ownership"
+			} else if data.Transfer.Owner == "" {
 				data.Transfer.Message = "New owner cannot be empty"
 			} else if u, err := goit.GetUserByName(data.Transfer.Owner); err != nil {
-				log.Println("[/repo/edit]", err.Error())
+				util.Errorln("[/repo/edit]", err.Error())
 				goit.HttpError(w, http.StatusInternalServerError)
 				return
 			} else if u == nil {
 				data.Transfer.Message = "User \"" + data.Transfer.Owner + "\" does not exist"
 			} else if err := goit.ChownRepo(repo.Id, u.Id); err != nil {
-				log.Println("[/repo/edit]", err.Error())
+				util.Errorln("[/repo/edit]", err.Error())
 				goit.HttpError(w, http.StatusInternalServerError)
 				return
 			} else {
-				log.Println("User", user.Id, "transferred repo", repo.Id, "ownership to", u.Id)
+				util.Infoln("[/repo/edit] User", user.Id, "transferred repo", repo.Id, "ownership to", u.Id)
+				goit.TransferWebhooks(*repo, u.Id, user)
 				http.Redirect(w, r, "/"+data.Edit.Name, http.StatusFound)
 				return
 			}
@@ -194,20 +313,62 @@ func HandleEdit(w http.ResponseWriter, r *http.Request) {
 		case "delete":
 			var reponame = r.FormValue("reponame")
 
-			if reponame != repo.Name {
+			if !data.IsOwner {
+				data.Delete.Message = "Only the owner can delete the repository"
+			} else if reponame != repo.Name {
 				data.Delete.Message = "Input does not match the repository name"
-			} else if err := goit.DelRepo(repo.Id); err != nil {
-				log.Println("[/repo/edit]", err.Error())
-				goit.HttpError(w, http.StatusInternalServerError)
-				return
 			} else {
+				/* Webhooks are deleted with the repository, so dispatch to them first */
+				goit.DispatchWebhooks("delete", repo, user, nil, nil)
+
+				if err := goit.DelRepo(repo.Id); err != nil {
+					util.Errorln("[/repo/edit]", err.Error())
+					goit.HttpError(w, http.StatusInternalServerError)
+					return
+				}
+
 				http.Redirect(w, r, "/", http.StatusFound)
 				return
 			}
 		}
 	}
 
+	collabs, err := goit.GetCollaborators(repo.Id)
+	if err != nil {
+		util.Errorln("[/repo/edit]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	for _, c := range collabs {
+		if u, err := goit.GetUser(c.UserId); err != nil {
+			util.Errorln("[/repo/edit]", err.Error())
+			goit.HttpError(w, http.StatusInternalServerError)
+			return
+		} else if u != nil {
+			data.Collaborators = append(data.Collaborators, collab{
+				Name: u.Name, FullName: u.FullName, Access: c.Access.String(),
+			})
+		}
+	}
+
+	if protected, err := goit.GetProtectedBranches(repo.Id); err != nil {
+		util.Errorln("[/repo/edit]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	} else if data.Protected, err = protectedRows(protected); err != nil {
+		util.Errorln("[/repo/edit]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	if err := data.Webhooks.Load(user.Id, repo.Id, user.IsAdmin); err != nil {
+		util.Errorln("[/repo/edit]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
 	if err := goit.Tmpl.ExecuteTemplate(w, "repo/edit", data); err != nil {
-		log.Println("[/repo/edit]", err.Error())
+		util.Errorln("[/repo/edit]", err.Error())
 	}
 }
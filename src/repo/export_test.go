@@ -0,0 +1,26 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package repo
+
+import (
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+var (
+	ParseBlame = parseBlame
+	RefQuery   = refQuery
+)
+
+/* Return the refs listed in the header of a view of a repository at a revision. */
+func SetRefs(gr *git.Repository, rev string) ([]string, error) {
+	h := HeaderFields{}
+	err := h.setRefs(gr, rev)
+	return h.Refs, err
+}
+
+/* Resolve a README link destination in a repository at a revision. */
+func ResolveLink(tree *object.Tree, repo, rev, dest string, raw bool) string {
+	return string((&linkTransformer{repo: repo, rev: rev, tree: tree}).resolve([]byte(dest), raw))
+}
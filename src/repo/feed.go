@@ -0,0 +1,326 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package repo
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Jamozed/Goit/src/goit"
+	"github.com/Jamozed/Goit/src/util"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+const feedSize = 50
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	Id      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	Title   string      `xml:"title"`
+	Id      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Link    atomLink    `xml:"link"`
+	Content atomContent `xml:"content"`
+
+	when time.Time
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+func HandleLogAtom(w http.ResponseWriter, r *http.Request) {
+	repo, gr := feedRepo(w, r)
+	if repo == nil {
+		return
+	}
+
+	base := feedBase(r)
+
+	entries, err := commitEntries(gr, repo, base)
+	if err != nil {
+		util.Errorln("[/repo/log.atom]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	writeFeed(w, atomFeed{
+		Title: repo.Name + " commits", Id: base + "/" + repo.Name + "/log", Link: atomLink{base + "/" + repo.Name + "/log"},
+		Entries: entries,
+	})
+}
+
+func HandleRefsAtom(w http.ResponseWriter, r *http.Request) {
+	repo, gr := feedRepo(w, r)
+	if repo == nil {
+		return
+	}
+
+	base := feedBase(r)
+
+	entries, err := tagEntries(gr, repo, base)
+	if err != nil {
+		util.Errorln("[/repo/refs.atom]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	writeFeed(w, atomFeed{
+		Title: repo.Name + " tags", Id: base + "/" + repo.Name + "/refs", Link: atomLink{base + "/" + repo.Name + "/refs"},
+		Entries: entries,
+	})
+}
+
+/* Serve a feed of the commits and tags in all of a user's repositories that are visible to the requester. */
+func HandleUserAtom(w http.ResponseWriter, r *http.Request) {
+	auth, user, err := feedAuth(w, r)
+	if err != nil {
+		util.Errorln("[/user/activity.atom]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	owner, err := goit.GetUserByName(chi.URLParam(r, "name"))
+	if err != nil {
+		util.Errorln("[/user/activity.atom]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	} else if owner == nil {
+		goit.HttpError(w, http.StatusNotFound)
+		return
+	}
+
+	repos, err := goit.GetRepos()
+	if err != nil {
+		util.Errorln("[/user/activity.atom]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	grants, err := goit.GetGrants(user)
+	if err != nil {
+		util.Errorln("[/user/activity.atom]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	base := feedBase(r)
+	var entries []atomEntry
+
+	for _, repo := range repos {
+		if repo.OwnerId != owner.Id || !grants.IsVisible(&repo, auth, user) {
+			continue
+		}
+
+		gr, err := git.PlainOpen(goit.RepoPath(repo.Name, true))
+		if err != nil {
+			util.Errorln("[/user/activity.atom]", err.Error())
+			goit.HttpError(w, http.StatusInternalServerError)
+			return
+		}
+
+		commits, err := commitEntries(gr, &repo, base)
+		if err != nil {
+			util.Errorln("[/user/activity.atom]", err.Error())
+			goit.HttpError(w, http.StatusInternalServerError)
+			return
+		}
+
+		tags, err := tagEntries(gr, &repo, base)
+		if err != nil {
+			util.Errorln("[/user/activity.atom]", err.Error())
+			goit.HttpError(w, http.StatusInternalServerError)
+			return
+		}
+
+		for _, e := range append(commits, tags...) {
+			e.Title = repo.Name + ": " + e.Title
+			entries = append(entries, e)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].when.After(entries[j].when) })
+
+	writeFeed(w, atomFeed{
+		Title: owner.Name + " activity", Id: base + "/user/" + owner.Name, Link: atomLink{base + "/"},
+		Entries: entries[:min(len(entries), feedSize)],
+	})
+}
+
+/* Authenticate a feed request with a session cookie, or with Basic authentication for feed readers. */
+func feedAuth(w http.ResponseWriter, r *http.Request) (bool, *goit.User, error) {
+	if auth, user, err := goit.Auth(w, r, false); err != nil || auth {
+		return auth, user, err
+	}
+
+	if user, scope, err := goit.BasicAuth(r); err != nil {
+		return false, nil, err
+	} else if user != nil && scope >= goit.AccessRead {
+		return true, user, nil
+	}
+
+	return false, nil, nil
+}
+
+/* Load and open the repository of a feed request, writing an error response if it cannot be served. */
+func feedRepo(w http.ResponseWriter, r *http.Request) (*goit.Repo, *git.Repository) {
+	auth, user, err := feedAuth(w, r)
+	if err != nil {
+		util.Errorln("[/repo/atom]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return nil, nil
+	}
+
+	repo, err := goit.GetRepoByName(chi.URLParam(r, "repo"))
+	if err != nil {
+		goit.HttpError(w, http.StatusInternalServerError)
+		return nil, nil
+	} else if repo == nil || !goit.IsVisible(repo, auth, user) {
+		/* Prompt feed readers for credentials if none were given */
+		if !auth {
+			w.Header().Set("WWW-Authenticate", "Basic realm=\"goit\"")
+			goit.HttpError(w, http.StatusUnauthorized)
+		} else {
+			goit.HttpError(w, http.StatusNotFound)
+		}
+
+		return nil, nil
+	}
+
+	gr, err := git.PlainOpen(goit.RepoPath(repo.Name, true))
+	if err != nil {
+		util.Errorln("[/repo/atom]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return nil, nil
+	}
+
+	return repo, gr
+}
+
+func feedBase(r *http.Request) string {
+	return util.If(goit.Conf.UsesHttps, "https://", "http://") + r.Host
+}
+
+/* Create feed entries for the latest commits on the default branch of a repository. */
+func commitEntries(gr *git.Repository, repo *goit.Repo, base string) ([]atomEntry, error) {
+	ref, err := gr.Head()
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	iter, err := gr.Log(&git.LogOptions{From: ref.Hash(), Order: git.LogOrderCommitterTime})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var entries []atomEntry
+	for i := 0; i < feedSize; i += 1 {
+		c, err := iter.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		link := base + "/" + repo.Name + "/commit/" + c.Hash.String()
+		entries = append(entries, atomEntry{
+			Title: strings.SplitN(c.Message, "\n", 2)[0], Id: link, Updated: c.Committer.When.UTC().Format(time.RFC3339),
+			Author: atomAuthor{c.Author.Name}, Link: atomLink{link}, Content: atomContent{"text", c.Message},
+			when: c.Committer.When,
+		})
+	}
+
+	return entries, nil
+}
+
+/* Create feed entries for the latest tags of a repository, using the tagger date of annotated tags. */
+func tagEntries(gr *git.Repository, repo *goit.Repo, base string) ([]atomEntry, error) {
+	iter, err := gr.Tags()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []atomEntry
+	if err := iter.ForEach(func(r *plumbing.Reference) error {
+		var e atomEntry
+
+		if tag, err := gr.TagObject(r.Hash()); err != nil {
+			if !errors.Is(err, plumbing.ErrObjectNotFound) {
+				return err
+			}
+
+			c, err := gr.CommitObject(r.Hash())
+			if errors.Is(err, plumbing.ErrObjectNotFound) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			e = atomEntry{Author: atomAuthor{c.Author.Name}, Content: atomContent{"text", c.Message}, when: c.Committer.When}
+		} else {
+			e = atomEntry{Author: atomAuthor{tag.Tagger.Name}, Content: atomContent{"text", tag.Message}, when: tag.Tagger.When}
+		}
+
+		link := base + "/" + repo.Name + "/tree?ref=" + url.QueryEscape(r.Name().Short())
+		e.Title, e.Id, e.Link = r.Name().Short(), link, atomLink{link}
+		e.Updated = e.when.UTC().Format(time.RFC3339)
+
+		entries = append(entries, e)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].when.After(entries[j].when) })
+	return entries[:min(len(entries), feedSize)], nil
+}
+
+func writeFeed(w http.ResponseWriter, feed atomFeed) {
+	feed.Updated = time.Unix(0, 0).UTC().Format(time.RFC3339)
+	if len(feed.Entries) != 0 {
+		feed.Updated = feed.Entries[0].Updated
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		util.Errorln("[atom]", err.Error())
+		return
+	}
+
+	if err := enc.Encode(feed); err != nil {
+		util.Errorln("[atom]", err.Error())
+	}
+}
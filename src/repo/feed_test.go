@@ -0,0 +1,162 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package repo_test
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Jamozed/Goit/src/goit"
+	"github.com/Jamozed/Goit/src/repo"
+)
+
+type testFeed struct {
+	XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+	Entries []struct {
+		Title string `xml:"title"`
+		Link  struct {
+			Href string `xml:"href,attr"`
+		} `xml:"link"`
+	} `xml:"entry"`
+}
+
+/* Serve a feed request with chi route parameters, as a user with a token if the user is not empty. */
+func serveFeed(
+	t *testing.T, h http.HandlerFunc, target string, params map[string]string, user, token string,
+) (*httptest.ResponseRecorder, testFeed) {
+	t.Helper()
+
+	r := newRequest(http.MethodGet, target, params)
+	if user != "" {
+		r.SetBasicAuth(user, token)
+	}
+
+	w := httptest.NewRecorder()
+	h(w, r)
+
+	var feed testFeed
+	if w.Code == http.StatusOK {
+		if ct := w.Header().Get("Content-Type"); ct != "application/atom+xml; charset=utf-8" {
+			t.Error("Expected an Atom content type, got", ct)
+		}
+
+		if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	return w, feed
+}
+
+func TestFeeds(t *testing.T) {
+	_, hash := newTestRepo(t, goit.Private, map[string]string{"a.txt": "content"})
+	testGit(t, "", "tag", "v1", hash)
+
+	if err := goit.CreateUser(goit.User{Name: "bob", Pass: []byte{}, Salt: []byte{}}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	/* A public repository of alice's with the same commit */
+	pub := goit.Repo{OwnerId: 1, Name: "pub", DefaultBranch: "master", Visibility: goit.Public}
+	if _, err := goit.CreateRepo(pub); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := exec.Command("git", "--git-dir", goit.RepoPath("pub", true), "fetch", "--no-tags",
+		goit.RepoPath("proj", true), "refs/heads/master:refs/heads/master").Run(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tokens := map[string]string{}
+	for uid, name := range map[int64]string{1: "alice", 2: "bob"} {
+		_, token, err := goit.NewToken(uid, "feed", goit.AccessRead, time.Time{})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		tokens[name] = token
+	}
+
+	params := map[string]string{"repo": "proj"}
+
+	t.Run("anonymous", func(t *testing.T) {
+		for _, h := range []http.HandlerFunc{repo.HandleLogAtom, repo.HandleRefsAtom} {
+			w, _ := serveFeed(t, h, "/proj/log.atom", params, "", "")
+			if w.Code != http.StatusUnauthorized {
+				t.Error("Expected status 401 got", w.Code)
+			} else if w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("Expected a prompt for credentials")
+			}
+		}
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		w, _ := serveFeed(t, repo.HandleLogAtom, "/proj/log.atom", params, "alice", "invalid")
+		if w.Code != http.StatusUnauthorized {
+			t.Error("Expected status 401 got", w.Code)
+		}
+	})
+
+	t.Run("no access", func(t *testing.T) {
+		w, _ := serveFeed(t, repo.HandleLogAtom, "/proj/log.atom", params, "bob", tokens["bob"])
+		if w.Code != http.StatusNotFound {
+			t.Error("Expected status 404 got", w.Code)
+		}
+	})
+
+	t.Run("read token", func(t *testing.T) {
+		w, feed := serveFeed(t, repo.HandleLogAtom, "/proj/log.atom", params, "alice", tokens["alice"])
+		if w.Code != http.StatusOK {
+			t.Fatal("Expected status 200 got", w.Code)
+		}
+
+		if len(feed.Entries) != 1 || !strings.HasSuffix(feed.Entries[0].Link.Href, "/proj/commit/"+hash) {
+			t.Error("Expected an entry for", hash, "got", feed.Entries)
+		}
+
+		w, feed = serveFeed(t, repo.HandleRefsAtom, "/proj/refs.atom", params, "alice", tokens["alice"])
+		if w.Code != http.StatusOK {
+			t.Fatal("Expected status 200 got", w.Code)
+		}
+
+		if len(feed.Entries) != 1 || feed.Entries[0].Title != "v1" {
+			t.Error("Expected an entry for v1, got", feed.Entries)
+		}
+	})
+
+	t.Run("user", func(t *testing.T) {
+		params := map[string]string{"name": "alice"}
+
+		/* Anonymous readers only see the public repository, while alice also sees her private repository */
+		for user, want := range map[string][]string{
+			"": {"pub: Files"}, "alice": {"pub: Files", "proj: Files", "proj: v1"},
+		} {
+			w, feed := serveFeed(t, repo.HandleUserAtom, "/user/alice/activity.atom", params, user, tokens[user])
+			if w.Code != http.StatusOK {
+				t.Fatal("Expected status 200 got", w.Code)
+			}
+
+			var titles []string
+			for _, e := range feed.Entries {
+				titles = append(titles, e.Title)
+			}
+
+			for _, title := range want {
+				if !slices.Contains(titles, title) {
+					t.Error("Expected", title, "for", user, "got", titles)
+				}
+			}
+
+			if len(titles) != len(want) {
+				t.Error("Expected", want, "for", user, "got", titles)
+			}
+		}
+	})
+}
@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"html/template"
 	"io"
-	"log"
 	"net/http"
 	"path"
 	"strings"
@@ -25,11 +24,12 @@ import (
 func HandleFile(w http.ResponseWriter, r *http.Request) {
 	auth, user, err := goit.Auth(w, r, true)
 	if err != nil {
-		log.Println("[admin]", err.Error())
+		util.Errorln("[admin]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 	}
 
 	tpath := chi.URLParam(r, "*")
+	rev := r.URL.Query().Get("ref")
 
 	repo, err := goit.GetRepoByName(chi.URLParam(r, "repo"))
 	if err != nil {
@@ -45,6 +45,9 @@ func HandleFile(w http.ResponseWriter, r *http.Request) {
 		Title, Path, LineC, Size, Mode string
 		Lines                          []string
 		HtmlBody, HtmlPath, BodyCss    template.HTML
+
+		IsLfs, LfsStored bool
+		LfsSize          string
 	}{
 		Title:        repo.Name + " - " + tpath,
 		HeaderFields: GetHeaderFields(auth, user, repo, r.Host),
@@ -52,31 +55,37 @@ func HandleFile(w http.ResponseWriter, r *http.Request) {
 
 	gr, err := git.PlainOpen(goit.RepoPath(repo.Name, true))
 	if err != nil {
-		log.Println("[/repo/file]", err.Error())
+		util.Errorln("[/repo/file]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
 
-	ref, err := gr.Head()
+	if err := data.setRefs(gr, rev); err != nil {
+		util.Errorln("[/repo/file]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	ref, err := goit.ResolveRef(gr, rev)
 	if errors.Is(err, plumbing.ErrReferenceNotFound) {
 		goit.HttpError(w, http.StatusNotFound)
 		return
 	} else if err != nil {
-		log.Println("[/repo/file]", err.Error())
+		util.Errorln("[/repo/file]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
 
 	if readme, _ := findPattern(gr, ref, readmePattern); readme != "" {
-		data.Readme = path.Join("/", repo.Name, "file", readme)
+		data.Readme = path.Join("/", repo.Name, "file", readme) + refQuery(rev)
 	}
 	if licence, _ := findPattern(gr, ref, licencePattern); licence != "" {
-		data.Licence = path.Join("/", repo.Name, "file", licence)
+		data.Licence = path.Join("/", repo.Name, "file", licence) + refQuery(rev)
 	}
 
 	commit, err := gr.CommitObject(ref.Hash())
 	if err != nil {
-		log.Println("[/repo/file]", err.Error())
+		util.Errorln("[/repo/file]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
@@ -86,7 +95,7 @@ func HandleFile(w http.ResponseWriter, r *http.Request) {
 		goit.HttpError(w, http.StatusNotFound)
 		return
 	} else if err != nil {
-		log.Println("[/repo/file]", err.Error())
+		util.Errorln("[/repo/file]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
@@ -95,27 +104,32 @@ func HandleFile(w http.ResponseWriter, r *http.Request) {
 	data.Path = file.Name
 	data.Size = humanize.IBytes(uint64(file.Size))
 
+	if p, ok := goit.GetLfsPointer(file); ok {
+		data.IsLfs, data.LfsStored = true, goit.LfsExists(repo.Id, p)
+		data.LfsSize = humanize.IBytes(uint64(p.Size))
+	}
+
 	parts := strings.Split(file.Name, "/")
-	htmlPath := "<b style=\"padding-left: 0.4rem;\"><a href=\"/" + repo.Name + "/tree\">" + repo.Name + "</a></b>/"
+	htmlPath := "<b style=\"padding-left: 0.4rem;\"><a href=\"/" + repo.Name + "/tree" + refQuery(rev) + "\">" + repo.Name + "</a></b>/"
 	dirPath := ""
 
 	for i := 0; i < len(parts)-1; i += 1 {
 		dirPath = path.Join(dirPath, parts[i])
-		htmlPath += "<a href=\"/" + repo.Name + "/tree/" + dirPath + "\">" + parts[i] + "</a>/"
+		htmlPath += "<a href=\"/" + repo.Name + "/tree/" + dirPath + refQuery(rev) + "\">" + parts[i] + "</a>/"
 	}
 	htmlPath += parts[len(parts)-1]
 
 	data.HtmlPath = template.HTML(htmlPath)
 
 	if rc, err := file.Blob.Reader(); err != nil {
-		log.Println("[/repo/file]", err.Error())
+		util.Errorln("[/repo/file]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	} else {
 		buf := make([]byte, min(file.Size, 512))
 
 		if _, err := rc.Read(buf); err != nil {
-			log.Println("[/repo/file]", err.Error())
+			util.Errorln("[/repo/file]", err.Error())
 			goit.HttpError(w, http.StatusInternalServerError)
 			return
 		}
@@ -123,7 +137,7 @@ func HandleFile(w http.ResponseWriter, r *http.Request) {
 		if strings.HasPrefix(http.DetectContentType(buf), "text") {
 			buf2 := make([]byte, min(file.Size-int64(len(buf)), (10*1024*1024)-int64(len(buf))))
 			if _, err := rc.Read(buf2); err != nil && !errors.Is(err, io.EOF) {
-				log.Println("[/repo/file]", err.Error())
+				util.Errorln("[/repo/file]", err.Error())
 				goit.HttpError(w, http.StatusInternalServerError)
 				return
 			}
@@ -131,7 +145,7 @@ func HandleFile(w http.ResponseWriter, r *http.Request) {
 			body := string(append(buf, buf2...))
 			buf, css, err := Highlight(file.Name, body)
 			if err != nil {
-				log.Println("[/repo/file]", err.Error())
+				util.Errorln("[/repo/file]", err.Error())
 				goit.HttpError(w, http.StatusInternalServerError)
 				return
 			}
@@ -147,6 +161,6 @@ func HandleFile(w http.ResponseWriter, r *http.Request) {
 	data.LineC = fmt.Sprint(len(data.Lines), " lines")
 
 	if err := goit.Tmpl.ExecuteTemplate(w, "repo/file", data); err != nil {
-		log.Println("[/repo/file]", err.Error())
+		util.Errorln("[/repo/file]", err.Error())
 	}
 }
@@ -6,8 +6,8 @@ package repo
 import (
 	"errors"
 	"fmt"
+	"html/template"
 	"io"
-	"log"
 	"net/http"
 	"path/filepath"
 	"strconv"
@@ -26,12 +26,13 @@ const PAGE = 100
 func HandleLog(w http.ResponseWriter, r *http.Request) {
 	auth, user, err := goit.Auth(w, r, true)
 	if err != nil {
-		log.Println("[repo/log]", err.Error())
+		util.Errorln("[repo/log]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
 
 	tpath := chi.URLParam(r, "*")
+	rev := r.URL.Query().Get("ref")
 
 	repo, err := goit.GetRepoByName(chi.URLParam(r, "repo"))
 	if err != nil {
@@ -58,6 +59,7 @@ func HandleLog(w http.ResponseWriter, r *http.Request) {
 		Title                        string
 		Commits                      []row
 		Page, PrevOffset, NextOffset int64
+		HtmlReadme                   template.HTML
 	}{
 		Title:        repo.Name + " - Log",
 		HeaderFields: GetHeaderFields(auth, user, repo, r.Host),
@@ -69,26 +71,42 @@ func HandleLog(w http.ResponseWriter, r *http.Request) {
 
 	gr, err := git.PlainOpen(goit.RepoPath(repo.Name, true))
 	if err != nil {
-		log.Println("[/repo/log]", err.Error())
+		util.Errorln("[/repo/log]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
 
-	ref, err := gr.Head()
-	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+	if err := data.setRefs(gr, rev); err != nil {
+		util.Errorln("[/repo/log]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	ref, err := goit.ResolveRef(gr, rev)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) && rev != "" {
+		goit.HttpError(w, http.StatusNotFound)
+		return
+	} else if errors.Is(err, plumbing.ErrReferenceNotFound) {
 		data.NextOffset = 0
 		goto execute
 	} else if err != nil {
-		log.Println("[/repo/log]", err.Error())
+		util.Errorln("[/repo/log]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
 
 	if readme, _ := findPattern(gr, ref, readmePattern); readme != "" {
-		data.Readme = filepath.Join("/", repo.Name, "file", readme)
+		data.Readme = filepath.Join("/", repo.Name, "file", readme) + refQuery(rev)
+
+		/* Render the README on the landing page */
+		if tpath == "" && offset == 0 {
+			if data.HtmlReadme, err = renderReadme(gr, ref, repo.Name, rev, readme); err != nil {
+				util.Errorln("[/repo/log]", err.Error())
+			}
+		}
 	}
 	if licence, _ := findPattern(gr, ref, licencePattern); licence != "" {
-		data.Licence = filepath.Join("/", repo.Name, "file", licence)
+		data.Licence = filepath.Join("/", repo.Name, "file", licence) + refQuery(rev)
 	}
 
 	if iter, err := gr.Log(&git.LogOptions{
@@ -96,7 +114,7 @@ func HandleLog(w http.ResponseWriter, r *http.Request) {
 			return tpath == "" || s == tpath || strings.HasPrefix(s, tpath+"/")
 		},
 	}); err != nil {
-		log.Println("[/repo/log]", err.Error())
+		util.Errorln("[/repo/log]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	} else {
@@ -107,7 +125,7 @@ func HandleLog(w http.ResponseWriter, r *http.Request) {
 					goto execute
 				}
 
-				log.Println("[/repo/log]", err.Error())
+				util.Errorln("[/repo/log]", err.Error())
 				goit.HttpError(w, http.StatusInternalServerError)
 				return
 			}
@@ -119,7 +137,7 @@ func HandleLog(w http.ResponseWriter, r *http.Request) {
 				data.NextOffset = 0
 				goto execute
 			} else if err != nil {
-				log.Println("[/repo/log]", err.Error())
+				util.Errorln("[/repo/log]", err.Error())
 				goit.HttpError(w, http.StatusInternalServerError)
 				return
 			}
@@ -127,7 +145,7 @@ func HandleLog(w http.ResponseWriter, r *http.Request) {
 			var files, additions, deletions int
 
 			if stats, err := goit.DiffStats(c); err != nil {
-				log.Println("[/repo/log]", err.Error())
+				util.Errorln("[/repo/log]", err.Error())
 			} else {
 				files = len(stats)
 				for _, s := range stats {
@@ -150,6 +168,6 @@ func HandleLog(w http.ResponseWriter, r *http.Request) {
 
 execute:
 	if err := goit.Tmpl.ExecuteTemplate(w, "repo/log", data); err != nil {
-		log.Println("[/repo/log]", err.Error())
+		util.Errorln("[/repo/log]", err.Error())
 	}
 }
@@ -4,12 +4,15 @@
 package repo
 
 import (
+	"bufio"
 	"errors"
+	"fmt"
 	"io"
-	"log"
 	"net/http"
+	"strings"
 
 	"github.com/Jamozed/Goit/src/goit"
+	"github.com/Jamozed/Goit/src/util"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
@@ -19,7 +22,7 @@ import (
 func HandleRaw(w http.ResponseWriter, r *http.Request) {
 	auth, user, err := goit.Auth(w, r, true)
 	if err != nil {
-		log.Println("[repo/raw]", err.Error())
+		util.Errorln("[repo/raw]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
@@ -37,24 +40,24 @@ func HandleRaw(w http.ResponseWriter, r *http.Request) {
 
 	gr, err := git.PlainOpen(goit.RepoPath(repo.Name, true))
 	if err != nil {
-		log.Println("[/repo/file]", err.Error())
+		util.Errorln("[/repo/file]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
 
-	ref, err := gr.Head()
+	ref, err := goit.ResolveRef(gr, r.URL.Query().Get("ref"))
 	if errors.Is(err, plumbing.ErrReferenceNotFound) {
 		goit.HttpError(w, http.StatusNotFound)
 		return
 	} else if err != nil {
-		log.Println("[/repo/file]", err.Error())
+		util.Errorln("[/repo/file]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
 
 	commit, err := gr.CommitObject(ref.Hash())
 	if err != nil {
-		log.Println("[/repo/file]", err.Error())
+		util.Errorln("[/repo/file]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
@@ -64,27 +67,31 @@ func HandleRaw(w http.ResponseWriter, r *http.Request) {
 		goit.HttpError(w, http.StatusNotFound)
 		return
 	} else if err != nil {
-		log.Println("[/repo/file]", err.Error())
+		util.Errorln("[/repo/file]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
 
-	if rc, err := file.Blob.Reader(); err != nil {
-		log.Println("[/repo/file]", err.Error())
+	if rc, size, err := goit.OpenFile(repo, file); err != nil {
+		util.Errorln("[/repo/file]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	} else {
-		buf := make([]byte, min(file.Size, (10*1024*1024)))
-		if _, err := rc.Read(buf); err != nil && !errors.Is(err, io.EOF) {
-			log.Println("[/repo/file]", err.Error())
-			goit.HttpError(w, http.StatusInternalServerError)
-			return
+		/* Serve text as plain text, so that files are never rendered as HTML in the origin of the server */
+		br := bufio.NewReader(rc)
+		head, _ := br.Peek(512)
+
+		ctype := http.DetectContentType(head)
+		if strings.HasPrefix(ctype, "text/") {
+			ctype = "text/plain; charset=utf-8"
 		}
 
-		if _, err := w.Write(buf); err != nil {
-			log.Println("[/repo/file]", err.Error())
-			goit.HttpError(w, http.StatusInternalServerError)
-			return
+		w.Header().Set("Content-Type", ctype)
+		w.Header().Set("Content-Length", fmt.Sprint(size))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+
+		if _, err := io.Copy(w, br); err != nil {
+			util.Errorln("[/repo/file]", err.Error())
 		}
 
 		rc.Close()
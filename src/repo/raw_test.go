@@ -0,0 +1,168 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package repo_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Jamozed/Goit/src/cron"
+	"github.com/Jamozed/Goit/src/goit"
+	"github.com/Jamozed/Goit/src/repo"
+	"github.com/go-chi/chi/v5"
+)
+
+/*
+Open an empty instance in a temporary data path with the user alice, and create a repository of hers named "proj" with
+a commit of files on master, returning the repository and the commit hash.
+*/
+func newTestRepo(t *testing.T, vis goit.Visibility, files map[string]string) (*goit.Repo, string) {
+	t.Helper()
+
+	goit.Conf.DataPath = t.TempDir()
+	goit.Cron = cron.New()
+
+	if err := goit.OpenDatabase(filepath.Join(goit.Conf.DataPath, "goit.db")); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := goit.CreateUser(goit.User{Name: "alice", Pass: []byte{}, Salt: []byte{}}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	rid, err := goit.CreateRepo(goit.Repo{OwnerId: 1, Name: "proj", DefaultBranch: "master", Visibility: vis})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	r, err := goit.GetRepo(rid)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	hash := testCommit(t, "", files)
+	testGit(t, "", "update-ref", "refs/heads/master", hash)
+	return r, hash
+}
+
+/* Run a Git command in the "proj" repository with an input, returning its output. */
+func testGit(t *testing.T, in string, args ...string) string {
+	t.Helper()
+
+	c := exec.Command("git", append([]string{"--git-dir", goit.RepoPath("proj", true)}, args...)...)
+	c.Stdin = strings.NewReader(in)
+	c.Env = append(os.Environ(), "GIT_AUTHOR_NAME=Goit", "GIT_AUTHOR_EMAIL=goit@example.com",
+		"GIT_COMMITTER_NAME=Goit", "GIT_COMMITTER_EMAIL=goit@example.com")
+
+	out, err := c.Output()
+	if err != nil {
+		t.Fatal("git", args, err.Error())
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+/* Create a commit of files in the "proj" repository without updating any ref, returning its hash. */
+func testCommit(t *testing.T, parent string, files map[string]string) string {
+	t.Helper()
+
+	var tree []string
+	for name, content := range files {
+		tree = append(tree, "100644 blob "+testGit(t, content, "hash-object", "-w", "--stdin")+"\t"+name)
+	}
+
+	args := []string{"commit-tree", testGit(t, strings.Join(tree, "\n")+"\n", "mktree"), "-m", "Files"}
+	if parent != "" {
+		args = append(args, "-p", parent)
+	}
+
+	return testGit(t, "", args...)
+}
+
+/* Create a request with chi route parameters, as the repository router does. */
+func newRequest(method, target string, params map[string]string) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+
+	rctx := chi.NewRouteContext()
+	for k, v := range params {
+		rctx.URLParams.Add(k, v)
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+}
+
+func TestHandleRaw(t *testing.T) {
+	object := strings.Repeat("large object\n", 1000)
+	sum := sha256.Sum256([]byte(object))
+	oid := hex.EncodeToString(sum[:])
+
+	pointer := fmt.Sprint("version https://git-lfs.github.com/spec/v1\noid sha256:", oid, "\nsize ", len(object), "\n")
+	html, png := "<html><script>alert(1)</script></html>", "\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"
+
+	r, _ := newTestRepo(t, goit.Public, map[string]string{
+		"index.html": html,
+		"image.png":  png,
+		"large.txt":  pointer,
+	})
+
+	if err := os.MkdirAll(filepath.Dir(goit.LfsPath(r.Id, oid)), 0o777); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := os.WriteFile(goit.LfsPath(r.Id, oid), []byte(object), 0o666); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tests := []struct {
+		path, ctype string
+		size        int
+	}{
+		{"index.html", "text/plain; charset=utf-8", len(html)},
+		{"image.png", "image/png", len(png)},
+		{"large.txt", "text/plain; charset=utf-8", len(object)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			repo.HandleRaw(w, newRequest(http.MethodGet, "/proj/raw/"+tt.path, map[string]string{
+				"repo": "proj", "*": tt.path,
+			}))
+
+			if w.Code != http.StatusOK {
+				t.Fatal("Expected status 200 got", w.Code)
+			}
+
+			if ct := w.Header().Get("Content-Type"); ct != tt.ctype {
+				t.Error("Expected Content-Type", tt.ctype, "got", ct)
+			}
+
+			if cl := w.Header().Get("Content-Length"); cl != fmt.Sprint(tt.size) || w.Body.Len() != tt.size {
+				t.Error("Expected", tt.size, "bytes got", cl, w.Body.Len())
+			}
+
+			if w.Header().Get("X-Content-Type-Options") != "nosniff" {
+				t.Error("Expected content type sniffing to be disabled")
+			}
+		})
+	}
+
+	t.Run("not found", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		repo.HandleRaw(w, newRequest(http.MethodGet, "/proj/raw/none", map[string]string{"repo": "proj", "*": "none"}))
+
+		if w.Code != http.StatusNotFound {
+			t.Error("Expected status 404 got", w.Code)
+		}
+	})
+}
@@ -0,0 +1,133 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package repo
+
+import (
+	"bytes"
+	"html"
+	"html/template"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+var readmePolicy = bluemonday.UGCPolicy()
+
+/*
+Render a README file at the root of a reference. Markdown files are rendered to sanitised HTML with relative links and
+images resolved against the repository, and other files are shown as preformatted text.
+*/
+func renderReadme(gr *git.Repository, ref *plumbing.Reference, repo, rev, name string) (template.HTML, error) {
+	commit, err := gr.CommitObject(ref.Hash())
+	if err != nil {
+		return "", err
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return "", err
+	}
+
+	file, err := tree.File(name)
+	if err != nil {
+		return "", err
+	}
+
+	rc, err := file.Blob.Reader()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	buf, err := io.ReadAll(io.LimitReader(rc, 10*1024*1024))
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.EqualFold(path.Ext(name), ".md") {
+		return template.HTML("<pre>" + html.EscapeString(string(buf)) + "</pre>"), nil
+	}
+
+	md := goldmark.New(
+		goldmark.WithExtensions(extension.GFM),
+		goldmark.WithParserOptions(parser.WithAutoHeadingID(), parser.WithASTTransformers(
+			util.Prioritized(&linkTransformer{repo: repo, rev: rev, tree: tree}, 100),
+		)),
+	)
+
+	var out bytes.Buffer
+	if err := md.Convert(buf, &out); err != nil {
+		return "", err
+	}
+
+	return template.HTML(readmePolicy.SanitizeBytes(out.Bytes())), nil
+}
+
+/* Rewrite relative link and image destinations to point at the file, tree, and raw views of a repository. */
+type linkTransformer struct {
+	repo, rev string
+	tree      *object.Tree
+}
+
+func (t *linkTransformer) Transform(node *ast.Document, reader text.Reader, pc parser.Context) {
+	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		switch n := n.(type) {
+		case *ast.Link:
+			n.Destination = t.resolve(n.Destination, false)
+		case *ast.Image:
+			n.Destination = t.resolve(n.Destination, true)
+		}
+
+		return ast.WalkContinue, nil
+	})
+}
+
+/*
+Resolve a destination relative to the root of the repository. Destinations with a scheme or host, absolute paths, and
+fragments of the README itself are left unchanged, and any query is kept.
+*/
+func (t *linkTransformer) resolve(dest []byte, raw bool) []byte {
+	u, err := url.Parse(string(dest))
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" || strings.HasPrefix(u.Path, "/") {
+		return dest
+	}
+
+	/* Paths are relative to the README, which is always at the root of the repository */
+	p := strings.TrimPrefix(path.Clean("/"+u.Path), "/")
+
+	view := "file"
+	if raw {
+		view = "raw"
+	} else if _, err := t.tree.Tree(p); err == nil || p == "" {
+		view = "tree"
+	}
+
+	s := path.Join("/", t.repo, view, p) + refQuery(t.rev)
+	if u.RawQuery != "" && t.rev != "" {
+		s += "&" + u.RawQuery
+	} else if u.RawQuery != "" {
+		s += "?" + u.RawQuery
+	}
+	if u.Fragment != "" {
+		s += "#" + u.Fragment
+	}
+
+	return []byte(s)
+}
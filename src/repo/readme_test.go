@@ -0,0 +1,81 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package repo_test
+
+import (
+	"testing"
+
+	"github.com/Jamozed/Goit/src/repo"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+/* Store a tree with the entries of a repository containing a README and a "docs" directory. */
+func testTree(t *testing.T) *object.Tree {
+	t.Helper()
+	st := memory.NewStorage()
+
+	store := func(tree *object.Tree) plumbing.Hash {
+		obj := st.NewEncodedObject()
+		if err := tree.Encode(obj); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		h, err := st.SetEncodedObject(obj)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		return h
+	}
+
+	docs := store(&object.Tree{Entries: []object.TreeEntry{{Name: "a.md", Mode: filemode.Regular}}})
+	root := store(&object.Tree{Entries: []object.TreeEntry{
+		{Name: "README.md", Mode: filemode.Regular}, {Name: "docs", Mode: filemode.Dir, Hash: docs},
+	}})
+
+	tree, err := object.GetTree(st, root)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return tree
+}
+
+func TestResolveLink(t *testing.T) {
+	tree := testTree(t)
+
+	tests := []struct {
+		name, rev, dest string
+		raw             bool
+		want            string
+	}{
+		{"file", "", "docs/a.md", false, "/proj/file/docs/a.md"},
+		{"dot file", "", "./docs/a.md", false, "/proj/file/docs/a.md"},
+		{"directory", "", "docs", false, "/proj/tree/docs"},
+		{"root", "", ".", false, "/proj/tree"},
+		{"parent", "", "../../docs/a.md", false, "/proj/file/docs/a.md"},
+		{"image", "", "docs/a.png", true, "/proj/raw/docs/a.png"},
+		{"revision", "dev", "docs/a.md", false, "/proj/file/docs/a.md?ref=dev"},
+		{"fragment", "", "docs/a.md#usage", false, "/proj/file/docs/a.md#usage"},
+		{"query", "", "docs/a.md?plain=1", false, "/proj/file/docs/a.md?plain=1"},
+		{"query and revision", "dev", "docs/a.md?plain=1#usage", false, "/proj/file/docs/a.md?ref=dev&plain=1#usage"},
+		{"absolute", "", "/other/file/a.md", false, "/other/file/a.md"},
+		{"absolute image", "", "/static/favicon.png", true, "/static/favicon.png"},
+		{"url", "", "https://example.com/a.md", false, "https://example.com/a.md"},
+		{"scheme relative", "", "//example.com/a.md", false, "//example.com/a.md"},
+		{"mailto", "", "mailto:alice@example.com", false, "mailto:alice@example.com"},
+		{"anchor", "", "#usage", false, "#usage"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := repo.ResolveLink(tree, "proj", tt.rev, tt.dest, tt.raw); got != tt.want {
+				t.Error("Expected", tt.want, "got", got)
+			}
+		})
+	}
+}
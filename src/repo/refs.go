@@ -5,7 +5,7 @@ package repo
 
 import (
 	"errors"
-	"log"
+	"fmt"
 	"net/http"
 	"path/filepath"
 	"slices"
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/Jamozed/Goit/src/goit"
+	"github.com/Jamozed/Goit/src/util"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
@@ -22,7 +23,7 @@ import (
 func HandleRefs(w http.ResponseWriter, r *http.Request) {
 	auth, user, err := goit.Auth(w, r, true)
 	if err != nil {
-		log.Println("[admin]", err.Error())
+		util.Errorln("[admin]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 	}
 
@@ -38,19 +39,34 @@ func HandleRefs(w http.ResponseWriter, r *http.Request) {
 	type row struct {
 		Name, Hash, Message, Author, LastCommit string
 		Commits                                 uint64
+		IsProtected                             bool
 	}
 	data := struct {
 		HeaderFields
-		Title          string
+		Title, Default string
 		Branches, Tags []row
+		Protected      []protectedRow
 	}{
 		Title:        repo.Name + " - References",
 		HeaderFields: GetHeaderFields(auth, user, repo, r.Host),
 	}
 
+	protected, err := goit.GetProtectedBranches(repo.Id)
+	if err != nil {
+		util.Errorln("[/repo/refs]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	if data.Protected, err = protectedRows(protected); err != nil {
+		util.Errorln("[/repo/refs]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
 	gr, err := git.PlainOpen(goit.RepoPath(repo.Name, true))
 	if err != nil {
-		log.Println("[/repo/refs]", err.Error())
+		util.Errorln("[/repo/refs]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
@@ -58,11 +74,13 @@ func HandleRefs(w http.ResponseWriter, r *http.Request) {
 	ref, err := gr.Head()
 	if err != nil {
 		if !errors.Is(err, plumbing.ErrReferenceNotFound) {
-			log.Println("[/repo/log]", err.Error())
+			util.Errorln("[/repo/log]", err.Error())
 			goit.HttpError(w, http.StatusInternalServerError)
 			return
 		}
 	} else {
+		data.Default = ref.Name().Short()
+
 		if readme, _ := findPattern(gr, ref, readmePattern); readme != "" {
 			data.Readme = filepath.Join("/", repo.Name, "file", readme)
 		}
@@ -72,7 +90,7 @@ func HandleRefs(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if iter, err := gr.Branches(); err != nil {
-		log.Println("[/repo/refs]", err.Error())
+		util.Errorln("[/repo/refs]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	} else if err := iter.ForEach(func(r *plumbing.Reference) error {
@@ -89,17 +107,20 @@ func HandleRefs(w http.ResponseWriter, r *http.Request) {
 		data.Branches = append(data.Branches, row{
 			Name: r.Name().Short(), Hash: r.Hash().String(), Message: strings.SplitN(commit.Message, "\n", 2)[0],
 			Author: commit.Author.Name, LastCommit: commit.Author.When.UTC().Format(time.DateTime), Commits: commits,
+			IsProtected: slices.ContainsFunc(protected, func(b goit.ProtectedBranch) bool {
+				return b.Matches(r.Name().Short())
+			}),
 		})
 
 		return nil
 	}); err != nil {
-		log.Println("[/repo/refs]", err.Error())
+		util.Errorln("[/repo/refs]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
 
 	if iter, err := gr.Tags(); err != nil {
-		log.Println("[/repo/refs]", err.Error())
+		util.Errorln("[/repo/refs]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	} else if err := iter.ForEach(func(r *plumbing.Reference) error {
@@ -128,7 +149,7 @@ func HandleRefs(w http.ResponseWriter, r *http.Request) {
 
 		return nil
 	}); err != nil {
-		log.Println("[/repo/refs]", err.Error())
+		util.Errorln("[/repo/refs]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
@@ -136,6 +157,30 @@ func HandleRefs(w http.ResponseWriter, r *http.Request) {
 	slices.Reverse(data.Tags)
 
 	if err := goit.Tmpl.ExecuteTemplate(w, "repo/refs", data); err != nil {
-		log.Println("[/repo/refs]", err.Error())
+		util.Errorln("[/repo/refs]", err.Error())
 	}
 }
+
+type protectedRow struct{ Id, Pattern, Users string }
+
+/* Format protected branches for display, with the names of the users allowed to push to them. */
+func protectedRows(protected []goit.ProtectedBranch) ([]protectedRow, error) {
+	rows := []protectedRow{}
+	for _, b := range protected {
+		var names []string
+		for _, uid := range b.Users {
+			if u, err := goit.GetUser(uid); err != nil {
+				return nil, err
+			} else if u != nil {
+				names = append(names, u.Name)
+			}
+		}
+
+		rows = append(rows, protectedRow{
+			Id: fmt.Sprint(b.Id), Pattern: b.Pattern, Users: util.If(len(names) == 0, "anyone with write access",
+				strings.Join(names, ", ")),
+		})
+	}
+
+	return rows, nil
+}
@@ -0,0 +1,137 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package repo_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Jamozed/Goit/src/goit"
+	"github.com/Jamozed/Goit/src/repo"
+	"github.com/go-git/go-git/v5"
+)
+
+func TestRefQuery(t *testing.T) {
+	for rev, want := range map[string]string{
+		"":          "",
+		"master":    "?ref=master",
+		"feature/x": "?ref=feature%2Fx",
+		"a&b=c#d":   "?ref=a%26b%3Dc%23d",
+	} {
+		if got := repo.RefQuery(rev); got != want {
+			t.Errorf("Expected %q for %q got %q", want, rev, got)
+		}
+	}
+}
+
+func TestRefs(t *testing.T) {
+	_, first := newTestRepo(t, goit.Public, map[string]string{"README.md": "Readme", "a.txt": "first content"})
+	second := testCommit(t, first, map[string]string{"README.md": "Readme", "a.txt": "second content"})
+
+	/* A branch and a lightweight tag at the second commit, and an annotated tag at the first */
+	testGit(t, "", "update-ref", "refs/heads/feature/x", second)
+	testGit(t, "", "tag", "v1", second)
+	testGit(t, "", "tag", "-a", "v2", "-m", "Version 2", first)
+
+	gr, err := git.PlainOpen(goit.RepoPath("proj", true))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	t.Run("resolve", func(t *testing.T) {
+		for rev, want := range map[string]string{
+			"": first, "master": first, "feature/x": second, "v1": second, "v2": first, second[:7]: second,
+			first: first,
+		} {
+			if ref, err := goit.ResolveRef(gr, rev); err != nil {
+				t.Error("Expected", rev, "to resolve, got", err)
+			} else if ref.Hash().String() != want {
+				t.Error("Expected", rev, "to resolve to", want, "got", ref.Hash())
+			}
+		}
+
+		if _, err := goit.ResolveRef(gr, "unknown"); err == nil {
+			t.Error("Expected an unknown ref to not resolve")
+		}
+	})
+
+	t.Run("set refs", func(t *testing.T) {
+		refs, err := repo.SetRefs(gr, "")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		/* The HEAD branch is listed first */
+		if len(refs) != 4 || refs[0] != "master" {
+			t.Error("Expected master and three other refs, got", refs)
+		}
+
+		for _, rev := range []string{"feature/x", "v1", "v2"} {
+			if !slices.Contains(refs, rev) {
+				t.Error("Expected", rev, "to be listed, got", refs)
+			}
+		}
+
+		/* A revision that is not a branch or tag is listed first, and a branch is not listed twice */
+		if refs, err := repo.SetRefs(gr, second[:7]); err != nil {
+			t.Fatal(err.Error())
+		} else if len(refs) != 5 || refs[0] != second[:7] {
+			t.Error("Expected", second[:7], "to be listed first, got", refs)
+		}
+
+		if refs, err := repo.SetRefs(gr, "feature/x"); err != nil {
+			t.Fatal(err.Error())
+		} else if len(refs) != 4 {
+			t.Error("Expected feature/x to be listed once, got", refs)
+		}
+	})
+
+	tests := []struct {
+		name, ref string
+		status    int
+		content   string
+	}{
+		{"head", "", http.StatusOK, "first content"},
+		{"branch", "feature/x", http.StatusOK, "second content"},
+		{"tag", "v1", http.StatusOK, "second content"},
+		{"annotated tag", "v2", http.StatusOK, "first content"},
+		{"short hash", second[:7], http.StatusOK, "second content"},
+		{"unknown", "unknown", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run("file "+tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			repo.HandleFile(w, newRequest(http.MethodGet, "/proj/file/a.txt"+repo.RefQuery(tt.ref), map[string]string{
+				"repo": "proj", "*": "a.txt",
+			}))
+
+			if w.Code != tt.status {
+				t.Fatal("Expected status", tt.status, "got", w.Code)
+			} else if tt.status == http.StatusOK && !strings.Contains(w.Body.String(), tt.content) {
+				t.Errorf("Expected %q to be shown", tt.content)
+			}
+		})
+
+		t.Run("tree "+tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			repo.HandleTree(w, newRequest(http.MethodGet, "/proj/tree"+repo.RefQuery(tt.ref), map[string]string{
+				"repo": "proj",
+			}))
+
+			if w.Code != tt.status {
+				t.Fatal("Expected status", tt.status, "got", w.Code)
+			}
+
+			/* Links preserve the revision being viewed */
+			if link := "/proj/file/README.md" + repo.RefQuery(tt.ref); tt.status == http.StatusOK &&
+				!strings.Contains(w.Body.String(), `href="`+link+`"`) {
+				t.Error("Expected a link to", link)
+			}
+		})
+	}
+}
@@ -4,7 +4,10 @@
 package repo
 
 import (
+	"errors"
+	"net/url"
 	"regexp"
+	"slices"
 
 	"github.com/Jamozed/Goit/src/goit"
 	"github.com/Jamozed/Goit/src/util"
@@ -16,17 +19,70 @@ type HeaderFields struct {
 	Name, Description, Url  string
 	Readme, Licence, Mirror string
 	Editable                bool
+
+	Ref  string
+	Refs []string
 }
 
 func GetHeaderFields(auth bool, user *goit.User, repo *goit.Repo, host string) HeaderFields {
 	return HeaderFields{
 		Name: repo.Name, Description: repo.Description,
 		Url:      util.If(goit.Conf.UsesHttps, "https://", "http://") + host + "/" + repo.Name,
-		Editable: (auth && repo.OwnerId == user.Id),
+		Editable: goit.RepoAccess(repo, auth, user) >= goit.AccessAdmin,
 		Mirror:   util.If(repo.IsMirror, repo.Upstream, ""),
 	}
 }
 
+/* Populate the reference switcher with the branches and tags of a repository, with the HEAD branch first. */
+func (h *HeaderFields) setRefs(gr *git.Repository, rev string) error {
+	h.Ref = rev
+
+	head, err := gr.Head()
+	if err != nil && !errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return err
+	}
+
+	refs, err := gr.References()
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	if err := refs.ForEach(func(r *plumbing.Reference) error {
+		if !r.Name().IsBranch() && !r.Name().IsTag() {
+			return nil
+		}
+
+		if head != nil && r.Name() == head.Name() {
+			h.Refs = append(h.Refs, r.Name().Short())
+		} else {
+			names = append(names, r.Name().Short())
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	h.Refs = append(h.Refs, names...)
+
+	/* Include revisions that are not a branch or tag, such as commit hashes */
+	if rev != "" && !slices.Contains(h.Refs, rev) {
+		h.Refs = append([]string{rev}, h.Refs...)
+	}
+
+	return nil
+}
+
+/* Return a query string that preserves a revision across links, or nothing for HEAD. */
+func refQuery(rev string) string {
+	if rev == "" {
+		return ""
+	}
+
+	return "?ref=" + url.QueryEscape(rev)
+}
+
 var readmePattern = regexp.MustCompile(`(?i)^readme(?:\.?(?:md|txt))?$`)
 var licencePattern = regexp.MustCompile(`(?i)^licence(?:\.?(?:md|txt))?$`)
 
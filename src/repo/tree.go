@@ -6,7 +6,6 @@ package repo
 import (
 	"errors"
 	"html/template"
-	"log"
 	"net/http"
 	"path"
 	"sort"
@@ -24,11 +23,12 @@ import (
 func HandleTree(w http.ResponseWriter, r *http.Request) {
 	auth, user, err := goit.Auth(w, r, true)
 	if err != nil {
-		log.Println("[admin]", err.Error())
+		util.Errorln("[admin]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 	}
 
 	tpath := chi.URLParam(r, "*")
+	rev := r.URL.Query().Get("ref")
 
 	repo, err := goit.GetRepoByName(chi.URLParam(r, "repo"))
 	if err != nil {
@@ -48,18 +48,19 @@ func HandleTree(w http.ResponseWriter, r *http.Request) {
 		Title, Path, Size string
 		Files             []row
 		HtmlPath          template.HTML
+		HtmlReadme        template.HTML
 	}{
 		Title:        repo.Name + " - Tree",
 		HeaderFields: GetHeaderFields(auth, user, repo, r.Host),
 	}
 
 	parts := strings.Split(tpath, "/")
-	htmlPath := "<b style=\"padding-left: 0.4rem;\"><a href=\"/" + repo.Name + "/tree\">" + repo.Name + "</a></b>/"
+	htmlPath := "<b style=\"padding-left: 0.4rem;\"><a href=\"/" + repo.Name + "/tree" + refQuery(rev) + "\">" + repo.Name + "</a></b>/"
 	dirPath := ""
 
 	for i := 0; i < len(parts)-1; i += 1 {
 		dirPath = path.Join(dirPath, parts[i])
-		htmlPath += "<a href=\"/" + repo.Name + "/tree/" + dirPath + "\">" + parts[i] + "</a>/"
+		htmlPath += "<a href=\"/" + repo.Name + "/tree/" + dirPath + refQuery(rev) + "\">" + parts[i] + "</a>/"
 	}
 	htmlPath += parts[len(parts)-1]
 
@@ -68,35 +69,50 @@ func HandleTree(w http.ResponseWriter, r *http.Request) {
 
 	gr, err := git.PlainOpen(goit.RepoPath(repo.Name, true))
 	if err != nil {
-		log.Println("[/repo/tree]", err.Error())
+		util.Errorln("[/repo/tree]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 		return
 	}
 
-	if ref, err := gr.Head(); err != nil {
+	if err := data.setRefs(gr, rev); err != nil {
+		util.Errorln("[/repo/tree]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	if ref, err := goit.ResolveRef(gr, rev); err != nil {
 		if !errors.Is(err, plumbing.ErrReferenceNotFound) {
-			log.Println("[/repo/tree]", err.Error())
+			util.Errorln("[/repo/tree]", err.Error())
 			goit.HttpError(w, http.StatusInternalServerError)
 			return
+		} else if rev != "" {
+			goit.HttpError(w, http.StatusNotFound)
+			return
 		}
 	} else {
 		if readme, _ := findPattern(gr, ref, readmePattern); readme != "" {
-			data.Readme = path.Join("/", repo.Name, "file", readme)
+			data.Readme = path.Join("/", repo.Name, "file", readme) + refQuery(rev)
+
+			if tpath == "" {
+				if data.HtmlReadme, err = renderReadme(gr, ref, repo.Name, rev, readme); err != nil {
+					util.Errorln("[/repo/tree]", err.Error())
+				}
+			}
 		}
 		if licence, _ := findPattern(gr, ref, licencePattern); licence != "" {
-			data.Licence = path.Join("/", repo.Name, "file", licence)
+			data.Licence = path.Join("/", repo.Name, "file", licence) + refQuery(rev)
 		}
 
 		commit, err := gr.CommitObject(ref.Hash())
 		if err != nil {
-			log.Println("[/repo/tree]", err.Error())
+			util.Errorln("[/repo/tree]", err.Error())
 			goit.HttpError(w, http.StatusInternalServerError)
 			return
 		}
 
 		tree, err := commit.Tree()
 		if err != nil {
-			log.Println("[/repo/tree]", err.Error())
+			util.Errorln("[/repo/tree]", err.Error())
 			goit.HttpError(w, http.StatusInternalServerError)
 			return
 		}
@@ -111,7 +127,7 @@ func HandleTree(w http.ResponseWriter, r *http.Request) {
 				goit.HttpError(w, http.StatusNotFound)
 				return
 			} else if err != nil {
-				log.Println("[/repo/tree]", err.Error())
+				util.Errorln("[/repo/tree]", err.Error())
 				goit.HttpError(w, http.StatusInternalServerError)
 				return
 			}
@@ -143,7 +159,7 @@ func HandleTree(w http.ResponseWriter, r *http.Request) {
 				if !ok {
 					file, err := tree.File(v.Name)
 					if err != nil {
-						log.Println("[/repo/tree]", err.Error())
+						util.Errorln("[/repo/tree]", err.Error())
 						goit.HttpError(w, http.StatusInternalServerError)
 						return
 					}
@@ -168,7 +184,7 @@ func HandleTree(w http.ResponseWriter, r *http.Request) {
 				if !ok {
 					dirt, err := tree.Tree(v.Name)
 					if err != nil {
-						log.Println("[/repo/tree]", err.Error())
+						util.Errorln("[/repo/tree]", err.Error())
 						goit.HttpError(w, http.StatusInternalServerError)
 						return
 					}
@@ -178,7 +194,7 @@ func HandleTree(w http.ResponseWriter, r *http.Request) {
 						dirSize += uint64(f.Size)
 						return nil
 					}); err != nil {
-						log.Println("[/repo/tree]", err.Error())
+						util.Errorln("[/repo/tree]", err.Error())
 						goit.HttpError(w, http.StatusInternalServerError)
 						return
 					}
@@ -204,6 +220,6 @@ func HandleTree(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := goit.Tmpl.ExecuteTemplate(w, "repo/tree", data); err != nil {
-		log.Println("[/repo/tree]", err.Error())
+		util.Errorln("[/repo/tree]", err.Error())
 	}
 }
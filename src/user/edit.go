@@ -7,18 +7,18 @@ import (
 	"bytes"
 	"fmt"
 	"html/template"
-	"log"
 	"net/http"
 	"slices"
 
 	"github.com/Jamozed/Goit/src/goit"
+	"github.com/Jamozed/Goit/src/util"
 	"github.com/gorilla/csrf"
 )
 
 func HandleEdit(w http.ResponseWriter, r *http.Request) {
 	auth, user, err := goit.Auth(w, r, true)
 	if err != nil {
-		log.Println("[admin]", err.Error())
+		util.Errorln("[admin]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 	}
 
@@ -53,7 +53,7 @@ func HandleEdit(w http.ResponseWriter, r *http.Request) {
 			} else if slices.Contains(goit.Reserved, data.Form.Name) && user.Id != 0 || !goit.IsLegal(data.Form.Name) {
 				data.MessageA = "Username \"" + data.Form.Name + "\" is illegal"
 			} else if exists, err := goit.UserExists(data.Form.Name); err != nil {
-				log.Println("[/user/edit]", err.Error())
+				util.Errorln("[/user/edit]", err.Error())
 				goit.HttpError(w, http.StatusInternalServerError)
 				return
 			} else if exists && data.Form.Name != user.Name {
@@ -61,7 +61,7 @@ func HandleEdit(w http.ResponseWriter, r *http.Request) {
 			} else if err := goit.UpdateUser(user.Id, goit.User{
 				Name: data.Form.Name, FullName: data.Form.FullName, IsAdmin: user.IsAdmin,
 			}); err != nil {
-				log.Println("[/user/edit]", err.Error())
+				util.Errorln("[/user/edit]", err.Error())
 				goit.HttpError(w, http.StatusInternalServerError)
 				return
 			} else {
@@ -84,7 +84,7 @@ func HandleEdit(w http.ResponseWriter, r *http.Request) {
 			} else if !bytes.Equal(goit.Hash(password, user.Salt), user.Pass) {
 				data.MessageB = "Password incorrect"
 			} else if err := goit.UpdatePassword(user.Id, newPassword); err != nil {
-				log.Println("[/user/edit]", err.Error())
+				util.Errorln("[/user/edit]", err.Error())
 				goit.HttpError(w, http.StatusInternalServerError)
 				return
 			} else {
@@ -104,6 +104,6 @@ func HandleEdit(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := goit.Tmpl.ExecuteTemplate(w, "user/edit", data); err != nil {
-		log.Println("[/user/edit]", err.Error())
+		util.Errorln("[/user/edit]", err.Error())
 	}
 }
@@ -0,0 +1,109 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package user
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jamozed/Goit/src/goit"
+	"github.com/Jamozed/Goit/src/util"
+	"github.com/gorilla/csrf"
+)
+
+func HandleKeys(w http.ResponseWriter, r *http.Request) {
+	auth, user, err := goit.Auth(w, r, true)
+	if err != nil {
+		util.Errorln("[/user/keys]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	if !auth {
+		goit.HttpError(w, http.StatusUnauthorized)
+		return
+	}
+
+	type row struct{ Id, Name, Fingerprint, Created string }
+	data := struct {
+		Title, Message string
+		Name, Key      string
+		Keys           []row
+
+		CsrfField template.HTML
+	}{
+		Title: "User - SSH Keys",
+
+		CsrfField: csrf.TemplateField(r),
+	}
+
+	if r.Method == http.MethodPost {
+		switch r.FormValue("action") {
+		case "add":
+			data.Name = strings.TrimSpace(r.FormValue("name"))
+			data.Key = strings.TrimSpace(r.FormValue("key"))
+
+			key, kerr := goit.ParseSshKey(data.Key)
+			key.OwnerId = user.Id
+			if data.Name != "" {
+				key.Name = data.Name
+			}
+
+			if data.Key == "" {
+				data.Message = "Key cannot be empty"
+			} else if kerr != nil {
+				data.Message = "Key is invalid"
+			} else if len(key.Name) > 256 {
+				data.Message = "Name cannot exceed 256 characters"
+			} else if exists, err := goit.SshKeyExists(key.Key); err != nil {
+				util.Errorln("[/user/keys]", err.Error())
+				goit.HttpError(w, http.StatusInternalServerError)
+				return
+			} else if exists {
+				data.Message = "Key is already in use"
+			} else if err := goit.CreateSshKey(key); err != nil {
+				util.Errorln("[/user/keys]", err.Error())
+				goit.HttpError(w, http.StatusInternalServerError)
+				return
+			} else {
+				util.Infoln("[keys]", user.Name, "added key", key.Fingerprint)
+				http.Redirect(w, r, "/user/keys", http.StatusFound)
+				return
+			}
+
+		case "revoke":
+			if kid, err := strconv.ParseInt(r.FormValue("key"), 10, 64); err != nil {
+				data.Message = "Key is invalid"
+			} else if err := goit.DelSshKey(user.Id, kid); err != nil {
+				util.Errorln("[/user/keys]", err.Error())
+				goit.HttpError(w, http.StatusInternalServerError)
+				return
+			} else {
+				http.Redirect(w, r, "/user/keys", http.StatusFound)
+				return
+			}
+		}
+	}
+
+	keys, err := goit.GetSshKeys(user.Id)
+	if err != nil {
+		util.Errorln("[/user/keys]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	for _, k := range keys {
+		data.Keys = append(data.Keys, row{
+			Id: fmt.Sprint(k.Id), Name: k.Name, Fingerprint: k.Fingerprint, Created: k.Created.Format(time.DateTime),
+		})
+	}
+
+	if err := goit.Tmpl.ExecuteTemplate(w, "user/keys", data); err != nil {
+		util.Errorln("[/user/keys]", err.Error())
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package user_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Jamozed/Goit/src/cron"
+	"github.com/Jamozed/Goit/src/goit"
+	"github.com/Jamozed/Goit/src/user"
+	"golang.org/x/crypto/ssh"
+)
+
+/* Open an empty instance with the users alice and bob, returning a session cookie for each, indexed by user ID. */
+func newTestInstance(t *testing.T) map[int64]*http.Cookie {
+	t.Helper()
+
+	goit.Conf.DataPath = t.TempDir()
+	goit.Cron = cron.New()
+
+	if err := goit.OpenDatabase(filepath.Join(goit.Conf.DataPath, "goit.db")); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	cookies := map[int64]*http.Cookie{}
+	for i, name := range []string{"alice", "bob"} {
+		if err := goit.CreateUser(goit.User{Name: name, Pass: []byte{}, Salt: []byte{}}); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		uid := int64(i + 1)
+		s, err := goit.NewSession(uid, "", time.Now().Add(7*24*time.Hour))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		cookies[uid] = &http.Cookie{Name: "session", Value: fmt.Sprint(uid) + "." + s.Token}
+	}
+
+	return cookies
+}
+
+/* Post a form to the SSH keys page with a session cookie. */
+func postKeys(t *testing.T, cookie *http.Cookie, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodPost, "/user/keys", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(cookie)
+
+	w := httptest.NewRecorder()
+	user.HandleKeys(w, r)
+	return w
+}
+
+func TestHandleKeys(t *testing.T) {
+	cookies := newTestInstance(t)
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	authorized := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+
+	t.Run("add", func(t *testing.T) {
+		w := postKeys(t, cookies[1], url.Values{"action": {"add"}, "name": {"laptop"}, "key": {authorized}})
+		if w.Code != http.StatusFound {
+			t.Fatal("Expected", http.StatusFound, "got", w.Code, w.Body.String())
+		}
+
+		keys, err := goit.GetSshKeys(1)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if len(keys) != 1 || keys[0].Name != "laptop" || keys[0].Fingerprint != ssh.FingerprintSHA256(key) {
+			t.Error("Expected the key laptop, got", keys)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		w := postKeys(t, cookies[2], url.Values{"action": {"add"}, "key": {"ssh-ed25519 invalid"}})
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Key is invalid") {
+			t.Error("Expected the key to be reported invalid, got", w.Code)
+		}
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		/* A key in use by another user is refused, whatever its comment */
+		w := postKeys(t, cookies[2], url.Values{"action": {"add"}, "key": {authorized + " bob@laptop"}})
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Key is already in use") {
+			t.Error("Expected the key to be reported in use, got", w.Code)
+		}
+
+		if keys, err := goit.GetSshKeys(2); err != nil {
+			t.Fatal(err.Error())
+		} else if len(keys) != 0 {
+			t.Error("Expected bob to have no keys, got", keys)
+		}
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		keys, err := goit.GetSshKeys(1)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		/* A key is only revoked by its owner */
+		for _, uid := range []int64{2, 1} {
+			w := postKeys(t, cookies[uid], url.Values{"action": {"revoke"}, "key": {fmt.Sprint(keys[0].Id)}})
+			if w.Code != http.StatusFound {
+				t.Fatal("Expected", http.StatusFound, "got", w.Code)
+			}
+
+			owner, err := goit.GetUserBySshKey(key)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			if uid == 2 && owner == nil {
+				t.Error("Expected the key to remain after bob revoked it")
+			} else if uid == 1 && owner != nil {
+				t.Error("Expected the key to be revoked by alice, got", owner)
+			}
+		}
+	})
+}
@@ -6,18 +6,18 @@ package user
 import (
 	"bytes"
 	"html/template"
-	"log"
 	"net/http"
 	"time"
 
 	"github.com/Jamozed/Goit/src/goit"
+	"github.com/Jamozed/Goit/src/util"
 	"github.com/gorilla/csrf"
 )
 
 func HandleLogin(w http.ResponseWriter, r *http.Request) {
 	auth, _, err := goit.Auth(w, r, true)
 	if err != nil {
-		log.Println("[admin]", err.Error())
+		util.Errorln("[admin]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 	}
 
@@ -49,26 +49,26 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 
 		user, err := goit.GetUserByName(data.Name)
 		if err != nil {
-			log.Println("[/user/login]", err.Error())
+			util.Errorln("[/user/login]", err.Error())
 			goit.HttpError(w, http.StatusInternalServerError)
 			return
 		} else if user == nil || !bytes.Equal(goit.Hash(password, user.Salt), user.Pass) {
 			data.Message = "Invalid credentials"
 			data.FocusPw = true
 
-			log.Println("[login] login attempt with", data.Name, "from", ip)
+			util.Infoln("[login] login attempt with", data.Name, "from", ip)
 
 			goto execute
 		}
 
 		sess, err := goit.NewSession(user.Id, ip, time.Now().Add(2*24*time.Hour))
 		if err != nil {
-			log.Println("[/user/login]", err.Error())
+			util.Errorln("[/user/login]", err.Error())
 			goit.HttpError(w, http.StatusInternalServerError)
 			return
 		}
 
-		log.Println("[login]", user.Name, "logged in from", ip)
+		util.Infoln("[login]", user.Name, "logged in from", ip)
 
 		goit.SetSessionCookie(w, user.Id, sess)
 		http.Redirect(w, r, "/", http.StatusFound)
@@ -77,6 +77,6 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 
 execute:
 	if err := goit.Tmpl.ExecuteTemplate(w, "user/login", data); err != nil {
-		log.Println("[/user/login]", err.Error())
+		util.Errorln("[/user/login]", err.Error())
 	}
 }
@@ -5,7 +5,6 @@ package user
 
 import (
 	"fmt"
-	"log"
 	"net/http"
 	"strconv"
 	"time"
@@ -17,7 +16,7 @@ import (
 func HandleSessions(w http.ResponseWriter, r *http.Request) {
 	auth, user, err := goit.Auth(w, r, true)
 	if err != nil {
-		log.Println("[admin]", err.Error())
+		util.Errorln("[admin]", err.Error())
 		goit.HttpError(w, http.StatusInternalServerError)
 	}
 
@@ -39,17 +38,17 @@ func HandleSessions(w http.ResponseWriter, r *http.Request) {
 		Sessions []row
 	}{Title: "User - Sessions"}
 
-	goit.SessionsMutex.RLock()
-	util.Debugln("[goit.HandleSessions] SessionsMutex rlock")
-
-	if revoke >= 0 && revoke < int64(len(goit.Sessions[user.Id])) {
-		var token = goit.Sessions[user.Id][revoke].Token
-		var current = token == ss.Token
+	sessions, err := goit.GetSessions(user.Id)
+	if err != nil {
+		util.Errorln("[/user/sessions]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
 
-		goit.SessionsMutex.RUnlock()
-		util.Debugln("[goit.HandleSessions] SessionsMutex runlock")
+	if revoke >= 0 && revoke < int64(len(sessions)) {
+		var current = sessions[revoke].Id == ss.Id
 
-		goit.EndSession(user.Id, token)
+		goit.EndSessionById(user.Id, sessions[revoke].Id)
 
 		if current {
 			goit.EndSessionCookie(w)
@@ -61,17 +60,14 @@ func HandleSessions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	for i, v := range goit.Sessions[user.Id] {
+	for i, v := range sessions {
 		data.Sessions = append(data.Sessions, row{
 			Index: fmt.Sprint(i), Ip: v.Ip, Seen: v.Seen.Format(time.DateTime), Expiry: v.Expiry.Format(time.DateTime),
-			Current: util.If(v.Token == ss.Token, "(current)", ""),
+			Current: util.If(v.Id == ss.Id, "(current)", ""),
 		})
 	}
 
-	goit.SessionsMutex.RUnlock()
-	util.Debugln("[goit.HandleSessions] SessionsMutex runlock")
-
 	if err := goit.Tmpl.ExecuteTemplate(w, "user/sessions", data); err != nil {
-		log.Println("[/user/login]", err.Error())
+		util.Errorln("[/user/login]", err.Error())
 	}
 }
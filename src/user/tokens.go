@@ -0,0 +1,113 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package user
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jamozed/Goit/src/goit"
+	"github.com/Jamozed/Goit/src/util"
+	"github.com/gorilla/csrf"
+)
+
+func HandleTokens(w http.ResponseWriter, r *http.Request) {
+	auth, user, err := goit.Auth(w, r, true)
+	if err != nil {
+		util.Errorln("[/user/tokens]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	if !auth {
+		goit.HttpError(w, http.StatusUnauthorized)
+		return
+	}
+
+	type row struct{ Id, Name, Scope, Created, Expiry, LastUsed string }
+	data := struct {
+		Title, Message, Token string
+		Name, Scope, Expiry   string
+		Tokens                []row
+
+		CsrfField template.HTML
+	}{
+		Title: "User - Tokens",
+
+		CsrfField: csrf.TemplateField(r),
+	}
+
+	if r.Method == http.MethodPost {
+		switch r.FormValue("action") {
+		case "create":
+			data.Name = strings.TrimSpace(r.FormValue("name"))
+			data.Scope = r.FormValue("scope")
+			data.Expiry = r.FormValue("expiry")
+
+			scope := goit.AccessFromString(data.Scope)
+			days, derr := strconv.ParseInt(data.Expiry, 10, 64)
+
+			if data.Name == "" {
+				data.Message = "Name cannot be empty"
+			} else if len(data.Name) > 256 {
+				data.Message = "Name cannot exceed 256 characters"
+			} else if scope != goit.AccessRead && scope != goit.AccessWrite {
+				data.Message = "Scope \"" + data.Scope + "\" is invalid"
+			} else if derr != nil || days < 0 {
+				data.Message = "Expiry \"" + data.Expiry + "\" is invalid"
+			} else if days > 365 {
+				data.Message = "Expiry cannot exceed 365 days"
+			} else if t, value, err := goit.NewToken(
+				user.Id, data.Name, scope, util.If(days == 0, time.Time{}, time.Now().AddDate(0, 0, int(days))),
+			); err != nil {
+				util.Errorln("[/user/tokens]", err.Error())
+				goit.HttpError(w, http.StatusInternalServerError)
+				return
+			} else {
+				util.Infoln("[tokens]", user.Name, "created token", t.Id)
+				data.Token = value
+				data.Name, data.Scope, data.Expiry = "", "", ""
+			}
+
+		case "revoke":
+			if tid, err := strconv.ParseInt(r.FormValue("token"), 10, 64); err != nil {
+				data.Message = "Token is invalid"
+			} else if err := goit.DelToken(user.Id, tid); err != nil {
+				util.Errorln("[/user/tokens]", err.Error())
+				goit.HttpError(w, http.StatusInternalServerError)
+				return
+			} else {
+				http.Redirect(w, r, "/user/tokens", http.StatusFound)
+				return
+			}
+		}
+	}
+
+	tokens, err := goit.GetTokens(user.Id)
+	if err != nil {
+		util.Errorln("[/user/tokens]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	for _, t := range tokens {
+		expiry := "never"
+		if !t.Expiry.IsZero() {
+			expiry = t.Expiry.Format(time.DateTime) + util.If(t.Expiry.Before(time.Now()), " (expired)", "")
+		}
+
+		data.Tokens = append(data.Tokens, row{
+			Id: fmt.Sprint(t.Id), Name: t.Name, Scope: t.Scope.String(), Created: t.Created.Format(time.DateTime),
+			Expiry: expiry, LastUsed: util.If(t.LastUsed.IsZero(), "never", t.LastUsed.Format(time.DateTime)),
+		})
+	}
+
+	if err := goit.Tmpl.ExecuteTemplate(w, "user/tokens", data); err != nil {
+		util.Errorln("[/user/tokens]", err.Error())
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright (C) 2024, Jakob Wakeling
+// All rights reserved.
+
+package user
+
+import (
+	"net/http"
+
+	"github.com/Jamozed/Goit/src/goit"
+	"github.com/Jamozed/Goit/src/util"
+	"github.com/gorilla/csrf"
+)
+
+/* Handle the webhooks of a user, which receive events for all of the repositories they own. */
+func HandleWebhooks(w http.ResponseWriter, r *http.Request) {
+	auth, user, err := goit.Auth(w, r, true)
+	if err != nil {
+		util.Errorln("[/user/webhooks]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	if !auth {
+		goit.HttpError(w, http.StatusUnauthorized)
+		return
+	}
+
+	data := struct {
+		Title    string
+		Webhooks goit.WebhookFields
+	}{
+		Title:    "User - Webhooks",
+		Webhooks: goit.WebhookFields{Action: "/user/webhooks", CsrfField: csrf.TemplateField(r)},
+	}
+
+	if r.Method == http.MethodPost {
+		if done, err := goit.WebhookAction(r, user.Id, goit.AllRepos, &data.Webhooks); err != nil {
+			util.Errorln("[/user/webhooks]", err.Error())
+			goit.HttpError(w, http.StatusInternalServerError)
+			return
+		} else if done {
+			http.Redirect(w, r, "/user/webhooks", http.StatusFound)
+			return
+		}
+	}
+
+	if err := data.Webhooks.Load(user.Id, goit.AllRepos, user.IsAdmin); err != nil {
+		util.Errorln("[/user/webhooks]", err.Error())
+		goit.HttpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	if err := goit.Tmpl.ExecuteTemplate(w, "user/webhooks", data); err != nil {
+		util.Errorln("[/user/webhooks]", err.Error())
+	}
+}
@@ -3,14 +3,45 @@
 
 package util
 
-import "log"
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
 
 var Debug = false
 
+/* Log at the debug level, formatting the operands like log.Println. */
 func Debugln(v ...any) {
-	if Debug {
-		var a = []any{"\033[34m[DEBUG]\033[0m"}
-		a = append(a, v...)
-		log.Println(a...)
+	logln(slog.LevelDebug, v...)
+}
+
+/* Log at the info level, formatting the operands like log.Println. */
+func Infoln(v ...any) {
+	logln(slog.LevelInfo, v...)
+}
+
+/* Log at the warn level, formatting the operands like log.Println. */
+func Warnln(v ...any) {
+	logln(slog.LevelWarn, v...)
+}
+
+/* Log at the error level, formatting the operands like log.Println. */
+func Errorln(v ...any) {
+	logln(slog.LevelError, v...)
+}
+
+/* Log at the error level, formatting the operands like log.Println, then exit with status 1. */
+func Fatalln(v ...any) {
+	logln(slog.LevelError, v...)
+	os.Exit(1)
+}
+
+func logln(level slog.Level, v ...any) {
+	ctx := context.Background()
+	if l := slog.Default(); l.Enabled(ctx, level) {
+		l.Log(ctx, level, strings.TrimSuffix(fmt.Sprintln(v...), "\n"))
 	}
 }